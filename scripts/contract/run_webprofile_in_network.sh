@@ -9,9 +9,8 @@ MONGO_URI=${MONGO_URI:-mongodb://mongo:27017/sharelatex}
 
 # Build the image
 REPO_ROOT="$(cd "$(dirname "$0")/../.." && pwd)"
-pushd "${REPO_ROOT}/services/git-bridge/cmd/webprofile-api" >/dev/null
-docker build -t ${IMAGE_TAG} .
-popd >/dev/null
+docker build -t ${IMAGE_TAG} -f "${REPO_ROOT}/services/git-bridge/cmd/webprofile-api/Dockerfile" \
+  --build-context contracts="${REPO_ROOT}/specs/001-ssh-git-auth/contracts" "${REPO_ROOT}/services/git-bridge"
 
 # Remove existing container if present
 docker rm -f ${IMAGE_TAG} >/dev/null 2>&1 || true
@@ -23,6 +22,9 @@ if [ "${PUBLISH_PORT:-false}" = "true" ]; then
 fi
 
 # Run attached to the compose network
-docker run -d --name ${IMAGE_TAG} --network ${NETWORK} -e MONGO_URI="${MONGO_URI}" ${PORT_ARG} ${IMAGE_TAG}
+# Basic auth is opt-in (no default credentials); parity clients use overleaf:overleaf
+docker run -d --name ${IMAGE_TAG} --network ${NETWORK} -e MONGO_URI="${MONGO_URI}" \
+  -e WEBPROFILE_ADMIN_USER="${WEBPROFILE_ADMIN_USER:-overleaf}" -e WEBPROFILE_ADMIN_PASS="${WEBPROFILE_ADMIN_PASS:-overleaf}" \
+  ${PORT_ARG} ${IMAGE_TAG}
 
 echo "Started ${IMAGE_TAG} on network ${NETWORK}. Use http://${IMAGE_TAG}:3900 from other containers in the ${NETWORK} network."
\ No newline at end of file
@@ -31,7 +31,7 @@ if [ "$CMD" = "start" ]; then
   fi
 
   echo "Building webprofile-api Docker image (local)"
-  docker build -f services/git-bridge/cmd/webprofile-api/Dockerfile -t "$IMAGE_NAME" .
+  docker build -f services/git-bridge/cmd/webprofile-api/Dockerfile --build-context contracts=specs/001-ssh-git-auth/contracts -t "$IMAGE_NAME" services/git-bridge
 
   echo "Starting $CONTAINER_NAME on host port $PORT and joining Docker network $NETWORK"
   docker run -d --name "$CONTAINER_NAME" --network "$NETWORK" -p "$PORT":3900 "$IMAGE_NAME"
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/events"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+// recordingPublisher keeps delivered events in order.
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, e events.Event) error {
+	p.events = append(p.events, e)
+	return nil
+}
+
+func TestChatEvents(t *testing.T) {
+	pub := &recordingPublisher{}
+	bus := events.NewBus(events.NewMemoryOutbox(100), pub)
+	c := testClient{t, newRouter(&chatAPI{repo: repository.NewMemory(store.New()), bus: bus})}
+	project := "/project/" + parityProject
+	thread := project + "/thread/" + parityThread
+	user := `"user_id":"` + parityUser + `"`
+
+	global := decodeMessage(t, c.ok("POST", project+"/messages", `{`+user+`,"content":"hello"}`)).ID
+	c.ok("POST", project+"/messages/"+global+"/edit", `{`+user+`,"content":"hello again"}`)
+	c.ok("DELETE", project+"/messages/"+global, "")
+	comment := decodeMessage(t, c.ok("POST", thread+"/messages", `{`+user+`,"content":"a comment"}`)).ID
+	c.ok("POST", thread+"/messages/"+comment+"/edit", `{`+user+`,"content":"an edit"}`)
+	c.ok("DELETE", thread+"/messages/"+comment, "")
+	c.ok("POST", thread+"/resolve", `{`+user+`}`)
+	c.ok("POST", thread+"/reopen", `{`+user+`}`)
+	c.ok("DELETE", thread, "")
+	// a failed write emits nothing
+	c.do("POST", thread+"/resolve", "", 400)
+
+	bus.Dispatch(context.Background(), time.Now())
+
+	want := []string{
+		events.NewChatMessage + ` [{"id":"` + global + `"`,
+		events.EditGlobalMessage + ` ["` + global + `","hello again"]`,
+		events.DeleteGlobalMessage + ` ["` + global + `"]`,
+		events.NewComment + ` ["` + parityThread + `",{"id":"` + comment + `"`,
+		events.EditMessage + ` ["` + parityThread + `","` + comment + `","an edit"]`,
+		events.DeleteMessage + ` ["` + parityThread + `","` + comment + `"]`,
+		events.ResolveThread + ` ["` + parityThread + `",{"user_id":"` + parityUser + `"`,
+		events.ReopenThread + ` ["` + parityThread + `"]`,
+		events.DeleteThread + ` ["` + parityThread + `"]`,
+	}
+	if len(pub.events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), pub.events)
+	}
+	for i, e := range pub.events {
+		payload, _ := json.Marshal(e.Payload)
+		if got := e.Message + " " + string(payload); !strings.HasPrefix(got, want[i]) || e.ProjectID != parityProject {
+			t.Errorf("event %d: expected %s..., got %s for %s", i, want[i], got, e.ProjectID)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+)
+
+// threadWriter writes one export format, one thread at a time.
+type threadWriter interface {
+	writeThread(t repository.Thread) error
+	// close writes whatever follows the last thread.
+	close() error
+}
+
+// exportFormats maps ?format= to the content type, file extension and
+// writer of each export.
+var exportFormats = map[string]struct {
+	contentType, ext string
+	open             func(w io.Writer, projectId string) (threadWriter, error)
+}{
+	"json": {"application/json", "json", openJSONExport},
+	"csv":  {"text/csv; charset=utf-8", "csv", openCSVExport},
+	"md":   {"text/markdown; charset=utf-8", "md", openMarkdownExport},
+}
+
+// exportThreads streams every comment thread of a project, with messages
+// and resolution state, as JSON (the getThreads shape), CSV (one row per
+// message) or Markdown. Threads are flushed to the client as they are read,
+// so nothing is buffered beyond one thread. Once the first thread has been
+// sent, errors can only end the response early, and are logged.
+func (a *chatAPI) exportThreads(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "json"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		writeText(w, http.StatusBadRequest, "Invalid format")
+		return
+	}
+	projectId := r.PathValue("projectId")
+	flusher, _ := w.(http.Flusher)
+	var tw threadWriter
+	start := func() error {
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="comments-%s.%s"`, projectId, format.ext))
+		w.WriteHeader(http.StatusOK)
+		var err error
+		tw, err = format.open(w, projectId)
+		return err
+	}
+	err := a.repo.EachThread(r.Context(), projectId, func(t repository.Thread) error {
+		if len(t.Messages) == 0 {
+			return nil
+		}
+		if tw == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := tw.writeThread(t); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && tw == nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	if err == nil && tw == nil {
+		// a project without threads still gets an empty document
+		err = start()
+	}
+	if err == nil {
+		err = tw.close()
+	}
+	if err != nil {
+		log.Printf("%s %s: export aborted: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// exportTime formats a message timestamp (ms) for CSV and Markdown.
+func exportTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+// jsonExport writes {"<threadId>": threadView, ...}.
+type jsonExport struct {
+	w io.Writer
+	n int
+}
+
+func openJSONExport(w io.Writer, projectId string) (threadWriter, error) {
+	_, err := io.WriteString(w, "{")
+	return &jsonExport{w: w}, err
+}
+
+func (e *jsonExport) writeThread(t repository.Thread) error {
+	key, _ := json.Marshal(t.ID)
+	view, err := json.Marshal(threadViews([]repository.Thread{t})[t.ID])
+	if err != nil {
+		return err
+	}
+	sep := ""
+	if e.n > 0 {
+		sep = ","
+	}
+	e.n++
+	_, err = fmt.Fprintf(e.w, "%s%s:%s", sep, key, view)
+	return err
+}
+
+func (e *jsonExport) close() error {
+	_, err := io.WriteString(e.w, "}\n")
+	return err
+}
+
+// csvExport writes one row per message, with its thread's state repeated.
+type csvExport struct {
+	cw *csv.Writer
+}
+
+var csvExportHeader = []string{
+	"thread_id", "resolved", "resolved_at", "resolved_by_user_id",
+	"message_id", "user_id", "timestamp", "edited_at", "deleted_at", "content",
+}
+
+func openCSVExport(w io.Writer, projectId string) (threadWriter, error) {
+	e := &csvExport{cw: csv.NewWriter(w)}
+	e.cw.Write(csvExportHeader)
+	return e, nil
+}
+
+func (e *csvExport) writeThread(t repository.Thread) error {
+	resolved, resolvedAt, resolvedBy := "false", "", ""
+	if t.Resolved != nil {
+		resolved, resolvedAt, resolvedBy = "true", t.Resolved.At.UTC().Format(time.RFC3339), t.Resolved.UserID
+	}
+	optional := func(ms int64) string {
+		if ms == 0 {
+			return ""
+		}
+		return exportTime(ms)
+	}
+	for _, m := range t.Messages {
+		e.cw.Write([]string{
+			t.ID, resolved, resolvedAt, resolvedBy,
+			m.ID, m.UserID, exportTime(m.Timestamp), optional(m.EditedAt), optional(m.DeletedAt), m.Content,
+		})
+	}
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+func (e *csvExport) close() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// markdownExport writes a section per thread and a list item per message.
+type markdownExport struct {
+	w io.Writer
+}
+
+func openMarkdownExport(w io.Writer, projectId string) (threadWriter, error) {
+	_, err := fmt.Fprintf(w, "# Comment threads of project %s\n", projectId)
+	return &markdownExport{w: w}, err
+}
+
+func (e *markdownExport) writeThread(t repository.Thread) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n## Thread %s\n\n", t.ID)
+	if t.Resolved != nil {
+		fmt.Fprintf(&b, "Resolved by %s at %s.\n\n", t.Resolved.UserID, t.Resolved.At.UTC().Format(time.RFC3339))
+	} else {
+		b.WriteString("Open.\n\n")
+	}
+	for _, m := range t.Messages {
+		note := ""
+		switch {
+		case m.DeletedAt != 0:
+			note = ", deleted " + exportTime(m.DeletedAt)
+		case m.EditedAt != 0:
+			note = ", edited " + exportTime(m.EditedAt)
+		}
+		// continuation lines are indented to stay inside the list item
+		content := strings.ReplaceAll(m.Content, "\n", "\n  ")
+		fmt.Fprintf(&b, "- **%s** (%s%s): %s\n", m.UserID, exportTime(m.Timestamp), note, content)
+	}
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *markdownExport) close() error {
+	return nil
+}
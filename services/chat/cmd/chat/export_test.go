@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+const secondThread = "507f191e810c19729de860eb"
+
+// seedExport creates two threads, the second resolved with a multi-line
+// message.
+func seedExport(t *testing.T, s *store.Store) {
+	t.Helper()
+	base := "/project/" + parityProject + "/thread/"
+	sendTestMessage(t, s, base+parityThread+"/messages", `first, \"quoted\"`)
+	sendTestMessage(t, s, base+secondThread+"/messages", "line one\\nline two")
+	sendTestMessage(t, s, "/project/"+parityProject+"/messages", "global chat is not exported")
+	repository.NewMemory(s).ResolveThread(context.Background(), parityProject, secondThread,
+		repository.Resolution{UserID: otherUser, At: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+}
+
+func exportTest(t *testing.T, s *store.Store, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(rr, httptest.NewRequest("GET", "/project/"+parityProject+"/threads/export?format="+format, nil))
+	if rr.Code != 200 {
+		t.Fatalf("%s: %d %s", format, rr.Code, rr.Body.String())
+	}
+	return rr
+}
+
+func TestExportJSONMatchesThreads(t *testing.T) {
+	s := store.New()
+	seedExport(t, s)
+	rr := exportTest(t, s, "json")
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="comments-`+parityProject+`.json"` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+	var exported, threads map[string]threadView
+	if err := json.Unmarshal(rr.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	list := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(list, httptest.NewRequest("GET", "/project/"+parityProject+"/threads", nil))
+	json.Unmarshal(list.Body.Bytes(), &threads)
+	if len(exported) != 2 || !reflect.DeepEqual(exported, threads) {
+		t.Fatalf("expected the getThreads output, got %s", rr.Body.String())
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	s := store.New()
+	seedExport(t, s)
+	rows, err := csv.NewReader(exportTest(t, s, "csv").Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], csvExportHeader) {
+		t.Fatalf("expected a header and two rows, got %q", rows)
+	}
+	first, second := rows[1], rows[2]
+	if first[0] != parityThread || first[1] != "false" || first[5] != parityUser || first[9] != `first, "quoted"` {
+		t.Fatalf("unexpected row %q", first)
+	}
+	if second[0] != secondThread || second[1] != "true" || second[2] != "2026-01-02T03:04:05Z" || second[3] != otherUser || second[9] != "line one\nline two" {
+		t.Fatalf("unexpected row %q", second)
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	s := store.New()
+	seedExport(t, s)
+	md := exportTest(t, s, "md").Body.String()
+	for _, want := range []string{
+		"# Comment threads of project " + parityProject + "\n",
+		"\n## Thread " + parityThread + "\n\nOpen.\n\n- **" + parityUser + "** (",
+		"): first, \"quoted\"\n",
+		"\n## Thread " + secondThread + "\n\nResolved by " + otherUser + " at 2026-01-02T03:04:05Z.\n",
+		"): line one\n  line two\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("expected %q in\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "global chat") {
+		t.Fatalf("global chat should not be exported:\n%s", md)
+	}
+}
+
+func TestExportEmptyAndInvalid(t *testing.T) {
+	if body := exportTest(t, store.New(), "").Body.String(); body != "{}\n" {
+		t.Fatalf("expected an empty JSON object, got %q", body)
+	}
+	rr := httptest.NewRecorder()
+	memoryRouter(store.New()).ServeHTTP(rr, httptest.NewRequest("GET", "/project/"+parityProject+"/threads/export?format=xml", nil))
+	if rr.Code != 400 || rr.Body.String() != "Invalid format" {
+		t.Fatalf("expected 400 Invalid format, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+// gatedRepository holds EachThread after the first thread until gate is
+// closed.
+type gatedRepository struct {
+	repository.ChatRepository
+	gate chan struct{}
+}
+
+func (g gatedRepository) EachThread(ctx context.Context, projectID string, fn func(repository.Thread) error) error {
+	n := 0
+	return g.ChatRepository.EachThread(ctx, projectID, func(t repository.Thread) error {
+		if n++; n == 2 {
+			<-g.gate
+		}
+		return fn(t)
+	})
+}
+
+// TestExportStreams checks that the first thread reaches the client before
+// the second one has been read.
+func TestExportStreams(t *testing.T) {
+	s := store.New()
+	seedExport(t, s)
+	gate := make(chan struct{})
+	srv := httptest.NewServer(newRouter(&chatAPI{repo: gatedRepository{repository.NewMemory(s), gate}}))
+	defer srv.Close()
+	defer func() {
+		select {
+		case <-gate:
+		default:
+			close(gate)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/project/"+parityProject+"/threads/export?format=md", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer res.Body.Close()
+	lines := bufio.NewScanner(res.Body)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "## Thread "+parityThread) {
+			break
+		}
+	}
+	if lines.Err() != nil || !strings.HasPrefix(lines.Text(), "## Thread") {
+		t.Fatalf("first thread was not streamed: %v", lines.Err())
+	}
+	close(gate)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "## Thread "+secondThread) {
+			return
+		}
+	}
+	t.Fatalf("second thread missing: %v", lines.Err())
+}
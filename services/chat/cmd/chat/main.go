@@ -6,356 +6,113 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/davrot/gogotex_at_work/services/chat/internal/events"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/mentions"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
 	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
-var messagesColl *mongo.Collection
-
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode("chat is alive")
 }
 
-// threadsHandler handles GET /project/{projectId}/threads and returns a simple
-// threads list (read-only, minimal response for parity testing).
-func threadsHandlerWithStore(s *store.Store, w http.ResponseWriter, r *http.Request) {
-	// Expect path: /project/{projectId}/threads
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 || parts[1] != "project" || parts[3] != "threads" {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	projectId := parts[2]
-
-	// POST actions on threads: resolve, reopen, delete, duplicate, generate
-	if r.Method == http.MethodPost {
-		if len(parts) >= 5 {
-			action := parts[4]
-			if action == "duplicate" {
-				// body: { threads: [id, ...] }
-				var body struct{ Threads []string `json:"threads"` }
-				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					w.Write([]byte("Invalid JSON"))
-					return
-				}
-				result := map[string]interface{}{}
-				for _, id := range body.Threads {
-					// duplicate: create new id by appending "-dup" and copy messages
-					srcKey := "messages:" + projectId + ":" + id
-					if val, ok := s.Get(srcKey); ok {
-						newId := id + "-dup"
-						targetKey := "messages:" + projectId + ":" + newId
-						s.Put(targetKey, val)
-						result[id] = map[string]string{"duplicateId": newId}
-					} else {
-						result[id] = map[string]string{"error": "not found"}
-					}
-				}
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusOK)
-				_ = json.NewEncoder(w).Encode(map[string]interface{}{"newThreads": result})
-				return
-			}
-			// generate thread data: mirror Node behaviour by returning grouped messages for provided thread ids
-			if action == "generate" {
-				var body struct{ Threads []string `json:"threads"` }
-				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					w.Write([]byte("Invalid JSON"))
-					return
-				}
-				out := map[string]interface{}{}
-				for _, id := range body.Threads {
-					key := "messages:" + projectId + ":" + id
-					if val, ok := s.Get(key); ok {
-						var arr []map[string]interface{}
-						_ = json.Unmarshal([]byte(val), &arr)
-						out[id] = map[string]interface{}{"messages": arr}
-					} else {
-						out[id] = map[string]interface{}{"messages": []interface{}{}}
-					}
-				}
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusOK)
-				_ = json.NewEncoder(w).Encode(out)
-				return
-			}
-		}
-	}
-
-	// resolve / reopen / delete operations on specific thread id
-	if r.Method == http.MethodPost && len(parts) >= 6 {
-		action := parts[5]
-		threadId := parts[4]
-		if action == "resolve" {
-			// set resolved marker
-			s.Put("resolved:"+projectId+":"+threadId, "1")
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		if action == "reopen" {
-			s.Delete("resolved:"+projectId+":"+threadId)
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		if action == "delete" {
-			s.Delete("messages:"+projectId+":"+threadId)
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-	}
-
-	// check for seeded threads in the in-memory store
-	key := "threads:" + projectId
-	if _, ok := s.Get(key); ok {
-		// Node implementation returns an object mapping threadId -> { messages: [] }
-		// only when messages exist; when there are no messages it returns {}
-		// To match parity, return {} for seeded empty threads (tests seed only thread ids)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{})
-		return
-	}
-
-	// default empty list
-	w.Header().Set("Content-Type", "application/json")
+// readyHandler is a simple readiness endpoint for health checks.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode([]interface{}{})
+	w.Write([]byte("ok"))
 }
 
-// messagesHandlerWithStore handles POST /project/{projectId}/threads/{threadId}/messages
-// and performs minimal validation to match Node controller behavior for parity tests.
-func messagesHandlerWithStore(s *store.Store, w http.ResponseWriter, r *http.Request) {
-	// log for parity debugging
-	log.Printf("messagesHandler: %s %s", r.Method, r.URL.Path)
-	// Expect path: /project/{projectId}/threads/{threadId}/messages or /messages/{messageId}
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 6 || parts[1] != "project" || parts[3] != "threads" || parts[5] != "messages" {
-		w.WriteHeader(http.StatusNotFound)
-		return
+// mongoDatabase returns the database named in uri, like Node's
+// mongoClient.db(), falling back to sharelatex.
+func mongoDatabase(uri string) string {
+	if cs, err := connstring.Parse(uri); err == nil && cs.Database != "" {
+		return cs.Database
 	}
-	projectId := parts[2]
-	threadId := parts[4]
-
-	// POST: create message
-	if r.Method == http.MethodPost {
-		var body struct {
-			UserID string `json:"user_id"`
-			Content string `json:"content"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Header().Set("Content-Type", "text/plain")
-			w.Write([]byte("Invalid JSON"))
-			return
-		}
-
-		// Validate user id (naive ObjectId check: 24 hex chars)
-		if len(body.UserID) != 24 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Header().Set("Content-Type", "text/plain")
-			w.Write([]byte("Invalid userId"))
-			return
-		}
-		if body.Content == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Header().Set("Content-Type", "text/plain")
-			w.Write([]byte("No content provided"))
-			return
-		}
-
-		// Minimal message creation: persist to Mongo if configured; otherwise use in-memory store
-		msg := map[string]interface{}{
-			"_id":      "m1",
-			"content":  body.Content,
-			"user_id":  body.UserID,
-			"timestamp": 1234567890,
-		}
-		if messagesColl != nil {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			doc := map[string]interface{}{
-				"room_id": threadId,
-				"content": body.Content,
-				"user_id": body.UserID,
-				"timestamp": time.Now().Unix(),
-			}
-			if _, err := messagesColl.InsertOne(ctx, doc); err != nil {
-				log.Printf("mongo insert error: %v", err)
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusCreated)
-			_ = json.NewEncoder(w).Encode(msg)
-			return
-		}
-
-		key := "messages:" + projectId + ":" + threadId
-		b, _ := json.Marshal([]interface{}{msg})
-		s.Put(key, string(b))
+	return "sharelatex"
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		_ = json.NewEncoder(w).Encode(msg)
-		return
+// mentionService returns the mention notifier when CHAT_MENTION_NOTIFICATIONS
+// is true, or nil. CHAT_MENTION_USERS, a JSON object of handle to user id,
+// takes precedence over the project members in db. Changes are queued in an
+// outbox (chat_mention_outbox with db, otherwise memory) and applied in the
+// background; start the returned service's Queue with Run.
+func mentionService(db *mongo.Database) *mentions.Service {
+	if os.Getenv("CHAT_MENTION_NOTIFICATIONS") != "true" {
+		return nil
 	}
-
-	// For GET, return stored messages if present
-	if r.Method == http.MethodGet {
-		// query Mongo if configured
-		if messagesColl != nil {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			cur, err := messagesColl.Find(ctx, map[string]interface{}{ "room_id": threadId })
-			if err == nil {
-				var out []map[string]interface{}
-				if err := cur.All(ctx, &out); err == nil {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusOK)
-					_ = json.NewEncoder(w).Encode(out)
-					return
-				}
-			}
-			// fallthrough to empty
-		}
-
-		key := "messages:" + projectId + ":" + threadId
-		if val, ok := s.Get(key); ok {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(val))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode([]interface{}{})
-		return
+	var dir mentions.Directory
+	if raw := os.Getenv("CHAT_MENTION_USERS"); raw != "" {
+		static := mentions.StaticDirectory{}
+		if err := json.Unmarshal([]byte(raw), &static); err != nil {
+			log.Printf("invalid CHAT_MENTION_USERS: %v", err)
+			return nil
+		}
+		dir = static
+	} else if db != nil {
+		dir = mentions.NewMongoDirectory(db)
+	} else {
+		log.Printf("CHAT_MENTION_NOTIFICATIONS needs CHAT_MENTION_USERS or MONGO_URI; mentions are ignored")
+		return nil
 	}
-
-	// New: PUT /project/{pid}/threads/{tid}/messages/{mid} -> edit
-	if r.Method == http.MethodPut || r.Method == http.MethodPatch {
-		// Expect messageId at parts[6]
-		if len(parts) < 7 {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		messageId := parts[6]
-		var body struct{ Content string `json:"content"` }
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Invalid JSON"))
-			return
-		}
-		if body.Content == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("No content provided"))
-			return
-		}
-		// Update in Mongo if present
-		if messagesColl != nil {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			res, err := messagesColl.UpdateOne(ctx, map[string]interface{}{ "_id": messageId, "room_id": threadId }, map[string]interface{}{ "$set": map[string]interface{}{ "content": body.Content, "edited_at": time.Now().Unix() } })
-			if err == nil && res.ModifiedCount == 1 {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		// In-memory store: naive replacement
-		key := "messages:" + projectId + ":" + threadId
-		if val, ok := s.Get(key); ok {
-			var arr []map[string]interface{}
-			_ = json.Unmarshal([]byte(val), &arr)
-			updated := false
-			for i := range arr {
-				if arr[i]["_id"] == messageId {
-					arr[i]["content"] = body.Content
-					updated = true
-					break
-				}
-			}
-			if updated {
-				b, _ := json.Marshal(arr)
-				s.Put(key, string(b))
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
+	url := os.Getenv("NOTIFICATIONS_URL")
+	if url == "" {
+		host := os.Getenv("NOTIFICATIONS_HOST")
+		if host == "" {
+			host = "127.0.0.1"
 		}
-		w.WriteHeader(http.StatusNotFound)
-		return
+		url = "http://" + host + ":3042"
 	}
-
-	// New: DELETE /project/{pid}/threads/{tid}/messages/{mid}
-	if r.Method == http.MethodDelete {
-		if len(parts) < 7 {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		messageId := parts[6]
-		if messagesColl != nil {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			res, err := messagesColl.DeleteOne(ctx, map[string]interface{}{ "_id": messageId, "room_id": threadId })
-			if err == nil && res.DeletedCount == 1 {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		key := "messages:" + projectId + ":" + threadId
-		if val, ok := s.Get(key); ok {
-			var arr []map[string]interface{}
-			_ = json.Unmarshal([]byte(val), &arr)
-			newArr := []map[string]interface{}{}
-			deleted := false
-			for _, m := range arr {
-				if m["_id"] == messageId {
-					deleted = true
-					continue
-				}
-				newArr = append(newArr, m)
-			}
-			if deleted {
-				b, _ := json.Marshal(newArr)
-				s.Put(key, string(b))
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
+	var outbox events.Outbox = events.NewMemoryOutbox(10000)
+	if db != nil {
+		o := events.NewMongoOutboxCollection(db, "chat_mention_outbox")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := o.EnsureIndexes(ctx); err != nil {
+			log.Printf("mention outbox index creation failed: %v", err)
 		}
-		w.WriteHeader(http.StatusNotFound)
-		return
+		outbox = o
 	}
-
-	w.WriteHeader(http.StatusMethodNotAllowed)
+	s := &mentions.Service{Directory: dir, Notifier: mentions.NewHTTPNotifier(url)}
+	s.Queue = events.NewBus(outbox, s)
+	return s
 }
 
-
-func main() {
-	// Optional seeding for tests: JSON map of projectId -> []threadIDs
-	seed := os.Getenv("SEED_THREADS")
-	s := store.New()
-	if seed != "" {
-		var decoded map[string][]string
-		if err := json.Unmarshal([]byte(seed), &decoded); err != nil {
-			log.Printf("invalid SEED_THREADS: %v", err)
-		} else {
-			for pid, threads := range decoded {
-				b, _ := json.Marshal(threads)
-				s.Put("threads:"+pid, string(b))
-			}
+// eventBus publishes to REALTIME_URL, defaulting to the real-time service's
+// internal listener at http://$REALTIME_HOST:3001, through an outbox in db
+// when there is one.
+func eventBus(db *mongo.Database) *events.Bus {
+	url := os.Getenv("REALTIME_URL")
+	if url == "" {
+		host := os.Getenv("REALTIME_HOST")
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		url = "http://" + host + ":3001"
+	}
+	var outbox events.Outbox = events.NewMemoryOutbox(10000)
+	if db != nil {
+		o := events.NewMongoOutbox(db)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := o.EnsureIndexes(ctx); err != nil {
+			log.Printf("outbox index creation failed: %v", err)
 		}
+		outbox = o
 	}
+	return events.NewBus(outbox, events.NewHTTPPublisher(url))
+}
 
-	// Optional MongoDB initialization
+func main() {
+	// MongoDB when configured, otherwise the in-memory store
+	var repo repository.ChatRepository = repository.NewMemory(store.New())
+	var db *mongo.Database
 	if uri := os.Getenv("MONGO_URI"); uri != "" {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -367,37 +124,32 @@ func main() {
 			if err := client.Ping(ctx, nil); err != nil {
 				log.Printf("mongo ping failed: %v", err)
 			} else {
-				messagesColl = client.Database("chat").Collection("messages")
+				db = client.Database(mongoDatabase(uri))
+				m := repository.NewMongo(db)
+				if err := m.EnsureIndexes(ctx); err != nil {
+					log.Printf("mongo index creation failed: %v", err)
+				}
+				repo = m
 				log.Printf("connected to Mongo at %s", uri)
 			}
 		}
 	}
 
+	go runRevisionPurge(context.Background(), repo)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3011"
 	}
 	addr := ":" + port
-	mux := http.NewServeMux()
-	mux.HandleFunc("/status", statusHandler)
-	mux.HandleFunc("/project/", func(w http.ResponseWriter, r *http.Request) {
-		// inject store into closure for handlers
-		// route messages endpoint to messagesHandler; otherwise threads
-		if strings.Contains(r.URL.Path, "/messages") {
-			messagesHandlerWithStore(s, w, r)
-			return
-		}
-		threadsHandlerWithStore(s, w, r)
-	})
-
-	// simple readiness endpoint for health checks
-	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
-
 	log.Printf("chat service listening on %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	api := &chatAPI{repo: repo, mentions: mentionService(db), bus: eventBus(db)}
+	poll := time.Duration(max(1, envInt("CHAT_OUTBOX_POLL_SECONDS", 2))) * time.Second
+	go api.bus.Run(context.Background(), poll)
+	if api.mentions != nil {
+		go api.mentions.Queue.Run(context.Background(), poll)
+	}
+	if err := http.ListenAndServe(addr, newRouter(api)); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
@@ -24,3 +24,16 @@ func TestStatusHandler(t *testing.T) {
 		t.Fatalf("unexpected body: %q", body)
 	}
 }
+
+func TestMentionServiceIsOptIn(t *testing.T) {
+	t.Setenv("CHAT_MENTION_USERS", `{"alice":"u-alice"}`)
+	t.Setenv("CHAT_MENTION_NOTIFICATIONS", "")
+	if s := mentionService(nil); s != nil {
+		t.Fatalf("expected mentions to be off by default, got %+v", s)
+	}
+	t.Setenv("CHAT_MENTION_NOTIFICATIONS", "true")
+	s := mentionService(nil)
+	if s == nil || s.Queue == nil {
+		t.Fatalf("expected a queued mention service, got %+v", s)
+	}
+}
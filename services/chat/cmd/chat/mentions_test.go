@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/mentions"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+// recordingNotifier keeps the current notifications by user and key.
+type recordingNotifier struct {
+	mu    sync.Mutex
+	notes map[string]mentions.Notification
+}
+
+func (n *recordingNotifier) Add(ctx context.Context, userID string, note mentions.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notes[userID+" "+note.Key] = note
+	return nil
+}
+
+func (n *recordingNotifier) Remove(ctx context.Context, userID, key string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.notes, userID+" "+key)
+	return nil
+}
+
+func TestMentionNotifications(t *testing.T) {
+	notifier := &recordingNotifier{notes: map[string]mentions.Notification{}}
+	c := testClient{t, newRouter(&chatAPI{
+		repo:     repository.NewMemory(store.New()),
+		mentions: &mentions.Service{Directory: mentions.StaticDirectory{"other": otherUser}, Notifier: notifier},
+	})}
+	thread := "/project/" + parityProject + "/thread/" + parityThread
+	id := decodeMessage(t, c.ok("POST", thread+"/messages", `{"user_id":"`+parityUser+`","content":"what do you think, @other?"}`)).ID
+	key := otherUser + " " + mentions.NotificationKey(id)
+
+	if n, ok := notifier.notes[key]; !ok || n.MessageOpts["messageId"] != id || n.MessageOpts["projectId"] != parityProject {
+		t.Fatalf("expected a mention notification, got %+v", notifier.notes)
+	}
+	c.ok("POST", thread+"/messages/"+id+"/edit", `{"content":"never mind"}`)
+	if len(notifier.notes) != 0 {
+		t.Fatalf("expected the notification to be removed, got %+v", notifier.notes)
+	}
+	c.ok("POST", thread+"/messages/"+id+"/edit", `{"content":"@other after all"}`)
+	if _, ok := notifier.notes[key]; !ok {
+		t.Fatalf("expected the notification to return, got %+v", notifier.notes)
+	}
+	c.ok("DELETE", thread+"/messages/"+id, "")
+	if len(notifier.notes) != 0 {
+		t.Fatalf("expected deleting to remove the notification, got %+v", notifier.notes)
+	}
+}
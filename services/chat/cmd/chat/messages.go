@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/events"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/mentions"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 100
+	maxMessageLength    = 10 * 1024 // 10kb, about 1,500 words
+)
+
+// chatAPI holds the HTTP handlers. All storage goes through repo. When set,
+// mentions keeps mention notifications in step with messages and bus
+// publishes changes to the real-time service.
+type chatAPI struct {
+	repo     repository.ChatRepository
+	mentions *mentions.Service
+	bus      *events.Bus
+}
+
+// emit publishes a change to the project's editor clients.
+func (a *chatAPI) emit(r *http.Request, message string, payload ...interface{}) {
+	if a.bus != nil {
+		a.bus.Emit(r.Context(), r.PathValue("projectId"), message, payload...)
+	}
+}
+
+// writeRepoError maps repository errors to the Node controller's responses.
+// ErrInvalidID is only reachable with Mongo through the older free-form id
+// paths; the Node paths validate ids up front.
+func writeRepoError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidID):
+		writeText(w, http.StatusBadRequest, invalidIDMessage(r.PathValue("projectId")))
+	case errors.Is(err, repository.ErrTooManyReactions):
+		writeText(w, http.StatusConflict, "Too many reactions")
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		writeInternalError(w, err)
+	}
+}
+
+func invalidIDMessage(projectId string) string {
+	if !primitive.IsValidObjectID(projectId) {
+		return "Invalid projectId"
+	}
+	return "Invalid threadId"
+}
+
+func (a *chatAPI) sendGlobalMessage(w http.ResponseWriter, r *http.Request) {
+	a.sendMessage(w, r, repository.GlobalThread)
+}
+
+func (a *chatAPI) sendThreadMessage(w http.ResponseWriter, r *http.Request) {
+	a.sendMessage(w, r, r.PathValue("threadId"))
+}
+
+// sendMessage validates and stores a new message. Errors are plain text, as
+// in Node's MessageHttpController._sendMessage.
+func (a *chatAPI) sendMessage(w http.ResponseWriter, r *http.Request, threadId string) {
+	var body struct {
+		UserID  string `json:"user_id"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeText(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if !primitive.IsValidObjectID(body.UserID) {
+		writeText(w, http.StatusBadRequest, "Invalid userId")
+		return
+	}
+	if body.Content == "" {
+		writeText(w, http.StatusBadRequest, "No content provided")
+		return
+	}
+	if len(body.Content) > maxMessageLength {
+		writeText(w, http.StatusBadRequest, fmt.Sprintf("Content too long (> %d bytes)", maxMessageLength))
+		return
+	}
+
+	projectId := r.PathValue("projectId")
+	msg := repository.Message{
+		ID:        primitive.NewObjectID().Hex(),
+		Content:   body.Content,
+		UserID:    body.UserID,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err := a.repo.CreateMessage(r.Context(), projectId, threadId, msg); err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	if a.mentions != nil {
+		a.mentions.MessageCreated(r.Context(), projectId, threadId, msg)
+	}
+	if threadId == repository.GlobalThread {
+		a.emit(r, events.NewChatMessage, msg)
+	} else {
+		a.emit(r, events.NewComment, threadId, msg)
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		repository.Message
+		RoomID string `json:"room_id"`
+	}{msg, projectId})
+}
+
+// pageQuery reads Node's ?before=<timestamp ms>&limit=<n> query. The limit
+// defaults to defaultMessageLimit and is capped at maxMessageLimit. To page
+// back, pass the oldest timestamp seen as the next before.
+func pageQuery(r *http.Request) (repository.Page, error) {
+	page := repository.Page{Limit: defaultMessageLimit}
+	q := r.URL.Query()
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before <= 0 {
+			return page, errors.New("Invalid before")
+		}
+		page.Before = before
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return page, errors.New("Invalid limit")
+		}
+		page.Limit = min(limit, maxMessageLimit)
+	}
+	return page, nil
+}
+
+// getGlobalMessages returns one page of project chat, newest first, which is
+// the order Node returns and the web client expects.
+func (a *chatAPI) getGlobalMessages(w http.ResponseWriter, r *http.Request) {
+	page, err := pageQuery(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msgs, err := a.repo.ListMessages(r.Context(), r.PathValue("projectId"), repository.GlobalThread, page)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	slices.Reverse(msgs)
+	writeJSON(w, http.StatusOK, nonNil(msgs))
+}
+
+// listThreadMessages returns one page of a thread's messages, oldest first.
+func (a *chatAPI) listThreadMessages(w http.ResponseWriter, r *http.Request) {
+	page, err := pageQuery(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msgs, err := a.repo.ListMessages(r.Context(), r.PathValue("projectId"), r.PathValue("threadId"), page)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(msgs))
+}
+
+func (a *chatAPI) getGlobalMessage(w http.ResponseWriter, r *http.Request) {
+	a.getMessage(w, r, repository.GlobalThread)
+}
+
+func (a *chatAPI) getThreadMessage(w http.ResponseWriter, r *http.Request) {
+	a.getMessage(w, r, r.PathValue("threadId"))
+}
+
+func (a *chatAPI) getMessage(w http.ResponseWriter, r *http.Request, threadId string) {
+	m, err := a.repo.GetMessage(r.Context(), r.PathValue("projectId"), threadId, r.PathValue("messageId"))
+	if errors.Is(err, repository.ErrInvalidID) {
+		// a malformed message id cannot match anything
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (a *chatAPI) editGlobalMessage(w http.ResponseWriter, r *http.Request) {
+	a.editMessage(w, r, repository.GlobalThread)
+}
+
+func (a *chatAPI) editThreadMessage(w http.ResponseWriter, r *http.Request) {
+	a.editMessage(w, r, r.PathValue("threadId"))
+}
+
+// editMessage replaces a message's content; the old content is kept as a
+// revision. When the body names a userId, only that user's message is
+// edited. Anything else, including deleted messages, is a 404.
+func (a *chatAPI) editMessage(w http.ResponseWriter, r *http.Request, threadId string) {
+	var body struct {
+		Content string `json:"content"`
+		UserID  string `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeText(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if body.Content == "" {
+		writeText(w, http.StatusBadRequest, "No content provided")
+		return
+	}
+	projectId, messageId := r.PathValue("projectId"), r.PathValue("messageId")
+	var before repository.Message
+	if a.mentions != nil {
+		before, _ = a.repo.GetMessage(r.Context(), projectId, threadId, messageId)
+	}
+	editedAt := time.Now().UnixMilli()
+	err := a.repo.UpdateMessage(r.Context(), projectId, threadId, messageId, body.UserID, body.Content, editedAt)
+	if errors.Is(err, repository.ErrInvalidID) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	if a.mentions != nil {
+		after := before
+		after.Content, after.EditedAt = body.Content, editedAt
+		a.mentions.MessageEdited(r.Context(), projectId, threadId, before, after)
+	}
+	if threadId == repository.GlobalThread {
+		a.emit(r, events.EditGlobalMessage, messageId, body.Content)
+	} else {
+		a.emit(r, events.EditMessage, threadId, messageId, body.Content)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Node deletes are idempotent: a missing message still answers 204.
+
+func (a *chatAPI) deleteGlobalMessage(w http.ResponseWriter, r *http.Request) {
+	a.deleteMessage(w, r, repository.GlobalThread, "")
+}
+
+func (a *chatAPI) deleteThreadMessage(w http.ResponseWriter, r *http.Request) {
+	a.deleteMessage(w, r, r.PathValue("threadId"), "")
+}
+
+func (a *chatAPI) deleteUserMessage(w http.ResponseWriter, r *http.Request) {
+	a.deleteMessage(w, r, r.PathValue("threadId"), r.PathValue("userId"))
+}
+
+func (a *chatAPI) deleteMessage(w http.ResponseWriter, r *http.Request, threadId, userId string) {
+	err := a.tombstone(r, threadId, userId)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) && !errors.Is(err, repository.ErrInvalidID) {
+		writeRepoError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// legacyDeleteMessage keeps the older path's 404 for unknown or already
+// deleted messages.
+func (a *chatAPI) legacyDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	err := a.tombstone(r, r.PathValue("threadId"), "")
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tombstone deletes the request's message, leaving a tombstone that lists
+// as "message deleted", and withdraws its mention notifications.
+func (a *chatAPI) tombstone(r *http.Request, threadId, userId string) error {
+	projectId, messageId := r.PathValue("projectId"), r.PathValue("messageId")
+	var before repository.Message
+	if a.mentions != nil {
+		before, _ = a.repo.GetMessage(r.Context(), projectId, threadId, messageId)
+	}
+	if err := a.repo.DeleteMessage(r.Context(), projectId, threadId, messageId, userId, time.Now().UnixMilli()); err != nil {
+		return err
+	}
+	if a.mentions != nil {
+		a.mentions.MessageDeleted(r.Context(), projectId, threadId, before)
+	}
+	if threadId == repository.GlobalThread {
+		a.emit(r, events.DeleteGlobalMessage, messageId)
+	} else {
+		a.emit(r, events.DeleteMessage, threadId, messageId)
+	}
+	return nil
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(msgs []repository.Message) []repository.Message {
+	if msgs == nil {
+		return []repository.Message{}
+	}
+	return msgs
+}
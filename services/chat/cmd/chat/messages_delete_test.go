@@ -9,25 +9,32 @@ import (
 
 func TestDeleteMessage(t *testing.T) {
 	s := store.New()
-	m := []map[string]interface{}{{"_id": "m1", "content": "hello"}}
+	m := []map[string]interface{}{{"id": "m1", "content": "hello"}}
 	b, _ := json.Marshal(m)
 	s.Put("messages:abc:t1", string(b))
 
 	// delete
 	req := httptest.NewRequest("DELETE", "/project/abc/threads/t1/messages/m1", nil)
 	w := httptest.NewRecorder()
-	messagesHandlerWithStore(s, w, req)
+	memoryRouter(s).ServeHTTP(w, req)
 	if w.Result().StatusCode != 204 {
 		t.Fatalf("expected 204 on delete, got %d", w.Result().StatusCode)
 	}
 
-	// verify empty
+	// verify tombstone
 	req2 := httptest.NewRequest("GET", "/project/abc/threads/t1/messages", nil)
 	w2 := httptest.NewRecorder()
-	messagesHandlerWithStore(s, w2, req2)
+	memoryRouter(s).ServeHTTP(w2, req2)
 	var out []map[string]interface{}
 	_ = json.NewDecoder(w2.Body).Decode(&out)
-	if len(out) != 0 {
-		t.Fatalf("expected empty messages after delete, got %+v", out)
+	if len(out) != 1 || out[0]["content"] != "message deleted" || out[0]["deleted_at"] == nil {
+		t.Fatalf("expected a tombstone after delete, got %+v", out)
+	}
+
+	// deleting a tombstone again is a 404 on this path
+	w3 := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(w3, httptest.NewRequest("DELETE", "/project/abc/threads/t1/messages/m1", nil))
+	if w3.Result().StatusCode != 404 {
+		t.Fatalf("expected 404 on second delete, got %d", w3.Result().StatusCode)
 	}
 }
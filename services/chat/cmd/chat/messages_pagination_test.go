@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seedMessages stores n messages with timestamps 1..n.
+func seedMessages(t *testing.T, s *store.Store, threadId string, n int) {
+	t.Helper()
+	repo := repository.NewMemory(s)
+	for i := 1; i <= n; i++ {
+		m := repository.Message{ID: primitive.NewObjectID().Hex(), Content: fmt.Sprint(i), UserID: parityUser, Timestamp: int64(i)}
+		if err := repo.CreateMessage(context.Background(), parityProject, threadId, m); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+}
+
+func timestamps(msgs []repository.Message) []int64 {
+	out := make([]int64, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Timestamp
+	}
+	return out
+}
+
+func TestThreadMessagePages(t *testing.T) {
+	s := store.New()
+	seedMessages(t, s, parityThread, 120)
+	base := "/project/" + parityProject + "/threads/" + parityThread + "/messages"
+
+	cases := []struct {
+		query       string
+		first, last int64
+		count       int
+	}{
+		{"", 71, 120, defaultMessageLimit},
+		{"?limit=5", 116, 120, 5},
+		{"?limit=5&before=116", 111, 115, 5},
+		{"?before=3", 1, 2, 2},
+		{"?limit=1000", 21, 120, maxMessageLimit},
+	}
+	for _, tc := range cases {
+		got := timestamps(listTestMessages(t, s, base+tc.query))
+		if len(got) != tc.count || got[0] != tc.first || got[len(got)-1] != tc.last {
+			t.Fatalf("%s: got %d messages %v", tc.query, len(got), got)
+		}
+	}
+}
+
+func TestGlobalMessagePagesAreNewestFirst(t *testing.T) {
+	s := store.New()
+	seedMessages(t, s, repository.GlobalThread, 10)
+	got := timestamps(listTestMessages(t, s, "/project/"+parityProject+"/messages?limit=3&before=9"))
+	if fmt.Sprint(got) != "[8 7 6]" {
+		t.Fatalf("expected [8 7 6], got %v", got)
+	}
+}
+
+func TestInvalidPageQuery(t *testing.T) {
+	for _, query := range []string{"?limit=0", "?limit=x", "?before=-1", "?before=soon"} {
+		rr := httptest.NewRecorder()
+		memoryRouter(store.New()).ServeHTTP(rr, httptest.NewRequest("GET", "/project/"+parityProject+"/messages"+query, nil))
+		if rr.Code != 400 {
+			t.Fatalf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func sendTestMessage(t *testing.T, s *store.Store, path, content string) repository.Message {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewBufferString(`{"user_id":"`+parityUser+`","content":"`+content+`"}`)))
+	if rr.Code != 201 {
+		t.Fatalf("POST %s: %d %s", path, rr.Code, rr.Body.String())
+	}
+	var m repository.Message
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return m
+}
+
+func listTestMessages(t *testing.T, s *store.Store, path string) []repository.Message {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	var out []repository.Message
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("GET %s: decode %q: %v", path, rr.Body.String(), err)
+	}
+	return out
+}
+
+func TestMessagesAreAppendedWithUniqueIds(t *testing.T) {
+	s := store.New()
+	before := time.Now().UnixMilli()
+	first := sendTestMessage(t, s, "/project/abc/threads/t1/messages", "first")
+	second := sendTestMessage(t, s, "/project/abc/threads/t1/messages", "second")
+
+	for _, m := range []repository.Message{first, second} {
+		if !primitive.IsValidObjectID(m.ID) {
+			t.Fatalf("expected an ObjectId message id, got %q", m.ID)
+		}
+		if m.Timestamp < before || m.Timestamp > time.Now().UnixMilli() {
+			t.Fatalf("expected a current millisecond timestamp, got %d", m.Timestamp)
+		}
+	}
+	if first.ID == second.ID {
+		t.Fatalf("expected unique ids, both are %q", first.ID)
+	}
+
+	out := listTestMessages(t, s, "/project/abc/threads/t1/messages")
+	if len(out) != 2 || out[0].Content != "first" || out[1].Content != "second" {
+		t.Fatalf("expected both messages in order, got %+v", out)
+	}
+}
+
+func TestMessagesAreScopedByProject(t *testing.T) {
+	s := store.New()
+	sendTestMessage(t, s, "/project/p1/threads/t1/messages", "in p1")
+	sendTestMessage(t, s, "/project/p2/threads/t1/messages", "in p2")
+	sendTestMessage(t, s, "/project/"+parityProject+"/messages", "global")
+
+	out := listTestMessages(t, s, "/project/p1/threads/t1/messages")
+	if len(out) != 1 || out[0].Content != "in p1" {
+		t.Fatalf("expected only p1's message, got %+v", out)
+	}
+	if out := listTestMessages(t, s, "/project/"+parityProject+"/messages"); len(out) != 1 {
+		t.Fatalf("expected one global message, got %+v", out)
+	}
+}
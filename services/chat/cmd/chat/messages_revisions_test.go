@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+func TestEditHistoryAndTombstones(t *testing.T) {
+	s := store.New()
+	c := testClient{t, memoryRouter(s)}
+	thread := "/project/" + parityProject + "/thread/" + parityThread
+	msg := sendTestMessage(t, s, thread+"/messages", "first draft")
+	path := thread + "/messages/" + msg.ID
+
+	c.do("POST", path+"/edit", `{"content":"second draft","userId":"`+parityUser+`"}`, 204)
+	c.do("POST", path+"/edit", `{"content":"final"}`, 204)
+	c.do("DELETE", path, "", 204)
+
+	var tomb repository.Message
+	json.Unmarshal(c.do("GET", path, "", 200).Body.Bytes(), &tomb)
+	if tomb.Content != repository.DeletedContent || tomb.DeletedAt == 0 || tomb.Timestamp != msg.Timestamp {
+		t.Fatalf("expected a tombstone, got %+v", tomb)
+	}
+
+	var revs []repository.Revision
+	json.Unmarshal(c.do("GET", path+"/revisions", "", 200).Body.Bytes(), &revs)
+	if len(revs) != 3 || revs[0].Content != "first draft" || revs[1].Content != "second draft" || revs[2].Content != "final" {
+		t.Fatalf("expected three revisions, got %+v", revs)
+	}
+	if revs[0].Timestamp != msg.Timestamp || revs[2].ReplacedAt != tomb.DeletedAt {
+		t.Fatalf("unexpected revision times %+v", revs)
+	}
+
+	// the retention purge drops revisions older than the cutoff
+	purgeRevisions(context.Background(), repository.NewMemory(s), time.Now().Add(time.Minute))
+	revs = nil
+	json.Unmarshal(c.do("GET", path+"/revisions", "", 200).Body.Bytes(), &revs)
+	if len(revs) != 0 {
+		t.Fatalf("expected revisions to be purged, got %+v", revs)
+	}
+	if c.do("GET", path, "", 200).Body.Len() == 0 {
+		t.Fatal("expected the tombstone to outlive its revisions")
+	}
+}
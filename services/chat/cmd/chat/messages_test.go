@@ -14,7 +14,7 @@ func TestSendMessageValidation(t *testing.T) {
 	// missing content
 	req := httptest.NewRequest("POST", "/project/abc/threads/t1/messages", bytes.NewBufferString(`{"user_id":"507f1f77bcf86cd799439011"}`))
 	w := httptest.NewRecorder()
-	messagesHandlerWithStore(s, w, req)
+	memoryRouter(s).ServeHTTP(w, req)
 	res := w.Result()
 	if res.StatusCode != http.StatusBadRequest {
 		t.Fatalf("expected 400 for missing content, got %d", res.StatusCode)
@@ -23,7 +23,7 @@ func TestSendMessageValidation(t *testing.T) {
 	// invalid user id
 	req2 := httptest.NewRequest("POST", "/project/abc/threads/t1/messages", bytes.NewBufferString(`{"user_id":"bad","content":"hello"}`))
 	w2 := httptest.NewRecorder()
-	messagesHandlerWithStore(s, w2, req2)
+	memoryRouter(s).ServeHTTP(w2, req2)
 	res2 := w2.Result()
 	if res2.StatusCode != http.StatusBadRequest {
 		t.Fatalf("expected 400 for invalid user id, got %d", res2.StatusCode)
@@ -32,7 +32,7 @@ func TestSendMessageValidation(t *testing.T) {
 	// happy path
 	req3 := httptest.NewRequest("POST", "/project/abc/threads/t1/messages", bytes.NewBufferString(`{"user_id":"507f1f77bcf86cd799439011","content":"hello"}`))
 	w3 := httptest.NewRecorder()
-	messagesHandlerWithStore(s, w3, req3)
+	memoryRouter(s).ServeHTTP(w3, req3)
 	res3 := w3.Result()
 	if res3.StatusCode != http.StatusCreated {
 		t.Fatalf("expected 201 for created message, got %d", res3.StatusCode)
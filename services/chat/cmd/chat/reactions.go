@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxEmojiLength fits the longest emoji ZWJ sequences and :shortcodes:.
+const maxEmojiLength = 64
+
+// shortcodeRe matches :shortcodes: such as :+1: or :thumbs_up:.
+var shortcodeRe = regexp.MustCompile(`^:[a-z0-9_+-]{1,32}:$`)
+
+// pictographs are the code points an emoji sequence is built around: the
+// symbol and pictograph blocks plus the older symbols with emoji presentation.
+var pictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x203c, Stride: 1},
+		{Lo: 0x2049, Hi: 0x2049, Stride: 1},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x21ff, Stride: 1},
+		{Lo: 0x2300, Hi: 0x23ff, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25a0, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2900, Hi: 0x297f, Stride: 1},
+		{Lo: 0x2b00, Hi: 0x2bff, Stride: 1},
+		{Lo: 0x3030, Hi: 0x303d, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+// emojiModifier reports whether r may follow or join pictographs in an emoji
+// sequence: ZWJ, variation selectors, the keycap mark and tag characters.
+// Skin tones and regional indicators are in pictographs' 0x1f000 block.
+func emojiModifier(r rune) bool {
+	return r == 0x200d || r == 0xfe0e || r == 0xfe0f || r == 0x20e3 || (r >= 0xe0020 && r <= 0xe007f)
+}
+
+// validEmoji accepts a :shortcode: or an emoji sequence: pictographs joined
+// by modifiers, or a keycap such as 1️⃣.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	if shortcodeRe.MatchString(emoji) {
+		return true
+	}
+	pictograph, keycapBase, keycap := false, false, false
+	for i, r := range emoji {
+		switch {
+		case unicode.Is(pictographs, r):
+			pictograph = true
+		case r == 0x20e3:
+			keycap = true
+		case emojiModifier(r):
+		case i == 0 && (r == '#' || r == '*' || (r >= '0' && r <= '9')):
+			keycapBase = true
+		default:
+			return false
+		}
+	}
+	if keycapBase {
+		return keycap
+	}
+	return pictograph
+}
+
+func (a *chatAPI) addGlobalReaction(w http.ResponseWriter, r *http.Request) {
+	a.addReaction(w, r, repository.GlobalThread)
+}
+
+func (a *chatAPI) addThreadReaction(w http.ResponseWriter, r *http.Request) {
+	a.addReaction(w, r, r.PathValue("threadId"))
+}
+
+// addReaction adds {user_id, emoji} to a message and returns the message
+// with its reaction counts. Reacting twice with the same emoji is a no-op.
+func (a *chatAPI) addReaction(w http.ResponseWriter, r *http.Request, threadId string) {
+	var body struct {
+		UserID string `json:"user_id"`
+		Emoji  string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeText(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	a.react(w, r, threadId, body.UserID, body.Emoji, a.repo.AddReaction)
+}
+
+func (a *chatAPI) removeGlobalReaction(w http.ResponseWriter, r *http.Request) {
+	a.removeReaction(w, r, repository.GlobalThread)
+}
+
+func (a *chatAPI) removeThreadReaction(w http.ResponseWriter, r *http.Request) {
+	a.removeReaction(w, r, r.PathValue("threadId"))
+}
+
+// removeReaction takes the emoji and user from the path and returns the
+// message with its remaining reaction counts.
+func (a *chatAPI) removeReaction(w http.ResponseWriter, r *http.Request, threadId string) {
+	a.react(w, r, threadId, r.PathValue("userId"), r.PathValue("emoji"), a.repo.RemoveReaction)
+}
+
+type reactFunc func(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error
+
+func (a *chatAPI) react(w http.ResponseWriter, r *http.Request, threadId, userId, emoji string, change reactFunc) {
+	if !primitive.IsValidObjectID(userId) {
+		writeText(w, http.StatusBadRequest, "Invalid userId")
+		return
+	}
+	if !validEmoji(emoji) {
+		writeText(w, http.StatusBadRequest, "Invalid emoji")
+		return
+	}
+	projectId, messageId := r.PathValue("projectId"), r.PathValue("messageId")
+	err := change(r.Context(), projectId, threadId, messageId, userId, emoji)
+	if errors.Is(err, repository.ErrInvalidID) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	a.getMessage(w, r, threadId)
+}
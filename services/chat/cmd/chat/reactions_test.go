@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+func TestReactions(t *testing.T) {
+	s := store.New()
+	c := testClient{t, memoryRouter(s)}
+	thread := "/project/" + parityProject + "/thread/" + parityThread
+	msg := sendTestMessage(t, s, thread+"/messages", "looks good")
+	reactions := thread + "/messages/" + msg.ID + "/reactions"
+
+	c.do("POST", reactions, `{"user_id":"`+parityUser+`","emoji":"👍"}`, 200)
+	c.do("POST", reactions, `{"user_id":"`+parityUser+`","emoji":"👍"}`, 200)
+	got := decodeMessage(t, c.do("POST", reactions, `{"user_id":"`+otherUser+`","emoji":"👍"}`, 200))
+	want := []repository.Reaction{{Emoji: "👍", Count: 2, UserIDs: []string{parityUser, otherUser}}}
+	if !reflect.DeepEqual(got.Reactions, want) {
+		t.Fatalf("expected one 👍 per user, got %+v", got.Reactions)
+	}
+
+	got = decodeMessage(t, c.do("DELETE", reactions+"/"+url.PathEscape("👍")+"/user/"+parityUser, "", 200))
+	if len(got.Reactions) != 1 || got.Reactions[0].Count != 1 || got.Reactions[0].UserIDs[0] != otherUser {
+		t.Fatalf("expected only the other user's 👍, got %+v", got.Reactions)
+	}
+	if listed := listTestMessages(t, s, "/project/"+parityProject+"/threads/"+parityThread+"/messages"); listed[0].Reactions[0].Count != 1 {
+		t.Fatalf("expected counts in message lists, got %+v", listed)
+	}
+
+	c.do("POST", reactions, `{"user_id":"`+parityUser+`","emoji":"two words"}`, 400)
+	for i := 0; i < repository.MaxEmojisPerUser-1; i++ {
+		c.do("POST", reactions, `{"user_id":"`+parityUser+`","emoji":"`+string(rune(0x1F600+i))+`"}`, 200)
+	}
+	c.do("POST", reactions, `{"user_id":"`+parityUser+`","emoji":"👍"}`, 200)
+	c.do("POST", reactions, `{"user_id":"`+parityUser+`","emoji":"🎉"}`, 409)
+	c.do("POST", reactions, `{"user_id":"nope","emoji":"👍"}`, 400)
+	c.do("POST", thread+"/messages/000000000000000000000000/reactions", `{"user_id":"`+parityUser+`","emoji":"👍"}`, 404)
+
+	global := sendTestMessage(t, s, "/project/"+parityProject+"/messages", "hi all")
+	got = decodeMessage(t, c.do("POST", "/project/"+parityProject+"/messages/"+global.ID+"/reactions", `{"user_id":"`+parityUser+`","emoji":":wave:"}`, 200))
+	if len(got.Reactions) != 1 || got.Reactions[0].Emoji != ":wave:" {
+		t.Fatalf("expected a reaction on the global message, got %+v", got.Reactions)
+	}
+}
+
+func TestValidEmoji(t *testing.T) {
+	for _, e := range []string{"👍", "👍🏽", "❤️", "👩‍💻", "🇩🇪", "1️⃣", "©️", ":wave:", ":+1:", ":thumbs_up:"} {
+		if !validEmoji(e) {
+			t.Errorf("validEmoji(%q) = false", e)
+		}
+	}
+	for _, e := range []string{"", "a", "ok", "two words", "1", "👍a", "<script>", ":Wave:", "::", ":wave", "\u200d"} {
+		if validEmoji(e) {
+			t.Errorf("validEmoji(%q) = true", e)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+)
+
+func (a *chatAPI) getGlobalMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	a.getMessageRevisions(w, r, repository.GlobalThread)
+}
+
+func (a *chatAPI) getThreadMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	a.getMessageRevisions(w, r, r.PathValue("threadId"))
+}
+
+// getMessageRevisions lists the earlier versions of a message, oldest first.
+// The current version is the message itself.
+func (a *chatAPI) getMessageRevisions(w http.ResponseWriter, r *http.Request, threadId string) {
+	revs, err := a.repo.MessageRevisions(r.Context(), r.PathValue("projectId"), threadId, r.PathValue("messageId"))
+	if errors.Is(err, repository.ErrInvalidID) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	if revs == nil {
+		revs = []repository.Revision{}
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+// envInt reads a non-negative integer from the environment, or returns d.
+func envInt(k string, d int) int {
+	n, err := strconv.Atoi(os.Getenv(k))
+	if err != nil || n < 0 {
+		return d
+	}
+	return n
+}
+
+// runRevisionPurge removes message revisions older than
+// CHAT_REVISION_RETENTION_DAYS (default 90, 0 keeps them forever) every
+// CHAT_REVISION_PURGE_SECONDS (default 3600).
+func runRevisionPurge(ctx context.Context, repo repository.ChatRepository) {
+	retention := time.Duration(envInt("CHAT_REVISION_RETENTION_DAYS", 90)) * 24 * time.Hour
+	if retention == 0 {
+		return
+	}
+	t := time.NewTicker(time.Duration(max(1, envInt("CHAT_REVISION_PURGE_SECONDS", 3600))) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			purgeRevisions(ctx, repo, time.Now().Add(-retention))
+		}
+	}
+}
+
+func purgeRevisions(ctx context.Context, repo repository.ChatRepository, cutoff time.Time) {
+	n, err := repo.PurgeRevisions(ctx, cutoff.UnixMilli())
+	if err != nil {
+		log.Printf("revision purge failed: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("purged %d message revisions older than %s", n, cutoff.Format(time.RFC3339))
+	}
+}
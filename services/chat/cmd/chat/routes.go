@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newRouter serves the Node chat API described in chat.yaml, plus the older
+// /project/{projectId}/threads/{threadId}/... paths the parity scripts use.
+func newRouter(a *chatAPI) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", statusHandler)
+	mux.HandleFunc("GET /ready", readyHandler)
+
+	// Node chat API (services/web ChatApiHandler)
+	mux.HandleFunc("GET /project/{projectId}/messages", nodeIDs(a.getGlobalMessages))
+	mux.HandleFunc("POST /project/{projectId}/messages", nodeIDs(a.sendGlobalMessage))
+	mux.HandleFunc("GET /project/{projectId}/messages/{messageId}", nodeIDs(a.getGlobalMessage))
+	mux.HandleFunc("DELETE /project/{projectId}/messages/{messageId}", nodeIDs(a.deleteGlobalMessage))
+	mux.HandleFunc("POST /project/{projectId}/messages/{messageId}/edit", nodeIDs(a.editGlobalMessage))
+	mux.HandleFunc("POST /project/{projectId}/thread/{threadId}/messages", nodeIDs(a.sendThreadMessage))
+	mux.HandleFunc("GET /project/{projectId}/thread/{threadId}/messages/{messageId}", nodeIDs(a.getThreadMessage))
+	mux.HandleFunc("DELETE /project/{projectId}/thread/{threadId}/messages/{messageId}", nodeIDs(a.deleteThreadMessage))
+	mux.HandleFunc("POST /project/{projectId}/thread/{threadId}/messages/{messageId}/edit", nodeIDs(a.editThreadMessage))
+	mux.HandleFunc("DELETE /project/{projectId}/thread/{threadId}/user/{userId}/messages/{messageId}", nodeIDs(a.deleteUserMessage))
+	mux.HandleFunc("GET /project/{projectId}/thread/{threadId}", nodeIDs(a.getThread))
+	mux.HandleFunc("DELETE /project/{projectId}/thread/{threadId}", nodeIDs(a.deleteThread))
+	mux.HandleFunc("POST /project/{projectId}/thread/{threadId}/resolve", nodeIDs(a.resolveThread))
+	mux.HandleFunc("POST /project/{projectId}/thread/{threadId}/reopen", nodeIDs(a.reopenThread))
+	mux.HandleFunc("GET /project/{projectId}/resolved-thread-ids", nodeIDs(a.getResolvedThreadIds))
+	mux.HandleFunc("POST /project/{projectId}/duplicate-comment-threads", nodeIDs(a.duplicateThreads))
+	mux.HandleFunc("POST /project/{projectId}/generate-thread-data", nodeIDs(a.generateThreadData))
+	mux.HandleFunc("DELETE /project/{projectId}", nodeIDs(a.destroyProject))
+	// getThreads is shared with the older paths below, so ids are not checked.
+	mux.HandleFunc("GET /project/{projectId}/threads", a.getThreads)
+
+	// Go-only extensions: per-project thread totals for dashboards, the
+	// resolve/reopen history of a thread, message revisions, reactions,
+	// search, thread export and erasing a user's data.
+	mux.HandleFunc("GET /project/{projectId}/thread-counts", nodeIDs(a.getThreadCounts))
+	mux.HandleFunc("GET /project/{projectId}/search", nodeIDs(a.searchMessages))
+	mux.HandleFunc("GET /project/{projectId}/threads/export", nodeIDs(a.exportThreads))
+	mux.HandleFunc("DELETE /user/{userId}", a.eraseUser)
+	mux.HandleFunc("GET /project/{projectId}/thread/{threadId}/history", nodeIDs(a.getThreadHistory))
+	mux.HandleFunc("GET /project/{projectId}/messages/{messageId}/revisions", nodeIDs(a.getGlobalMessageRevisions))
+	mux.HandleFunc("GET /project/{projectId}/thread/{threadId}/messages/{messageId}/revisions", nodeIDs(a.getThreadMessageRevisions))
+	mux.HandleFunc("POST /project/{projectId}/messages/{messageId}/reactions", nodeIDs(a.addGlobalReaction))
+	mux.HandleFunc("DELETE /project/{projectId}/messages/{messageId}/reactions/{emoji}/user/{userId}", nodeIDs(a.removeGlobalReaction))
+	mux.HandleFunc("POST /project/{projectId}/thread/{threadId}/messages/{messageId}/reactions", nodeIDs(a.addThreadReaction))
+	mux.HandleFunc("DELETE /project/{projectId}/thread/{threadId}/messages/{messageId}/reactions/{emoji}/user/{userId}", nodeIDs(a.removeThreadReaction))
+
+	// Older Go paths, kept for existing callers. Ids are free-form here.
+	mux.HandleFunc("POST /project/{projectId}/threads/{threadId}/messages", a.sendThreadMessage)
+	mux.HandleFunc("GET /project/{projectId}/threads/{threadId}/messages", a.listThreadMessages)
+	mux.HandleFunc("PUT /project/{projectId}/threads/{threadId}/messages/{messageId}", a.editThreadMessage)
+	mux.HandleFunc("PATCH /project/{projectId}/threads/{threadId}/messages/{messageId}", a.editThreadMessage)
+	mux.HandleFunc("DELETE /project/{projectId}/threads/{threadId}/messages/{messageId}", a.legacyDeleteMessage)
+	mux.HandleFunc("POST /project/{projectId}/threads/{threadId}/resolve", a.resolveThread)
+	mux.HandleFunc("POST /project/{projectId}/threads/{threadId}/reopen", a.reopenThread)
+	mux.HandleFunc("POST /project/{projectId}/threads/{threadId}/delete", a.deleteThread)
+	mux.HandleFunc("POST /project/{projectId}/threads/duplicate", a.duplicateThreads)
+	mux.HandleFunc("POST /project/{projectId}/threads/generate", a.generateThreadData)
+
+	mux.HandleFunc("/", notFoundHandler)
+	return mux
+}
+
+// nodeIDs rejects projectId/threadId path values that are not ObjectIds with
+// the same plain-text 400 as the Node controller's readContext.
+func nodeIDs(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if pid := r.PathValue("projectId"); pid != "" && !primitive.IsValidObjectID(pid) {
+			writeText(w, http.StatusBadRequest, "Invalid projectId")
+			return
+		}
+		if tid := r.PathValue("threadId"); tid != "" && !primitive.IsValidObjectID(tid) {
+			writeText(w, http.StatusBadRequest, "Invalid threadId")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// notFoundHandler matches the Node service's fallback 404.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeInternalError matches the Node service's error handler.
+func writeInternalError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal error: " + err.Error()})
+}
+
+func writeText(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	w.Write([]byte(msg))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+// memoryRouter serves the API from an in-memory repository on s, so tests
+// can seed and inspect the raw store.
+func memoryRouter(s *store.Store) http.Handler {
+	return newRouter(&chatAPI{repo: repository.NewMemory(s)})
+}
+
+// testClient sends requests to a router and fails the test when a response
+// has an unexpected status.
+type testClient struct {
+	t      *testing.T
+	router http.Handler
+}
+
+// do sends one request and requires status want.
+func (c testClient) do(method, path, body string, want int) *httptest.ResponseRecorder {
+	c.t.Helper()
+	rr := c.send(method, path, body)
+	if rr.Code != want {
+		c.t.Fatalf("%s %s: expected %d, got %d %s", method, path, want, rr.Code, rr.Body.String())
+	}
+	return rr
+}
+
+// ok sends one request and requires a 2xx status.
+func (c testClient) ok(method, path, body string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	rr := c.send(method, path, body)
+	if rr.Code >= 300 {
+		c.t.Fatalf("%s %s: %d %s", method, path, rr.Code, rr.Body.String())
+	}
+	return rr
+}
+
+func (c testClient) send(method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	c.router.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+	return rr
+}
+
+// decodeMessage decodes a response holding one message.
+func decodeMessage(t *testing.T, rr *httptest.ResponseRecorder) repository.Message {
+	t.Helper()
+	var m repository.Message
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	return m
+}
+
+const (
+	parityProject = "507f1f77bcf86cd799439011"
+	parityThread  = "507f191e810c19729de860ea"
+	parityUser    = "507f1f77bcf86cd799439012"
+	otherUser     = "507f1f77bcf86cd799439013"
+)
+
+// routeStep is one request against the router. {pid}, {tid}, {uid} and {ouid}
+// are replaced by the ids above; {mid} by the id of the last created message.
+// A non-empty body is compared with the response body as JSON (or as plain
+// text when it is not JSON).
+type routeStep struct {
+	name       string
+	method     string
+	path       string
+	reqBody    string
+	wantStatus int
+	wantBody   string
+}
+
+// TestNodeRouteParity walks every route of chat.yaml in order and checks the
+// status codes and bodies the Node controller produces.
+func TestNodeRouteParity(t *testing.T) {
+	steps := []routeStep{
+		{"status", "GET", "/status", "", 200, `"chat is alive"`},
+		{"status head", "HEAD", "/status", "", 200, ""},
+		{"invalid project id", "GET", "/project/abc/messages", "", 400, "Invalid projectId"},
+		{"invalid thread id", "POST", "/project/{pid}/thread/t1/messages", `{"user_id":"{uid}","content":"x"}`, 400, "Invalid threadId"},
+		{"unknown route", "GET", "/nope", "", 404, `{"message":"Not found"}`},
+
+		// global messages
+		{"empty global messages", "GET", "/project/{pid}/messages", "", 200, `[]`},
+		{"send global invalid user", "POST", "/project/{pid}/messages", `{"user_id":"bad","content":"hi"}`, 400, "Invalid userId"},
+		{"send global no content", "POST", "/project/{pid}/messages", `{"user_id":"{uid}"}`, 400, "No content provided"},
+		{"send global too long", "POST", "/project/{pid}/messages", `{"user_id":"{uid}","content":"` + strings.Repeat("a", maxMessageLength+1) + `"}`, 400, "Content too long (> 10240 bytes)"},
+		{"send global", "POST", "/project/{pid}/messages", `{"user_id":"{uid}","content":"hello"}`, 201, ""},
+		{"get global messages", "GET", "/project/{pid}/messages", "", 200, ""},
+		{"get global message", "GET", "/project/{pid}/messages/{mid}", "", 200, ""},
+		{"get missing global message", "GET", "/project/{pid}/messages/000000000000000000000000", "", 404, ""},
+		{"edit global message", "POST", "/project/{pid}/messages/{mid}/edit", `{"content":"hello again"}`, 204, ""},
+		{"edit missing global message", "POST", "/project/{pid}/messages/000000000000000000000000/edit", `{"content":"x"}`, 404, ""},
+		{"delete global message", "DELETE", "/project/{pid}/messages/{mid}", "", 204, ""},
+		{"deleted global message", "GET", "/project/{pid}/messages/{mid}", "", 200, ""},
+		{"global message revisions", "GET", "/project/{pid}/messages/{mid}/revisions", "", 200, ""},
+		{"edit deleted global message", "POST", "/project/{pid}/messages/{mid}/edit", `{"content":"x"}`, 404, ""},
+		{"delete global message again", "DELETE", "/project/{pid}/messages/{mid}", "", 204, ""},
+
+		// comment threads
+		{"missing thread", "GET", "/project/{pid}/thread/{tid}", "", 404, ""},
+		{"send thread message", "POST", "/project/{pid}/thread/{tid}/messages", `{"user_id":"{uid}","content":"comment"}`, 201, ""},
+		{"get thread", "GET", "/project/{pid}/thread/{tid}", "", 200, ""},
+		{"get thread message", "GET", "/project/{pid}/thread/{tid}/messages/{mid}", "", 200, ""},
+		{"edit as another user", "POST", "/project/{pid}/thread/{tid}/messages/{mid}/edit", `{"content":"nope","userId":"{ouid}"}`, 404, ""},
+		{"edit as author", "POST", "/project/{pid}/thread/{tid}/messages/{mid}/edit", `{"content":"edited","userId":"{uid}"}`, 204, ""},
+		{"delete as another user", "DELETE", "/project/{pid}/thread/{tid}/user/{ouid}/messages/{mid}", "", 204, ""},
+		{"message kept", "GET", "/project/{pid}/thread/{tid}/messages/{mid}", "", 200, ""},
+		{"resolve without user", "POST", "/project/{pid}/thread/{tid}/resolve", `{}`, 400, "Invalid userId"},
+		{"resolve", "POST", "/project/{pid}/thread/{tid}/resolve", `{"user_id":"{uid}"}`, 204, ""},
+		{"resolved ids", "GET", "/project/{pid}/resolved-thread-ids", "", 200, `{"resolvedThreadIds":["{tid}"]}`},
+		{"thread counts", "GET", "/project/{pid}/thread-counts", "", 200, `{"total":1,"resolved":1,"open":0}`},
+		{"search", "GET", "/project/{pid}/search?q=EDITED&resolved=true", "", 200, ""},
+		{"search without words", "GET", "/project/{pid}/search?q=+,", "", 400, "Invalid query"},
+		{"reopen", "POST", "/project/{pid}/thread/{tid}/reopen", "", 204, ""},
+		{"no resolved ids", "GET", "/project/{pid}/resolved-thread-ids", "", 200, `{"resolvedThreadIds":[]}`},
+		{"thread history", "GET", "/project/{pid}/thread/{tid}/history", "", 200, ""},
+		{"generate thread data", "POST", "/project/{pid}/generate-thread-data", `{"threads":["{tid}","000000000000000000000000"]}`, 200, ""},
+		{"duplicate missing thread", "POST", "/project/{pid}/duplicate-comment-threads", `{"threads":["000000000000000000000000"]}`, 200, `{"newThreads":{"000000000000000000000000":{"error":"not found"}}}`},
+		{"delete user message", "DELETE", "/project/{pid}/thread/{tid}/user/{uid}/messages/{mid}", "", 204, ""},
+		{"user message deleted", "GET", "/project/{pid}/thread/{tid}/messages/{mid}", "", 200, ""},
+		{"thread message revisions", "GET", "/project/{pid}/thread/{tid}/messages/{mid}/revisions", "", 200, ""},
+		{"missing message revisions", "GET", "/project/{pid}/thread/{tid}/messages/000000000000000000000000/revisions", "", 404, ""},
+		{"send again", "POST", "/project/{pid}/thread/{tid}/messages", `{"user_id":"{uid}","content":"again"}`, 201, ""},
+		{"delete thread message", "DELETE", "/project/{pid}/thread/{tid}/messages/{mid}", "", 204, ""},
+		{"send once more", "POST", "/project/{pid}/thread/{tid}/messages", `{"user_id":"{uid}","content":"more"}`, 201, ""},
+		{"delete thread", "DELETE", "/project/{pid}/thread/{tid}", "", 204, ""},
+		{"deleted thread", "GET", "/project/{pid}/thread/{tid}", "", 404, ""},
+		{"send before destroy", "POST", "/project/{pid}/thread/{tid}/messages", `{"user_id":"{uid}","content":"bye"}`, 201, ""},
+		{"destroy project", "DELETE", "/project/{pid}", "", 200, `{"threads":2,"messages":2,"revisions":2,"history":0}`},
+		{"destroy project again", "DELETE", "/project/{pid}", "", 200, `{"threads":0,"messages":0,"revisions":0,"history":0}`},
+		{"no threads left", "GET", "/project/{pid}/threads", "", 200, `{}`},
+	}
+
+	router := memoryRouter(store.New())
+	var lastMessage string
+	expand := strings.NewReplacer("{pid}", parityProject, "{tid}", parityThread, "{uid}", parityUser, "{ouid}", otherUser)
+	for _, step := range steps {
+		path := strings.ReplaceAll(expand.Replace(step.path), "{mid}", lastMessage)
+		req := httptest.NewRequest(step.method, path, bytes.NewBufferString(expand.Replace(step.reqBody)))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != step.wantStatus {
+			t.Fatalf("%s: %s %s: expected %d, got %d: %s", step.name, step.method, path, step.wantStatus, rr.Code, rr.Body.String())
+		}
+		if step.wantBody != "" {
+			assertBody(t, step.name, expand.Replace(step.wantBody), rr.Body.String())
+		}
+		if rr.Code == 201 {
+			var created map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+				t.Fatalf("%s: decode: %v", step.name, err)
+			}
+			if created["room_id"] != parityProject || created["user_id"] != parityUser {
+				t.Fatalf("%s: unexpected created message %v", step.name, created)
+			}
+			lastMessage, _ = created["id"].(string)
+		}
+	}
+}
+
+func TestThreadViews(t *testing.T) {
+	c := testClient{t, memoryRouter(store.New())}
+	c.ok("POST", "/project/"+parityProject+"/thread/"+parityThread+"/messages", `{"user_id":"`+parityUser+`","content":"comment"}`)
+	c.ok("POST", "/project/"+parityProject+"/thread/"+parityThread+"/resolve", `{"user_id":"`+parityUser+`"}`)
+	c.ok("POST", "/project/"+parityProject+"/messages", `{"user_id":"`+parityUser+`","content":"global chat"}`)
+
+	rr := c.ok("GET", "/project/"+parityProject+"/threads", "")
+	var threads map[string]threadView
+	if err := json.Unmarshal(rr.Body.Bytes(), &threads); err != nil {
+		t.Fatalf("decode threads: %v", err)
+	}
+	// the global chat thread is not a comment thread
+	if len(threads) != 1 {
+		t.Fatalf("expected one comment thread, got %+v", threads)
+	}
+	th := threads[parityThread]
+	if !th.Resolved || len(th.Messages) != 1 || th.Messages[0].Content != "comment" {
+		t.Fatalf("unexpected thread %+v", th)
+	}
+
+	rr = c.ok("POST", "/project/"+parityProject+"/generate-thread-data", `{"threads":["`+parityThread+`","000000000000000000000000"]}`)
+	var generated map[string]threadView
+	json.Unmarshal(rr.Body.Bytes(), &generated)
+	if len(generated) != 1 || len(generated[parityThread].Messages) != 1 {
+		t.Fatalf("unexpected generated thread data %+v", generated)
+	}
+}
+
+func assertBody(t *testing.T, name, want, got string) {
+	t.Helper()
+	var w, g interface{}
+	if json.Unmarshal([]byte(want), &w) != nil {
+		if got != want {
+			t.Fatalf("%s: expected body %q, got %q", name, want, got)
+		}
+		return
+	}
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("%s: expected JSON %s, got %q", name, want, got)
+	}
+	wb, _ := json.Marshal(w)
+	gb, _ := json.Marshal(g)
+	if !bytes.Equal(wb, gb) {
+		t.Fatalf("%s: expected body %s, got %s", name, wb, gb)
+	}
+}
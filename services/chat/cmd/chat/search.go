@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/search"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// snippetWidth is the length of search snippets, in characters.
+const snippetWidth = 160
+
+// searchHit is one result of the search endpoint. Snippet is HTML with the
+// matching words in <mark>.
+type searchHit struct {
+	ThreadID string             `json:"thread_id"`
+	Resolved bool               `json:"resolved"`
+	Message  repository.Message `json:"message"`
+	Snippet  string             `json:"snippet"`
+}
+
+// searchQuery reads ?q= and the optional thread_id, user_id, from, to (ms,
+// inclusive), resolved and limit filters.
+func searchQuery(r *http.Request) (repository.SearchQuery, error) {
+	v := r.URL.Query()
+	q := repository.SearchQuery{Terms: search.Tokenize(v.Get("q")), Limit: defaultMessageLimit}
+	if len(q.Terms) == 0 {
+		return q, errors.New("Invalid query")
+	}
+	if tid := v.Get("thread_id"); tid != "" {
+		if tid != repository.GlobalThread && !primitive.IsValidObjectID(tid) {
+			return q, errors.New("Invalid threadId")
+		}
+		q.ThreadID = tid
+	}
+	if uid := v.Get("user_id"); uid != "" {
+		if !primitive.IsValidObjectID(uid) {
+			return q, errors.New("Invalid userId")
+		}
+		q.UserID = uid
+	}
+	for _, f := range []struct {
+		name string
+		dst  *int64
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if s := v.Get(f.name); s != "" {
+			ts, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || ts <= 0 {
+				return q, errors.New("Invalid " + f.name)
+			}
+			*f.dst = ts
+		}
+	}
+	if s := v.Get("resolved"); s != "" {
+		resolved, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, errors.New("Invalid resolved")
+		}
+		q.Resolved = &resolved
+	}
+	if s := v.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return q, errors.New("Invalid limit")
+		}
+		q.Limit = min(limit, maxMessageLimit)
+	}
+	return q, nil
+}
+
+// searchMessages finds the project's chat and comment messages containing
+// every word of ?q=, newest first.
+func (a *chatAPI) searchMessages(w http.ResponseWriter, r *http.Request) {
+	q, err := searchQuery(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hits, err := a.repo.Search(r.Context(), r.PathValue("projectId"), q)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	out := make([]searchHit, len(hits))
+	for i, h := range hits {
+		out[i] = searchHit{
+			ThreadID: h.ThreadID, Resolved: h.Resolved, Message: h.Message,
+			Snippet: search.Snippet(h.Message.Content, q.Terms, snippetWidth),
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+func searchTest(t *testing.T, s *store.Store, query string) []searchHit {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(rr, httptest.NewRequest("GET", "/project/"+parityProject+"/search"+query, nil))
+	if rr.Code != 200 {
+		t.Fatalf("GET %s: %d %s", query, rr.Code, rr.Body.String())
+	}
+	var out []searchHit
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	return out
+}
+
+func TestSearch(t *testing.T) {
+	s := store.New()
+	thread := "/project/" + parityProject + "/thread/" + parityThread
+	comment := sendTestMessage(t, s, thread+"/messages", "The axis of Figure 3 needs units")
+	sendTestMessage(t, s, thread+"/messages", "unrelated")
+	chat := sendTestMessage(t, s, "/project/"+parityProject+"/messages", "did you see figure 3?")
+
+	hits := searchTest(t, s, "?q=figure+3")
+	if len(hits) != 2 || hits[0].Message.ID != chat.ID || hits[1].Message.ID != comment.ID {
+		t.Fatalf("expected the chat message then the comment, got %+v", hits)
+	}
+	if hits[0].ThreadID != "GLOBAL" || hits[1].ThreadID != parityThread {
+		t.Fatalf("unexpected thread ids %q, %q", hits[0].ThreadID, hits[1].ThreadID)
+	}
+	if want := "The axis of <mark>Figure</mark> <mark>3</mark> needs units"; hits[1].Snippet != want {
+		t.Fatalf("expected snippet %q, got %q", want, hits[1].Snippet)
+	}
+
+	if hits := searchTest(t, s, "?q=FIGURE&thread_id="+parityThread+"&user_id="+parityUser); len(hits) != 1 || hits[0].Message.ID != comment.ID {
+		t.Fatalf("expected the comment, got %+v", hits)
+	}
+	if hits := searchTest(t, s, "?q=figure&resolved=false"); len(hits) != 1 || hits[0].Message.ID != comment.ID {
+		t.Fatalf("expected open threads only, got %+v", hits)
+	}
+	if hits := searchTest(t, s, "?q=figure&user_id="+otherUser); len(hits) != 0 {
+		t.Fatalf("expected no hits for another author, got %+v", hits)
+	}
+}
+
+func TestInvalidSearchQuery(t *testing.T) {
+	for query, want := range map[string]string{
+		"":                        "Invalid query",
+		"?q=...":                  "Invalid query",
+		"?q=x&thread_id=nope":     "Invalid threadId",
+		"?q=x&user_id=nope":       "Invalid userId",
+		"?q=x&from=yesterday":     "Invalid from",
+		"?q=x&to=-5":              "Invalid to",
+		"?q=x&resolved=sometimes": "Invalid resolved",
+		"?q=x&limit=0":            "Invalid limit",
+	} {
+		rr := httptest.NewRecorder()
+		memoryRouter(store.New()).ServeHTTP(rr, httptest.NewRequest("GET", "/project/"+parityProject+"/search"+query, nil))
+		if rr.Code != 400 || rr.Body.String() != want {
+			t.Errorf("%s: expected 400 %q, got %d %q", query, want, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/events"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// threadView is one entry of Node's groupMessagesByThreads output. The web
+// service's ChatManager turns resolved_by_user_id into resolved_by_user.
+type threadView struct {
+	Messages         []repository.Message `json:"messages"`
+	Resolved         bool                 `json:"resolved,omitempty"`
+	ResolvedAt       *time.Time           `json:"resolved_at,omitempty"`
+	ResolvedByUserID string               `json:"resolved_by_user_id,omitempty"`
+}
+
+// threadViews groups threads by id. Like Node, threads without messages are
+// left out.
+func threadViews(threads []repository.Thread) map[string]threadView {
+	out := map[string]threadView{}
+	for _, t := range threads {
+		if len(t.Messages) == 0 {
+			continue
+		}
+		v := threadView{Messages: t.Messages}
+		if r := t.Resolved; r != nil {
+			v.Resolved, v.ResolvedAt, v.ResolvedByUserID = true, &r.At, r.UserID
+		}
+		out[t.ID] = v
+	}
+	return out
+}
+
+// getThreads returns every comment thread of a project keyed by thread id.
+func (a *chatAPI) getThreads(w http.ResponseWriter, r *http.Request) {
+	threads, err := a.repo.Threads(r.Context(), r.PathValue("projectId"), nil)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, threadViews(threads))
+}
+
+func (a *chatAPI) getThread(w http.ResponseWriter, r *http.Request) {
+	threadId := r.PathValue("threadId")
+	threads, err := a.repo.Threads(r.Context(), r.PathValue("projectId"), []string{threadId})
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	t, ok := threadViews(threads)[threadId]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// readUserID decodes an optional {"user_id"} body. A user id that is given
+// must be an ObjectId.
+func readUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeText(w, http.StatusBadRequest, "Invalid JSON")
+		return "", false
+	}
+	if body.UserID != "" && !primitive.IsValidObjectID(body.UserID) {
+		writeText(w, http.StatusBadRequest, "Invalid userId")
+		return "", false
+	}
+	return body.UserID, true
+}
+
+// resolveThread records who resolved a thread; user_id is required.
+func (a *chatAPI) resolveThread(w http.ResponseWriter, r *http.Request) {
+	userId, ok := readUserID(w, r)
+	if !ok {
+		return
+	}
+	if userId == "" {
+		writeText(w, http.StatusBadRequest, "Invalid userId")
+		return
+	}
+	res := repository.Resolution{UserID: userId, At: time.Now().UTC()}
+	if err := a.repo.ResolveThread(r.Context(), r.PathValue("projectId"), r.PathValue("threadId"), res); err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	a.emit(r, events.ResolveThread, r.PathValue("threadId"), res)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reopenThread takes an optional user_id, which Node does not send, for the
+// thread history.
+func (a *chatAPI) reopenThread(w http.ResponseWriter, r *http.Request) {
+	userId, ok := readUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := a.repo.ReopenThread(r.Context(), r.PathValue("projectId"), r.PathValue("threadId"), userId, time.Now().UTC()); err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	a.emit(r, events.ReopenThread, r.PathValue("threadId"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getThreadHistory lists a thread's resolve/reopen transitions, oldest first.
+func (a *chatAPI) getThreadHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := a.repo.ThreadHistory(r.Context(), r.PathValue("projectId"), r.PathValue("threadId"))
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	if history == nil {
+		history = []repository.ThreadEvent{}
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (a *chatAPI) deleteThread(w http.ResponseWriter, r *http.Request) {
+	if err := a.repo.DeleteThread(r.Context(), r.PathValue("projectId"), r.PathValue("threadId")); err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	a.emit(r, events.DeleteThread, r.PathValue("threadId"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *chatAPI) getResolvedThreadIds(w http.ResponseWriter, r *http.Request) {
+	ids, err := a.repo.ResolvedThreadIDs(r.Context(), r.PathValue("projectId"))
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"resolvedThreadIds": ids})
+}
+
+// getThreadCounts reports how many comment threads a project has and how many
+// of them are resolved. It has no Node counterpart.
+func (a *chatAPI) getThreadCounts(w http.ResponseWriter, r *http.Request) {
+	threads, err := a.repo.Threads(r.Context(), r.PathValue("projectId"), nil)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	total, resolved := 0, 0
+	for _, t := range threadViews(threads) {
+		total++
+		if t.Resolved {
+			resolved++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"total": total, "resolved": resolved, "open": total - resolved})
+}
+
+// destroyProject removes every thread and message of a project and returns
+// what it removed. Node answers 204; the web service ignores the body.
+func (a *chatAPI) destroyProject(w http.ResponseWriter, r *http.Request) {
+	d, err := a.repo.DeleteProject(r.Context(), r.PathValue("projectId"))
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// eraseUser removes a user's chat data from every project, for data
+// deletion requests: ?mode=delete removes their messages, ?mode=anonymise
+// attributes them to a deleted user. It returns what it changed.
+func (a *chatAPI) eraseUser(w http.ResponseWriter, r *http.Request) {
+	userId := r.PathValue("userId")
+	if !primitive.IsValidObjectID(userId) {
+		writeText(w, http.StatusBadRequest, "Invalid userId")
+		return
+	}
+	mode := repository.EraseMode(r.URL.Query().Get("mode"))
+	if mode != repository.EraseDelete && mode != repository.EraseAnonymise {
+		writeText(w, http.StatusBadRequest, "Invalid mode")
+		return
+	}
+	e, err := a.repo.EraseUser(r.Context(), userId, mode)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Mode repository.EraseMode `json:"mode"`
+		repository.UserErasure
+	}{mode, e})
+}
+
+type threadsBody struct {
+	Threads []string `json:"threads"`
+}
+
+// duplicateThreads copies the listed threads, all or nothing, and reports
+// each source thread: its duplicateId, or "not found" as in Node. If a copy
+// fails, none are kept; the failed thread reports "unknown" and the others
+// "aborted".
+// body: { threads: [id, ...] }
+func (a *chatAPI) duplicateThreads(w http.ResponseWriter, r *http.Request) {
+	var body threadsBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeText(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	copies, err := a.repo.DuplicateThreads(r.Context(), r.PathValue("projectId"), body.Threads)
+	var failed *repository.DuplicateError
+	if err != nil && !errors.As(err, &failed) {
+		writeRepoError(w, r, err)
+		return
+	}
+	if failed != nil {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	result := map[string]interface{}{}
+	for _, id := range body.Threads {
+		switch newId, ok := copies[id]; {
+		case failed != nil && id == failed.ThreadID:
+			result[id] = map[string]string{"error": "unknown"}
+		case failed != nil:
+			result[id] = map[string]string{"error": "aborted"}
+		case ok:
+			result[id] = map[string]string{"duplicateId": newId}
+		default:
+			// expected when the comment was deleted before duplication
+			result[id] = map[string]string{"error": "not found"}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"newThreads": result})
+}
+
+// generateThreadData returns the listed threads in the getThreads shape.
+func (a *chatAPI) generateThreadData(w http.ResponseWriter, r *http.Request) {
+	var body threadsBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeText(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if body.Threads == nil {
+		body.Threads = []string{}
+	}
+	threads, err := a.repo.Threads(r.Context(), r.PathValue("projectId"), body.Threads)
+	if err != nil {
+		writeRepoError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, threadViews(threads))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
@@ -11,14 +12,21 @@ import (
 func TestResolveReopenDeleteDuplicateGenerate(t *testing.T) {
 	s := store.New()
 	// seed messages for thread t1
-	m := []map[string]interface{}{{"_id": "m1", "content": "hello"}}
+	m := []map[string]interface{}{{"id": "m1", "content": "hello"}}
 	b, _ := json.Marshal(m)
 	s.Put("messages:abc:t1", string(b))
 
+	// resolve needs a user
+	w0 := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(w0, httptest.NewRequest("POST", "/project/abc/threads/t1/resolve", nil))
+	if w0.Result().StatusCode != 400 {
+		t.Fatalf("expected 400 on resolve without user_id, got %d", w0.Result().StatusCode)
+	}
+
 	// resolve
-	req := httptest.NewRequest("POST", "/project/abc/threads/t1/resolve", nil)
+	req := httptest.NewRequest("POST", "/project/abc/threads/t1/resolve", strings.NewReader(`{"user_id":"`+parityUser+`"}`))
 	w := httptest.NewRecorder()
-	threadsHandlerWithStore(s, w, req)
+	memoryRouter(s).ServeHTTP(w, req)
 	if w.Result().StatusCode != 204 {
 		t.Fatalf("expected 204 on resolve, got %d", w.Result().StatusCode)
 	}
@@ -26,7 +34,7 @@ func TestResolveReopenDeleteDuplicateGenerate(t *testing.T) {
 	// reopen
 	req2 := httptest.NewRequest("POST", "/project/abc/threads/t1/reopen", nil)
 	w2 := httptest.NewRecorder()
-	threadsHandlerWithStore(s, w2, req2)
+	memoryRouter(s).ServeHTTP(w2, req2)
 	if w2.Result().StatusCode != 204 {
 		t.Fatalf("expected 204 on reopen, got %d", w2.Result().StatusCode)
 	}
@@ -36,7 +44,7 @@ func TestResolveReopenDeleteDuplicateGenerate(t *testing.T) {
 	w3 := httptest.NewRecorder()
 	// attach body
 	req3.Body = nopCloser{Reader: bytesFromString(`{"threads":["t1"]}`)}
-	threadsHandlerWithStore(s, w3, req3)
+	memoryRouter(s).ServeHTTP(w3, req3)
 	if w3.Result().StatusCode != 200 {
 		t.Fatalf("expected 200 on duplicate, got %d", w3.Result().StatusCode)
 	}
@@ -45,7 +53,7 @@ func TestResolveReopenDeleteDuplicateGenerate(t *testing.T) {
 	req4 := httptest.NewRequest("POST", "/project/abc/threads/generate", nil)
 	w4 := httptest.NewRecorder()
 	req4.Body = nopCloser{Reader: bytesFromString(`{"threads":["t1"]}`)}
-	threadsHandlerWithStore(s, w4, req4)
+	memoryRouter(s).ServeHTTP(w4, req4)
 	if w4.Result().StatusCode != 200 {
 		t.Fatalf("expected 200 on generate, got %d", w4.Result().StatusCode)
 	}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+const missingThread = "000000000000000000000000"
+
+func duplicateTest(t *testing.T, h http.Handler, threads ...string) map[string]map[string]string {
+	t.Helper()
+	body, _ := json.Marshal(map[string][]string{"threads": threads})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/project/"+parityProject+"/duplicate-comment-threads", bytes.NewReader(body)))
+	if rr.Code != 200 {
+		t.Fatalf("duplicate: %d %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		NewThreads map[string]map[string]string `json:"newThreads"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	return out.NewThreads
+}
+
+func TestDuplicateThreads(t *testing.T) {
+	s := store.New()
+	sendTestMessage(t, s, "/project/"+parityProject+"/thread/"+parityThread+"/messages", "copy me")
+	repository.NewMemory(s).ResolveThread(context.Background(), parityProject, parityThread,
+		repository.Resolution{UserID: otherUser, At: time.Now()})
+
+	first := duplicateTest(t, memoryRouter(s), parityThread, missingThread)
+	second := duplicateTest(t, memoryRouter(s), parityThread)
+	dup := first[parityThread]["duplicateId"]
+	if dup == "" || dup == parityThread || second[parityThread]["duplicateId"] == dup {
+		t.Fatalf("expected new unique thread ids, got %v then %v", first, second)
+	}
+	if first[missingThread]["error"] != "not found" {
+		t.Fatalf("expected the missing thread to be reported, got %v", first)
+	}
+
+	rr := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(rr, httptest.NewRequest("GET", "/project/"+parityProject+"/thread/"+dup, nil))
+	var view threadView
+	json.Unmarshal(rr.Body.Bytes(), &view)
+	if rr.Code != 200 || !view.Resolved || view.ResolvedByUserID != otherUser || len(view.Messages) != 1 || view.Messages[0].Content != "copy me" {
+		t.Fatalf("unexpected duplicate %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// failingDuplicates fails DuplicateThreads on one thread.
+type failingDuplicates struct {
+	repository.ChatRepository
+	thread string
+}
+
+func (f failingDuplicates) DuplicateThreads(ctx context.Context, projectID string, threadIDs []string) (map[string]string, error) {
+	return nil, &repository.DuplicateError{ThreadID: f.thread, Err: errors.New("write failed")}
+}
+
+func TestDuplicateThreadsReportsRollback(t *testing.T) {
+	h := newRouter(&chatAPI{repo: failingDuplicates{repository.NewMemory(store.New()), parityThread}})
+	got := duplicateTest(t, h, missingThread, parityThread)
+	if got[parityThread]["error"] != "unknown" || got[missingThread]["error"] != "aborted" {
+		t.Fatalf("expected the failed and aborted threads to be reported, got %v", got)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+func TestResolutionMetadataAndHistory(t *testing.T) {
+	c := testClient{t, memoryRouter(store.New())}
+	thread := "/project/" + parityProject + "/thread/" + parityThread
+	c.ok("POST", thread+"/messages", `{"user_id":"`+parityUser+`","content":"comment"}`)
+	c.ok("POST", thread+"/resolve", `{"user_id":"`+otherUser+`"}`)
+
+	var threads map[string]threadView
+	json.Unmarshal(c.ok("GET", "/project/"+parityProject+"/threads", "").Body.Bytes(), &threads)
+	th := threads[parityThread]
+	if !th.Resolved || th.ResolvedByUserID != otherUser || th.ResolvedAt == nil || th.ResolvedAt.IsZero() {
+		t.Fatalf("expected resolution metadata, got %+v", th)
+	}
+
+	c.ok("POST", thread+"/reopen", `{"user_id":"`+parityUser+`"}`)
+	c.ok("POST", thread+"/resolve", `{"user_id":"`+parityUser+`"}`)
+	// reopening an open thread or resolving a resolved one is not a transition
+	c.ok("POST", thread+"/resolve", `{"user_id":"`+parityUser+`"}`)
+
+	var history []repository.ThreadEvent
+	json.Unmarshal(c.ok("GET", thread+"/history", "").Body.Bytes(), &history)
+	want := []repository.ThreadEvent{
+		{Action: repository.ActionResolve, UserID: otherUser},
+		{Action: repository.ActionReopen, UserID: parityUser},
+		{Action: repository.ActionResolve, UserID: parityUser},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), history)
+	}
+	for i, e := range history {
+		if e.Action != want[i].Action || e.UserID != want[i].UserID || e.At.IsZero() {
+			t.Fatalf("event %d: expected %+v, got %+v", i, want[i], e)
+		}
+		if i > 0 && e.At.Before(history[i-1].At) {
+			t.Fatalf("events out of order: %+v", history)
+		}
+	}
+
+	threads = nil
+	c.ok("POST", thread+"/reopen", "")
+	json.Unmarshal(c.ok("GET", "/project/"+parityProject+"/threads", "").Body.Bytes(), &threads)
+	if th := threads[parityThread]; th.Resolved || th.ResolvedAt != nil || th.ResolvedByUserID != "" {
+		t.Fatalf("expected an open thread, got %+v", th)
+	}
+}
@@ -16,7 +16,7 @@ func TestThreadsHandlerWithSeed(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/project/abc/threads", nil)
 	w := httptest.NewRecorder()
-	threadsHandlerWithStore(s, w, req)
+	memoryRouter(s).ServeHTTP(w, req)
 
 	res := w.Result()
 	if res.StatusCode != http.StatusOK {
@@ -12,14 +12,18 @@ func TestThreadsHandler(t *testing.T) {
 	req := httptest.NewRequest("GET", "/project/abc123/threads", nil)
 	w := httptest.NewRecorder()
 	s := store.New()
-	threadsHandlerWithStore(s, w, req)
+	memoryRouter(s).ServeHTTP(w, req)
 
 	res := w.Result()
 	if res.StatusCode != http.StatusOK {
 		t.Fatalf("expected status 200, got %d", res.StatusCode)
 	}
-	var body []interface{}
+	// Node's getThreads returns an object keyed by thread id, even when empty
+	var body map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
 		t.Fatalf("error decoding body: %v", err)
 	}
+	if len(body) != 0 {
+		t.Fatalf("expected no threads, got %+v", body)
+	}
 }
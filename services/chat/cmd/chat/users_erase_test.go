@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+)
+
+const secondProject = "507f1f77bcf86cd799439014"
+
+func eraseTest(t *testing.T, s *store.Store, query string, want int) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	memoryRouter(s).ServeHTTP(rr, httptest.NewRequest("DELETE", "/user/"+parityUser+query, nil))
+	if rr.Code != want {
+		t.Fatalf("%s: expected %d, got %d %s", query, want, rr.Code, rr.Body.String())
+	}
+	return rr.Body.String()
+}
+
+func TestEraseUserAnonymises(t *testing.T) {
+	s := store.New()
+	sendTestMessage(t, s, "/project/"+parityProject+"/messages", "mine")
+	sendTestMessage(t, s, "/project/"+secondProject+"/thread/"+parityThread+"/messages", "mine too")
+
+	got := eraseTest(t, s, "?mode=anonymise", 200)
+	assertBody(t, "erase", `{"mode":"anonymise","messages":2,"revisions":0,"reactions":0,"resolutions":0,"history":0}`, got)
+	for _, m := range listTestMessages(t, s, "/project/"+parityProject+"/messages") {
+		if m.UserID != repository.DeletedUser || m.Content != "mine" {
+			t.Fatalf("expected an anonymised message, got %+v", m)
+		}
+	}
+	assertBody(t, "erase again", `{"mode":"anonymise","messages":0,"revisions":0,"reactions":0,"resolutions":0,"history":0}`, eraseTest(t, s, "?mode=anonymise", 200))
+}
+
+func TestEraseUserDeletes(t *testing.T) {
+	s := store.New()
+	sendTestMessage(t, s, "/project/"+parityProject+"/messages", "mine")
+	var summary repository.UserErasure
+	json.Unmarshal([]byte(eraseTest(t, s, "?mode=delete", 200)), &summary)
+	if summary.Messages != 1 {
+		t.Fatalf("expected one deleted message, got %+v", summary)
+	}
+	if msgs := listTestMessages(t, s, "/project/"+parityProject+"/messages"); len(msgs) != 0 {
+		t.Fatalf("expected no messages, got %+v", msgs)
+	}
+}
+
+func TestEraseUserValidation(t *testing.T) {
+	eraseTest(t, store.New(), "", 400)
+	eraseTest(t, store.New(), "?mode=forget", 400)
+	rr := httptest.NewRecorder()
+	memoryRouter(store.New()).ServeHTTP(rr, httptest.NewRequest("DELETE", "/user/nope?mode=delete", nil))
+	if rr.Code != 400 || rr.Body.String() != "Invalid userId" {
+		t.Fatalf("expected 400 Invalid userId, got %d %q", rr.Code, rr.Body.String())
+	}
+}
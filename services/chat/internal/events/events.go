@@ -0,0 +1,206 @@
+// Package events publishes chat changes to the real-time service so that
+// collaborators see them without a reload. Events go through an outbox and
+// are delivered in order per project by a dispatcher that retries with
+// backoff, so a real-time outage delays events but does not lose them.
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Event names, as the web service emits them to editor clients.
+const (
+	NewChatMessage      = "new-chat-message"
+	EditGlobalMessage   = "edit-global-message"
+	DeleteGlobalMessage = "delete-global-message"
+	NewComment          = "new-comment"
+	EditMessage         = "edit-message"
+	DeleteMessage       = "delete-message"
+	ResolveThread       = "resolve-thread"
+	ReopenThread        = "reopen-thread"
+	DeleteThread        = "delete-thread"
+)
+
+// Event is one message to a project's editor clients, in the shape of the
+// editor-events channel: the clients receive Message with Payload as its
+// arguments.
+type Event struct {
+	ProjectID string        `json:"room_id"`
+	Message   string        `json:"message"`
+	Payload   []interface{} `json:"payload"`
+}
+
+// Topic is the pub/sub topic of a project's editor events.
+func (e Event) Topic() string {
+	return "editor-events:" + e.ProjectID
+}
+
+// Publisher delivers one event.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// ErrRejected marks an event the receiver will never accept. It is dropped
+// instead of retried.
+var ErrRejected = errors.New("event rejected")
+
+// Pending is an undelivered outbox entry. LeasedUntil is set while a
+// dispatcher is delivering it.
+type Pending struct {
+	ID          string
+	Event       Event
+	Attempts    int
+	NextAttempt time.Time
+	LeasedUntil time.Time
+}
+
+// Outbox stores events until they are delivered.
+type Outbox interface {
+	Enqueue(ctx context.Context, e Event) error
+	// Oldest returns up to limit undelivered events of projects not in skip,
+	// oldest first.
+	Oldest(ctx context.Context, limit int, skip []string) ([]Pending, error)
+	// Claim leases an undelivered event that is due at now and not leased
+	// by another dispatcher, until until. It reports false when the event
+	// cannot be claimed.
+	Claim(ctx context.Context, id string, now, until time.Time) (bool, error)
+	// Delivered removes an event from the queue.
+	Delivered(ctx context.Context, id string) error
+	// Failed schedules the next attempt of an event and releases its lease.
+	Failed(ctx context.Context, id string, attempts int, next time.Time, cause error) error
+}
+
+// backoff returns the delay before retry number attempts (1-based).
+func backoff(attempts int) time.Duration {
+	d := time.Second << uint(attempts-1)
+	if attempts > 9 || d > 5*time.Minute {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// lease is how long a dispatcher may deliver a claimed event before other
+// dispatchers may claim it again.
+const lease = time.Minute
+
+// Bus queues events and dispatches them.
+type Bus struct {
+	outbox Outbox
+	pub    Publisher
+	kick   chan struct{}
+}
+
+// NewBus returns a bus delivering events from outbox through pub. Call Run
+// to start delivery.
+func NewBus(outbox Outbox, pub Publisher) *Bus {
+	return &Bus{outbox: outbox, pub: pub, kick: make(chan struct{}, 1)}
+}
+
+// Emit queues an event for delivery and wakes the dispatcher. Failing to
+// queue is logged rather than returned: the change it describes has already
+// been stored.
+func (b *Bus) Emit(ctx context.Context, projectID, message string, payload ...interface{}) {
+	e := Event{ProjectID: projectID, Message: message, Payload: payload}
+	if err := b.outbox.Enqueue(ctx, e); err != nil {
+		log.Printf("queue %s event for %s: %v", message, projectID, err)
+		return
+	}
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers events when they are emitted and every poll, until ctx is
+// cancelled.
+func (b *Bus) Run(ctx context.Context, poll time.Duration) {
+	t := time.NewTicker(poll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.kick:
+		case <-t.C:
+		}
+		b.Dispatch(ctx, time.Now())
+	}
+}
+
+// dispatchPage is how many events Dispatch reads at a time, and
+// dispatchPages how many pages it reads per call.
+const (
+	dispatchPage  = 100
+	dispatchPages = 10
+)
+
+// Dispatch delivers queued events, oldest first. An event that is waiting
+// for its next attempt, fails, or is being delivered by another dispatcher
+// holds back the later events of its project, so they never overtake it;
+// other projects' events are still delivered. Each page skips the projects
+// held so far, so a held project's backlog cannot crowd out the others.
+func (b *Bus) Dispatch(ctx context.Context, now time.Time) {
+	held := map[string]bool{}
+	for page := 0; page < dispatchPages; page++ {
+		skip := make([]string, 0, len(held))
+		for project := range held {
+			skip = append(skip, project)
+		}
+		pending, err := b.outbox.Oldest(ctx, dispatchPage, skip)
+		if err != nil {
+			log.Printf("outbox: %v", err)
+			return
+		}
+		if !b.dispatch(ctx, now, pending, held) || len(pending) < dispatchPage {
+			return
+		}
+	}
+}
+
+// dispatch delivers pending in order, adding the projects it has to hold
+// back to held. It reports whether it delivered or held anything, that is
+// whether another page could make progress.
+func (b *Bus) dispatch(ctx context.Context, now time.Time, pending []Pending, held map[string]bool) bool {
+	progress := false
+	for _, p := range pending {
+		project := p.Event.ProjectID
+		if held[project] {
+			continue
+		}
+		progress = true
+		if p.NextAttempt.After(now) || p.LeasedUntil.After(now) {
+			held[project] = true
+			continue
+		}
+		claimed, err := b.outbox.Claim(ctx, p.ID, now, now.Add(lease))
+		if err != nil {
+			log.Printf("outbox claim %s: %v", p.ID, err)
+		}
+		if !claimed {
+			held[project] = true
+			continue
+		}
+		err = b.pub.Publish(ctx, p.Event)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrRejected):
+			log.Printf("dropping %s event for %s: %v", p.Event.Message, project, err)
+		default:
+			attempts := p.Attempts + 1
+			log.Printf("publish %s event %s (attempt %d): %v", p.Event.Message, p.ID, attempts, err)
+			if err := b.outbox.Failed(ctx, p.ID, attempts, now.Add(backoff(attempts)), err); err != nil {
+				log.Printf("outbox failed %s: %v", p.ID, err)
+			}
+			held[project] = true
+			continue
+		}
+		if err := b.outbox.Delivered(ctx, p.ID); err != nil {
+			log.Printf("outbox delivered %s: %v", p.ID, err)
+			held[project] = true
+		}
+	}
+	return progress
+}
@@ -0,0 +1,220 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeRealTime stands in for the real-time service. It answers status to
+// each publish and records the events it accepted.
+type fakeRealTime struct {
+	mu       sync.Mutex
+	status   int
+	topics   []string
+	received []Event
+}
+
+func (f *fakeRealTime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/internal/api/pubsub/publish" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if f.status != http.StatusOK {
+		w.WriteHeader(f.status)
+		return
+	}
+	var body struct{ Topic, Msg string }
+	json.NewDecoder(r.Body).Decode(&body)
+	var e Event
+	json.Unmarshal([]byte(body.Msg), &e)
+	f.topics = append(f.topics, body.Topic)
+	f.received = append(f.received, e)
+}
+
+func (f *fakeRealTime) setStatus(status int) {
+	f.mu.Lock()
+	f.status = status
+	f.mu.Unlock()
+}
+
+func runBusTest(t *testing.T, outbox Outbox) {
+	rt := &fakeRealTime{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+	bus := NewBus(outbox, NewHTTPPublisher(srv.URL))
+	ctx := context.Background()
+	now := time.Now()
+
+	bus.Emit(ctx, "p1", NewChatMessage, map[string]string{"id": "m1", "content": "hi"})
+	bus.Emit(ctx, "p1", EditGlobalMessage, "m1", "hello")
+
+	// real-time is down: the first event is retried after a backoff and
+	// the second waits behind it
+	bus.Dispatch(ctx, now)
+	rt.setStatus(http.StatusOK)
+	bus.Dispatch(ctx, now)
+	if len(rt.received) != 0 {
+		t.Fatalf("expected no delivery before the backoff, got %+v", rt.received)
+	}
+	bus.Dispatch(ctx, now.Add(backoff(1)))
+	if len(rt.received) != 2 || rt.received[0].Message != NewChatMessage || rt.received[1].Message != EditGlobalMessage {
+		t.Fatalf("expected both events in order, got %+v", rt.received)
+	}
+	if rt.topics[0] != "editor-events:p1" || rt.received[1].ProjectID != "p1" || rt.received[1].Payload[1] != "hello" {
+		t.Fatalf("unexpected event %q %+v", rt.topics[0], rt.received[1])
+	}
+	if p, _ := outbox.Oldest(ctx, 10, nil); len(p) != 0 {
+		t.Fatalf("expected an empty outbox, got %+v", p)
+	}
+
+	// events the receiver rejects are dropped instead of blocking the queue
+	rt.setStatus(http.StatusBadRequest)
+	bus.Emit(ctx, "p1", DeleteThread, "t1")
+	bus.Dispatch(ctx, now)
+	if p, _ := outbox.Oldest(ctx, 10, nil); len(p) != 0 {
+		t.Fatalf("expected the rejected event to be dropped, got %+v", p)
+	}
+
+	// a failing event holds back its own project only
+	rt.setStatus(http.StatusOK)
+	rt.received = nil
+	failing := &failProject{pub: NewHTTPPublisher(srv.URL), project: "p1"}
+	bus = NewBus(outbox, failing)
+	bus.Emit(ctx, "p1", NewChatMessage, "a")
+	bus.Emit(ctx, "p2", NewChatMessage, "b")
+	bus.Emit(ctx, "p1", NewChatMessage, "c")
+	bus.Emit(ctx, "p2", NewChatMessage, "d")
+	now = time.Now()
+	bus.Dispatch(ctx, now)
+	if len(rt.received) != 2 || rt.received[0].Payload[0] != "b" || rt.received[1].Payload[0] != "d" {
+		t.Fatalf("expected p2's events past the failing p1, got %+v", rt.received)
+	}
+	failing.project = ""
+	bus.Dispatch(ctx, now.Add(backoff(1)))
+	if len(rt.received) != 4 || rt.received[2].Payload[0] != "a" || rt.received[3].Payload[0] != "c" {
+		t.Fatalf("expected p1's events in order after the retry, got %+v", rt.received)
+	}
+
+	// an event claimed by another dispatcher is not delivered again, and
+	// holds back its project until the lease runs out
+	rt.received = nil
+	bus.Emit(ctx, "p3", NewChatMessage, "e")
+	bus.Emit(ctx, "p3", NewChatMessage, "f")
+	now = time.Now()
+	p, _ := outbox.Oldest(ctx, 10, nil)
+	if ok, err := outbox.Claim(ctx, p[0].ID, now, now.Add(lease)); !ok || err != nil {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+	if ok, _ := outbox.Claim(ctx, p[0].ID, now, now.Add(lease)); ok {
+		t.Fatal("expected a leased event not to be claimed twice")
+	}
+	bus.Dispatch(ctx, now)
+	if len(rt.received) != 0 {
+		t.Fatalf("expected no delivery during the lease, got %+v", rt.received)
+	}
+	bus.Dispatch(ctx, now.Add(lease))
+	if len(rt.received) != 2 || rt.received[0].Payload[0] != "e" {
+		t.Fatalf("expected the expired lease to be reclaimed in order, got %+v", rt.received)
+	}
+
+	// a held project with more than a page of events does not starve the
+	// projects queued behind it
+	rt.received = nil
+	failing.project = "p4"
+	for i := 0; i < dispatchPage+10; i++ {
+		bus.Emit(ctx, "p4", NewChatMessage, i)
+	}
+	bus.Emit(ctx, "p5", NewChatMessage, "g")
+	now = time.Now()
+	bus.Dispatch(ctx, now)
+	if len(rt.received) != 1 || rt.received[0].Payload[0] != "g" {
+		t.Fatalf("expected p5's event past p4's backlog, got %+v", rt.received)
+	}
+	failing.project = ""
+	bus.Dispatch(ctx, now.Add(backoff(1)))
+	if len(rt.received) != dispatchPage+11 {
+		t.Fatalf("expected p4's backlog after the retry, got %d events", len(rt.received))
+	}
+}
+
+// failProject fails every publish for project and passes the rest on.
+type failProject struct {
+	pub     Publisher
+	project string
+}
+
+func (f *failProject) Publish(ctx context.Context, e Event) error {
+	if e.ProjectID == f.project {
+		return errors.New("unavailable")
+	}
+	return f.pub.Publish(ctx, e)
+}
+
+func TestBusWithMemoryOutbox(t *testing.T) {
+	runBusTest(t, NewMemoryOutbox(1000))
+}
+
+func TestBusWithMongoOutbox(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	db := client.Database("chat_events_test")
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	runBusTest(t, NewMongoOutbox(db))
+}
+
+func TestMemoryOutboxLimit(t *testing.T) {
+	o := NewMemoryOutbox(2)
+	ctx := context.Background()
+	for _, m := range []string{"a", "b", "c"} {
+		o.Enqueue(ctx, Event{ProjectID: "p", Message: m})
+	}
+	p, _ := o.Oldest(ctx, 10, nil)
+	if len(p) != 2 || p[0].Event.Message != "b" || p[1].Event.Message != "c" {
+		t.Fatalf("expected the two newest events, got %+v", p)
+	}
+}
+
+func TestRunDeliversOnEmit(t *testing.T) {
+	rt := &fakeRealTime{status: http.StatusOK}
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+	bus := NewBus(NewMemoryOutbox(10), NewHTTPPublisher(srv.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Run(ctx, time.Hour)
+
+	bus.Emit(ctx, "p1", ReopenThread, "t1")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		rt.mu.Lock()
+		n := len(rt.received)
+		rt.mu.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected the event to be delivered without waiting for the poll")
+}
@@ -0,0 +1,214 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryOutbox keeps events in process. It survives real-time outages but
+// not restarts, and holds at most limit events, dropping the oldest.
+type MemoryOutbox struct {
+	mu      sync.Mutex
+	limit   int
+	nextID  int
+	pending []Pending
+}
+
+// NewMemoryOutbox returns an outbox holding at most limit events.
+func NewMemoryOutbox(limit int) *MemoryOutbox {
+	return &MemoryOutbox{limit: limit}
+}
+
+func (o *MemoryOutbox) Enqueue(ctx context.Context, e Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.nextID++
+	o.pending = append(o.pending, Pending{ID: strconv.Itoa(o.nextID), Event: e})
+	if len(o.pending) > o.limit {
+		o.pending = o.pending[len(o.pending)-o.limit:]
+	}
+	return nil
+}
+
+func (o *MemoryOutbox) Oldest(ctx context.Context, limit int, skip []string) ([]Pending, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []Pending
+	for _, p := range o.pending {
+		if len(out) == limit {
+			break
+		}
+		if !slices.Contains(skip, p.Event.ProjectID) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (o *MemoryOutbox) Claim(ctx context.Context, id string, now, until time.Time) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.pending {
+		p := &o.pending[i]
+		if p.ID == id && !p.NextAttempt.After(now) && !p.LeasedUntil.After(now) {
+			p.LeasedUntil = until
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (o *MemoryOutbox) Delivered(ctx context.Context, id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, p := range o.pending {
+		if p.ID == id {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (o *MemoryOutbox) Failed(ctx context.Context, id string, attempts int, next time.Time, cause error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.pending {
+		if o.pending[i].ID == id {
+			o.pending[i].Attempts, o.pending[i].NextAttempt = attempts, next
+			o.pending[i].LeasedUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+// MongoOutbox keeps events in the chat_outbox collection:
+//
+//	{_id, projectId, event, createdAt, nextAttemptAt, attempts, leasedUntil?, lastError?, sentAt?}
+//
+// event is the event's JSON, so payloads round-trip unchanged; projectId
+// lets Oldest skip held projects. Dispatchers
+// claim an event by setting leasedUntil with findOneAndUpdate, so several
+// chat instances can share the collection without delivering an event
+// twice. Delivered events are kept for a day for debugging, then expire.
+type MongoOutbox struct {
+	coll *mongo.Collection
+}
+
+// NewMongoOutbox returns an outbox on db's chat_outbox collection.
+func NewMongoOutbox(db *mongo.Database) *MongoOutbox {
+	return NewMongoOutboxCollection(db, "chat_outbox")
+}
+
+// NewMongoOutboxCollection returns an outbox on db's collection name, for
+// queues other than the real-time events.
+func NewMongoOutboxCollection(db *mongo.Database, name string) *MongoOutbox {
+	return &MongoOutbox{coll: db.Collection(name)}
+}
+
+// EnsureIndexes indexes pending events and expires delivered ones.
+func (o *MongoOutbox) EnsureIndexes(ctx context.Context) error {
+	pending := mongo.IndexModel{Keys: bson.D{{Key: "sentAt", Value: 1}, {Key: "createdAt", Value: 1}}}
+	ttl := mongo.IndexModel{Keys: bson.D{{Key: "sentAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(86400).SetName("sentAt_ttl")}
+	_, err := o.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{pending, ttl})
+	return err
+}
+
+func (o *MongoOutbox) Enqueue(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = o.coll.InsertOne(ctx, bson.M{
+		"projectId":     e.ProjectID,
+		"event":         string(b),
+		"createdAt":     now,
+		"nextAttemptAt": now,
+		"attempts":      0,
+	})
+	return err
+}
+
+func (o *MongoOutbox) Oldest(ctx context.Context, limit int, skip []string) ([]Pending, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	filter := bson.M{"sentAt": bson.M{"$exists": false}}
+	if len(skip) > 0 {
+		filter["projectId"] = bson.M{"$nin": skip}
+	}
+	cur, err := o.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var recs []struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		Event         string             `bson:"event"`
+		Attempts      int                `bson:"attempts"`
+		NextAttemptAt time.Time          `bson:"nextAttemptAt"`
+		LeasedUntil   time.Time          `bson:"leasedUntil"`
+	}
+	if err := cur.All(ctx, &recs); err != nil {
+		return nil, err
+	}
+	out := make([]Pending, 0, len(recs))
+	for _, r := range recs {
+		p := Pending{ID: r.ID.Hex(), Attempts: r.Attempts, NextAttempt: r.NextAttemptAt, LeasedUntil: r.LeasedUntil}
+		if err := json.Unmarshal([]byte(r.Event), &p.Event); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (o *MongoOutbox) Claim(ctx context.Context, id string, now, until time.Time) (bool, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+	filter := bson.M{
+		"_id":           oid,
+		"sentAt":        bson.M{"$exists": false},
+		"nextAttemptAt": bson.M{"$lte": now},
+		"$or":           bson.A{bson.M{"leasedUntil": bson.M{"$exists": false}}, bson.M{"leasedUntil": bson.M{"$lte": now}}},
+	}
+	err = o.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"leasedUntil": until}}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (o *MongoOutbox) Delivered(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = o.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"sentAt": time.Now()}})
+	return err
+}
+
+func (o *MongoOutbox) Failed(ctx context.Context, id string, attempts int, next time.Time, cause error) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = o.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{
+		"$set": bson.M{
+			"attempts":      attempts,
+			"nextAttemptAt": next,
+			"lastError":     cause.Error(),
+		},
+		"$unset": bson.M{"leasedUntil": true},
+	})
+	return err
+}
@@ -0,0 +1,48 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPPublisher posts events to the real-time service's
+// /internal/api/pubsub/publish as {"topic", "msg"}, with the event JSON as
+// msg.
+type HTTPPublisher struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewHTTPPublisher returns a publisher for the real-time service at baseURL.
+func NewHTTPPublisher(baseURL string) *HTTPPublisher {
+	return &HTTPPublisher{BaseURL: baseURL, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *HTTPPublisher) Publish(ctx context.Context, e Event) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	body, _ := json.Marshal(map[string]string{"topic": e.Topic(), "msg": string(msg)})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/internal/api/pubsub/publish", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("publish %s: status %d", e.Message, resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("%w: publish %s: status %d", ErrRejected, e.Message, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package mentions
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Directory resolves mention handles to user ids.
+type Directory interface {
+	// Resolve returns the user id of each handle it knows, keyed by handle.
+	// Unknown handles are left out. projectID lets a directory limit
+	// mentions to a project's collaborators.
+	Resolve(ctx context.Context, projectID string, handles []string) (map[string]string, error)
+}
+
+// StaticDirectory maps lower-case handles to user ids. It is a local
+// stand-in for development and tests.
+type StaticDirectory map[string]string
+
+func (d StaticDirectory) Resolve(ctx context.Context, projectID string, handles []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, h := range handles {
+		if id, ok := d[h]; ok {
+			out[h] = id
+		}
+	}
+	return out, nil
+}
+
+// MongoDirectory resolves a handle among a project's owner and invited
+// collaborators (editors, reviewers and read-only members) in the web
+// service's projects and users collections. A handle is the lower-cased local
+// part of a member's email address; handles shared by several members are
+// ambiguous and left out. Users outside the project are never resolved.
+type MongoDirectory struct {
+	projects *mongo.Collection
+	users    *mongo.Collection
+}
+
+// NewMongoDirectory returns a directory on db's projects and users
+// collections.
+func NewMongoDirectory(db *mongo.Database) *MongoDirectory {
+	return &MongoDirectory{projects: db.Collection("projects"), users: db.Collection("users")}
+}
+
+// memberFields are the project fields listing its members, as in the web
+// service's CollaboratorsGetter.
+var memberFields = []string{"collaberator_refs", "reviewer_refs", "readOnly_refs"}
+
+func (d *MongoDirectory) Resolve(ctx context.Context, projectID string, handles []string) (map[string]string, error) {
+	out := map[string]string{}
+	pid, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil || len(handles) == 0 {
+		return out, nil
+	}
+	projection := bson.M{"owner_ref": 1}
+	for _, f := range memberFields {
+		projection[f] = 1
+	}
+	var project bson.M
+	err = d.projects.FindOne(ctx, bson.M{"_id": pid}, options.FindOne().SetProjection(projection)).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	members := bson.A{project["owner_ref"]}
+	for _, f := range memberFields {
+		if refs, ok := project[f].(bson.A); ok {
+			members = append(members, refs...)
+		}
+	}
+	// members are looked up by _id, so the query stays on the primary index
+	// however large the users collection is
+	cur, err := d.users.Find(ctx, bson.M{"_id": bson.M{"$in": members}}, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Email string             `bson:"email"`
+	}
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	byHandle := map[string][]string{}
+	for _, u := range users {
+		byHandle[emailHandle(u.Email)] = append(byHandle[emailHandle(u.Email)], u.ID.Hex())
+	}
+	for _, h := range handles {
+		if ids := byHandle[h]; len(ids) == 1 {
+			out[h] = ids[0]
+		}
+	}
+	return out, nil
+}
+
+// emailHandle returns the normalised handle of an email address: its
+// lower-cased local part.
+func emailHandle(email string) string {
+	local, _, ok := strings.Cut(email, "@")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(local)
+}
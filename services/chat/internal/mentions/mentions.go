@@ -0,0 +1,214 @@
+// Package mentions finds @mentions in chat messages and keeps the mentioned
+// users' notifications in step with the message: created when a user is
+// mentioned, and removed when the mention is edited out or the message is
+// deleted.
+package mentions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/events"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+)
+
+// A mention is @ followed by a handle, at the start of the content or after
+// a character that cannot be part of an email address, so a@b.com is not a
+// mention of b.com.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.+-])@([A-Za-z0-9][A-Za-z0-9._-]*)`)
+
+// Parse returns the distinct handles mentioned in content, lower-cased, in
+// order of first appearance. A trailing full stop is not part of a handle.
+func Parse(content string) []string {
+	var handles []string
+	seen := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		h := strings.ToLower(strings.TrimRight(m[1], "."))
+		if h != "" && !seen[h] {
+			seen[h] = true
+			handles = append(handles, h)
+		}
+	}
+	return handles
+}
+
+// TemplateKey is the notification template of a chat mention.
+const TemplateKey = "notification_chat_mention"
+
+// maxExcerpt bounds the message excerpt stored in a notification.
+const maxExcerpt = 200
+
+// MaxHandles bounds the handles resolved per message. Mentions past the
+// first MaxHandles are ignored.
+const MaxHandles = 20
+
+// ChangeEvent is the outbox event of a message change whose notifications
+// are still to be updated.
+const ChangeEvent = "chat-mention-change"
+
+// change is the payload of a ChangeEvent.
+type change struct {
+	ThreadID string              `json:"threadId"`
+	Before   *repository.Message `json:"before,omitempty"`
+	After    *repository.Message `json:"after,omitempty"`
+}
+
+// NotificationKey identifies the mention notification of a message, so a
+// user has at most one per message.
+func NotificationKey(messageID string) string {
+	return "chat-mention-" + messageID
+}
+
+// Service turns message changes into notification changes. With a Queue,
+// changes are queued and applied by the queue's dispatcher, which delivers
+// through the Service's Publish, so a chat request never waits for the
+// directory or the notifications service. Without one they are applied
+// before the call returns.
+type Service struct {
+	Directory Directory
+	Notifier  Notifier
+	Queue     *events.Bus
+}
+
+// MessageCreated notifies everyone mentioned in m except its author.
+func (s *Service) MessageCreated(ctx context.Context, projectID, threadID string, m repository.Message) {
+	s.apply(ctx, projectID, change{ThreadID: threadID, After: &m})
+}
+
+// MessageEdited notifies users mentioned in after but not in before, and
+// removes the notifications of users no longer mentioned.
+func (s *Service) MessageEdited(ctx context.Context, projectID, threadID string, before, after repository.Message) {
+	s.apply(ctx, projectID, change{ThreadID: threadID, Before: &before, After: &after})
+}
+
+// MessageDeleted removes the notifications of everyone mentioned in m.
+func (s *Service) MessageDeleted(ctx context.Context, projectID, threadID string, m repository.Message) {
+	s.apply(ctx, projectID, change{ThreadID: threadID, Before: &m})
+}
+
+// apply queues c, or applies it at once when there is no queue. Failures
+// are logged: notifications are best effort and never fail the chat
+// request.
+func (s *Service) apply(ctx context.Context, projectID string, c change) {
+	if s.Queue != nil {
+		s.Queue.Emit(ctx, projectID, ChangeEvent, c)
+		return
+	}
+	if err := s.update(ctx, projectID, c.ThreadID, c.Before, c.After); err != nil {
+		log.Printf("update mention notifications in %s: %v", projectID, err)
+	}
+}
+
+// Publish applies a queued ChangeEvent, making the Service the Queue's
+// publisher. An error schedules a retry; notifications are keyed by user
+// and message, so applying a change twice is harmless.
+func (s *Service) Publish(ctx context.Context, e events.Event) error {
+	if e.Message != ChangeEvent || len(e.Payload) != 1 {
+		return fmt.Errorf("%w: unexpected %s event", events.ErrRejected, e.Message)
+	}
+	// queued payloads come back as JSON objects from a Mongo outbox
+	b, err := json.Marshal(e.Payload[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", events.ErrRejected, err)
+	}
+	var c change
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("%w: %v", events.ErrRejected, err)
+	}
+	if c.Before == nil && c.After == nil {
+		return fmt.Errorf("%w: empty change", events.ErrRejected)
+	}
+	return s.update(ctx, e.ProjectID, c.ThreadID, c.Before, c.After)
+}
+
+// update applies the difference between the users mentioned in before and
+// after, and returns the failures it met.
+func (s *Service) update(ctx context.Context, projectID, threadID string, before, after *repository.Message) error {
+	var was, is map[string]bool
+	if before != nil {
+		var err error
+		if was, err = s.mentioned(ctx, projectID, *before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		var err error
+		if is, err = s.mentioned(ctx, projectID, *after); err != nil {
+			return err
+		}
+	}
+	msg := after
+	if msg == nil {
+		msg = before
+	}
+	key := NotificationKey(msg.ID)
+	var errs []error
+	for userID := range was {
+		if !is[userID] {
+			if err := s.Notifier.Remove(ctx, userID, key); err != nil {
+				errs = append(errs, fmt.Errorf("remove mention notification %s for %s: %w", key, userID, err))
+			}
+		}
+	}
+	for userID := range is {
+		if was[userID] {
+			continue
+		}
+		n := Notification{
+			Key:         key,
+			TemplateKey: TemplateKey,
+			ForceCreate: true,
+			MessageOpts: map[string]string{
+				"projectId": projectID,
+				"threadId":  threadID,
+				"messageId": msg.ID,
+				"userId":    msg.UserID,
+				"content":   excerpt(msg.Content),
+			},
+		}
+		if err := s.Notifier.Add(ctx, userID, n); err != nil {
+			errs = append(errs, fmt.Errorf("add mention notification %s for %s: %w", key, userID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mentioned resolves the first MaxHandles handles in m to user ids, leaving
+// out its author.
+func (s *Service) mentioned(ctx context.Context, projectID string, m repository.Message) (map[string]bool, error) {
+	handles := Parse(m.Content)
+	if len(handles) == 0 {
+		return nil, nil
+	}
+	if len(handles) > MaxHandles {
+		handles = handles[:MaxHandles]
+	}
+	ids, err := s.Directory.Resolve(ctx, projectID, handles)
+	if err != nil {
+		return nil, fmt.Errorf("resolve mentions %v: %w", handles, err)
+	}
+	users := map[string]bool{}
+	for _, id := range ids {
+		if id != m.UserID {
+			users[id] = true
+		}
+	}
+	return users, nil
+}
+
+func excerpt(content string) string {
+	if len(content) <= maxExcerpt {
+		return content
+	}
+	cut := maxExcerpt
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return content[:cut] + "…"
+}
@@ -0,0 +1,236 @@
+package mentions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/events"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		content string
+		want    []string
+	}{
+		{"no mentions here", nil},
+		{"@alice can you check?", []string{"alice"}},
+		{"thanks @Bob and @alice, and @bob again.", []string{"bob", "alice"}},
+		{"ask @jane.doe.", []string{"jane.doe"}},
+		{"(@carol) @dave-2:", []string{"carol", "dave-2"}},
+		{"mail me at erin@example.com or @ nobody", nil},
+	}
+	for _, tc := range cases {
+		if got := Parse(tc.content); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %v, want %v", tc.content, got, tc.want)
+		}
+	}
+}
+
+// fakeNotifications stands in for the notifications service and keeps the
+// notifications it holds, one per user and key. Like the real service, DELETE
+// only clears templateKey, and POST skips a key the user already has unless
+// forceCreate is set.
+type fakeNotifications struct {
+	mu    sync.Mutex
+	notes map[string]Notification // userID + " " + key
+	adds  int
+}
+
+func (f *fakeNotifications) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimPrefix(r.URL.Path, "/user/")
+	var n Notification
+	json.NewDecoder(r.Body).Decode(&n)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := userID + " " + n.Key
+	switch r.Method {
+	case http.MethodPost:
+		f.adds++
+		if _, exists := f.notes[k]; exists && !n.ForceCreate {
+			break
+		}
+		f.notes[k] = n
+	case http.MethodDelete:
+		if old, ok := f.notes[k]; ok {
+			old.TemplateKey = ""
+			f.notes[k] = old
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// users returns the users with a live notification for key.
+func (f *fakeNotifications) users(key string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for k, n := range f.notes {
+		if user, ok := strings.CutSuffix(k, " "+key); ok && n.TemplateKey != "" {
+			out = append(out, user)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestServiceKeepsNotificationsInStep(t *testing.T) {
+	fake := &fakeNotifications{notes: map[string]Notification{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s := &Service{
+		Directory: StaticDirectory{"alice": "u-alice", "bob": "u-bob", "author": "u-author"},
+		Notifier:  NewHTTPNotifier(srv.URL),
+	}
+	ctx := context.Background()
+	key := NotificationKey("m1")
+	msg := repository.Message{ID: "m1", UserID: "u-author", Content: "@alice @alice @author @nobody have a look"}
+
+	s.MessageCreated(ctx, "p1", "t1", msg)
+	if got := fake.users(key); !reflect.DeepEqual(got, []string{"u-alice"}) {
+		t.Fatalf("after create: notified %v", got)
+	}
+	n := fake.notes["u-alice "+key]
+	if n.TemplateKey != TemplateKey || n.MessageOpts["projectId"] != "p1" || n.MessageOpts["threadId"] != "t1" || n.MessageOpts["userId"] != "u-author" {
+		t.Fatalf("unexpected notification %+v", n)
+	}
+
+	edited := msg
+	edited.Content = "@bob have a look, @alice"
+	s.MessageEdited(ctx, "p1", "t1", msg, edited)
+	if fake.adds != 2 {
+		t.Fatalf("expected only bob to be notified again, got %d adds", fake.adds)
+	}
+
+	removed := edited
+	removed.Content = "@bob have a look"
+	s.MessageEdited(ctx, "p1", "t1", edited, removed)
+	if got := fake.users(key); !reflect.DeepEqual(got, []string{"u-bob"}) {
+		t.Fatalf("after editing alice out: notified %v", got)
+	}
+
+	// the service still holds alice's cleared notification; mentioning her
+	// again must bring it back
+	readded := removed
+	readded.Content = "@bob have a look, @alice"
+	s.MessageEdited(ctx, "p1", "t1", removed, readded)
+	if got := fake.users(key); !reflect.DeepEqual(got, []string{"u-alice", "u-bob"}) {
+		t.Fatalf("after mentioning alice again: notified %v", got)
+	}
+
+	s.MessageDeleted(ctx, "p1", "t1", readded)
+	if got := fake.users(key); len(got) != 0 {
+		t.Fatalf("after delete: notified %v", got)
+	}
+}
+
+// countingDirectory resolves every handle and records how many it was asked
+// for.
+type countingDirectory struct{ asked int }
+
+func (d *countingDirectory) Resolve(ctx context.Context, projectID string, handles []string) (map[string]string, error) {
+	d.asked += len(handles)
+	out := map[string]string{}
+	for _, h := range handles {
+		out[h] = "u-" + h
+	}
+	return out, nil
+}
+
+func TestQueuedChangesAreAppliedByTheDispatcher(t *testing.T) {
+	fake := &fakeNotifications{notes: map[string]Notification{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	dir := &countingDirectory{}
+	s := &Service{Directory: dir, Notifier: NewHTTPNotifier(srv.URL)}
+	s.Queue = events.NewBus(events.NewMemoryOutbox(10), s)
+	ctx := context.Background()
+
+	content := ""
+	for i := 0; i < MaxHandles+5; i++ {
+		content += fmt.Sprintf("@user%d ", i)
+	}
+	s.MessageCreated(ctx, "p1", "t1", repository.Message{ID: "m1", UserID: "u-author", Content: content})
+	if dir.asked != 0 || len(fake.users(NotificationKey("m1"))) != 0 {
+		t.Fatalf("expected nothing to happen before dispatch, resolved %d handles", dir.asked)
+	}
+	s.Queue.Dispatch(ctx, time.Now())
+	if dir.asked != MaxHandles {
+		t.Fatalf("expected %d handles resolved, got %d", MaxHandles, dir.asked)
+	}
+	if got := fake.users(NotificationKey("m1")); len(got) != MaxHandles {
+		t.Fatalf("expected %d notifications, got %d", MaxHandles, len(got))
+	}
+}
+
+func TestPublishRejectsForeignEvents(t *testing.T) {
+	s := &Service{Directory: StaticDirectory{}, Notifier: NewHTTPNotifier("http://127.0.0.1:1")}
+	if err := s.Publish(context.Background(), events.Event{ProjectID: "p1", Message: events.NewChatMessage}); !errors.Is(err, events.ErrRejected) {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("é", maxExcerpt)
+	got := excerpt(long)
+	if len(got) > maxExcerpt+len("…") || !strings.HasSuffix(got, "…") || !strings.HasPrefix(long, strings.TrimSuffix(got, "…")) {
+		t.Fatalf("bad excerpt %q", got)
+	}
+}
+
+func TestMongoDirectoryResolvesProjectMembersOnly(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	db := client.Database("chat_mentions_test")
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	owner, editor, twinA, twinB, outsider := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	db.Collection("users").InsertMany(ctx, []interface{}{
+		bson.M{"_id": owner, "email": "owner@example.com"},
+		bson.M{"_id": editor, "email": "Alice@example.com"},
+		bson.M{"_id": twinA, "email": "sam@example.com"},
+		bson.M{"_id": twinB, "email": "sam@example.org"},
+		bson.M{"_id": outsider, "email": "eve@example.com"},
+	})
+	project := primitive.NewObjectID()
+	db.Collection("projects").InsertOne(ctx, bson.M{
+		"_id": project, "owner_ref": owner,
+		"collaberator_refs": bson.A{editor, twinA}, "readOnly_refs": bson.A{twinB},
+	})
+
+	got, err := NewMongoDirectory(db).Resolve(ctx, project.Hex(), []string{"owner", "alice", "sam", "eve", "nobody"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	want := map[string]string{"owner": owner.Hex(), "alice": editor.Hex()}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Resolve = %v, want %v", got, want)
+	}
+	if got, _ := NewMongoDirectory(db).Resolve(ctx, primitive.NewObjectID().Hex(), []string{"owner"}); len(got) != 0 {
+		t.Fatalf("expected nothing resolved in an unknown project, got %v", got)
+	}
+}
@@ -0,0 +1,70 @@
+package mentions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Notification is the body of the notifications service's
+// POST /user/:user_id. The service keeps one notification per user and key,
+// and its DELETE only clears templateKey, so a removed notification still
+// holds its key. ForceCreate makes POST replace it instead of skipping it.
+type Notification struct {
+	Key         string            `json:"key"`
+	TemplateKey string            `json:"templateKey"`
+	MessageOpts map[string]string `json:"messageOpts"`
+	ForceCreate bool              `json:"forceCreate"`
+}
+
+// Notifier creates and removes user notifications.
+type Notifier interface {
+	Add(ctx context.Context, userID string, n Notification) error
+	Remove(ctx context.Context, userID, key string) error
+}
+
+// HTTPNotifier is a Notifier for the notifications service at BaseURL.
+type HTTPNotifier struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewHTTPNotifier returns a notifier for the service at baseURL.
+func NewHTTPNotifier(baseURL string) *HTTPNotifier {
+	return &HTTPNotifier{BaseURL: baseURL, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Add is POST /user/:user_id with the notification as body.
+func (n *HTTPNotifier) Add(ctx context.Context, userID string, notification Notification) error {
+	return n.do(ctx, http.MethodPost, userID, notification)
+}
+
+// Remove is DELETE /user/:user_id with {"key"}.
+func (n *HTTPNotifier) Remove(ctx context.Context, userID, key string) error {
+	return n.do(ctx, http.MethodDelete, userID, map[string]string{"key": key})
+}
+
+func (n *HTTPNotifier) do(ctx context.Context, method, userID string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, n.BaseURL+"/user/"+url.PathEscape(userID), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s /user/%s: status %d", method, userID, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,559 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runConformance checks the ChatRepository contract. Every implementation
+// must pass it; ids are ObjectIds so the Mongo schema accepts them.
+func runConformance(t *testing.T, repo ChatRepository) {
+	ctx := context.Background()
+	newID := func() string { return primitive.NewObjectID().Hex() }
+	userA, userB := newID(), newID()
+	var clock int64 = 1_700_000_000_000
+	msg := func(user, content string) Message {
+		clock++
+		return Message{ID: newID(), Content: content, UserID: user, Timestamp: clock}
+	}
+
+	t.Run("messages are appended and scoped", func(t *testing.T) {
+		pid, other, tid := newID(), newID(), newID()
+		first, second := msg(userA, "first"), msg(userB, "second")
+		for _, m := range []Message{first, second} {
+			if err := repo.CreateMessage(ctx, pid, tid, m); err != nil {
+				t.Fatalf("CreateMessage: %v", err)
+			}
+		}
+		repo.CreateMessage(ctx, other, tid, msg(userA, "other project"))
+		repo.CreateMessage(ctx, pid, GlobalThread, msg(userA, "global"))
+
+		got, err := repo.ListMessages(ctx, pid, tid, Page{})
+		if err != nil || len(got) != 2 || !reflect.DeepEqual(got, []Message{first, second}) {
+			t.Fatalf("ListMessages = %+v, %v", got, err)
+		}
+		global, _ := repo.ListMessages(ctx, pid, GlobalThread, Page{})
+		if len(global) != 1 || global[0].Content != "global" {
+			t.Fatalf("global messages = %+v", global)
+		}
+		if none, err := repo.ListMessages(ctx, pid, newID(), Page{}); err != nil || len(none) != 0 {
+			t.Fatalf("missing thread = %+v, %v", none, err)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		pid, tid := newID(), newID()
+		var all []Message
+		for i := 0; i < 5; i++ {
+			m := msg(userA, "page")
+			all = append(all, m)
+			repo.CreateMessage(ctx, pid, tid, m)
+		}
+		for _, tc := range []struct {
+			page Page
+			want []Message
+		}{
+			{Page{}, all},
+			{Page{Limit: 2}, all[3:]},
+			{Page{Before: all[3].Timestamp}, all[:3]},
+			{Page{Before: all[3].Timestamp, Limit: 2}, all[1:3]},
+			{Page{Before: all[0].Timestamp, Limit: 2}, nil},
+		} {
+			got, err := repo.ListMessages(ctx, pid, tid, tc.page)
+			if err != nil || len(got) != len(tc.want) {
+				t.Fatalf("ListMessages(%+v) = %+v, %v", tc.page, got, err)
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tc.want[i]) {
+					t.Fatalf("ListMessages(%+v)[%d] = %+v, want %+v", tc.page, i, got[i], tc.want[i])
+				}
+			}
+		}
+	})
+
+	t.Run("get, update and delete", func(t *testing.T) {
+		pid, tid := newID(), newID()
+		m := msg(userA, "hello")
+		repo.CreateMessage(ctx, pid, tid, m)
+
+		if got, err := repo.GetMessage(ctx, pid, tid, m.ID); err != nil || !reflect.DeepEqual(got, m) {
+			t.Fatalf("GetMessage = %+v, %v", got, err)
+		}
+		if _, err := repo.GetMessage(ctx, pid, tid, newID()); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetMessage missing: %v", err)
+		}
+		if err := repo.UpdateMessage(ctx, pid, tid, m.ID, userB, "hijack", 1); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateMessage by another user: %v", err)
+		}
+		if err := repo.UpdateMessage(ctx, pid, tid, m.ID, userA, "edited", 42); err != nil {
+			t.Fatalf("UpdateMessage: %v", err)
+		}
+		if err := repo.UpdateMessage(ctx, pid, tid, newID(), "", "x", 1); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateMessage missing: %v", err)
+		}
+		if got, _ := repo.GetMessage(ctx, pid, tid, m.ID); got.Content != "edited" || got.EditedAt != 42 {
+			t.Fatalf("after update = %+v", got)
+		}
+		if err := repo.DeleteMessage(ctx, pid, tid, m.ID, userB, 50); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("DeleteMessage by another user: %v", err)
+		}
+		if err := repo.DeleteMessage(ctx, pid, tid, m.ID, userA, 50); err != nil {
+			t.Fatalf("DeleteMessage: %v", err)
+		}
+		if err := repo.DeleteMessage(ctx, pid, tid, m.ID, "", 60); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("DeleteMessage twice: %v", err)
+		}
+		if err := repo.UpdateMessage(ctx, pid, tid, m.ID, "", "revived", 70); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateMessage after delete: %v", err)
+		}
+		tomb, err := repo.GetMessage(ctx, pid, tid, m.ID)
+		if err != nil || tomb.Content != DeletedContent || tomb.DeletedAt != 50 || tomb.UserID != userA || tomb.Timestamp != m.Timestamp {
+			t.Fatalf("tombstone = %+v, %v", tomb, err)
+		}
+		if msgs, _ := repo.ListMessages(ctx, pid, tid, Page{}); len(msgs) != 1 || !reflect.DeepEqual(msgs[0], tomb) {
+			t.Fatalf("ListMessages after delete = %+v", msgs)
+		}
+
+		revs, err := repo.MessageRevisions(ctx, pid, tid, m.ID)
+		want := []Revision{
+			{Content: "hello", Timestamp: m.Timestamp, ReplacedAt: 42},
+			{Content: "edited", Timestamp: 42, ReplacedAt: 50},
+		}
+		if err != nil || len(revs) != 2 || revs[0] != want[0] || revs[1] != want[1] {
+			t.Fatalf("MessageRevisions = %+v, %v", revs, err)
+		}
+		if _, err := repo.MessageRevisions(ctx, pid, tid, newID()); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("MessageRevisions missing: %v", err)
+		}
+
+		// other tests share the repository, so only count this message's revisions
+		repo.PurgeRevisions(ctx, 43)
+		if revs, _ := repo.MessageRevisions(ctx, pid, tid, m.ID); len(revs) != 1 || revs[0] != want[1] {
+			t.Fatalf("MessageRevisions after purge = %+v", revs)
+		}
+	})
+
+	t.Run("reactions", func(t *testing.T) {
+		pid, tid := newID(), newID()
+		m := msg(userA, "nice")
+		repo.CreateMessage(ctx, pid, tid, m)
+		for _, r := range []struct{ user, emoji string }{
+			{userA, "👍"}, {userB, "👍"}, {userA, "👍"}, {userB, "🎉"},
+		} {
+			if err := repo.AddReaction(ctx, pid, tid, m.ID, r.user, r.emoji); err != nil {
+				t.Fatalf("AddReaction: %v", err)
+			}
+		}
+		got, _ := repo.GetMessage(ctx, pid, tid, m.ID)
+		want := []Reaction{
+			{Emoji: "👍", Count: 2, UserIDs: []string{userA, userB}},
+			{Emoji: "🎉", Count: 1, UserIDs: []string{userB}},
+		}
+		if !reflect.DeepEqual(got.Reactions, want) {
+			t.Fatalf("reactions = %+v", got.Reactions)
+		}
+
+		repo.RemoveReaction(ctx, pid, tid, m.ID, userB, "🎉")
+		repo.RemoveReaction(ctx, pid, tid, m.ID, userA, "👍")
+		if err := repo.RemoveReaction(ctx, pid, tid, m.ID, userA, "👍"); err != nil {
+			t.Fatalf("RemoveReaction twice: %v", err)
+		}
+		msgs, _ := repo.ListMessages(ctx, pid, tid, Page{})
+		if want := []Reaction{{Emoji: "👍", Count: 1, UserIDs: []string{userB}}}; !reflect.DeepEqual(msgs[0].Reactions, want) {
+			t.Fatalf("reactions after removal = %+v", msgs[0].Reactions)
+		}
+
+		if err := repo.AddReaction(ctx, pid, tid, newID(), userA, "👍"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("AddReaction on a missing message: %v", err)
+		}
+		repo.DeleteMessage(ctx, pid, tid, m.ID, "", 1)
+		if err := repo.AddReaction(ctx, pid, tid, m.ID, userA, "👍"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("AddReaction on a deleted message: %v", err)
+		}
+		if tomb, _ := repo.GetMessage(ctx, pid, tid, m.ID); tomb.Reactions != nil {
+			t.Fatalf("tombstone reactions = %+v", tomb.Reactions)
+		}
+	})
+
+	t.Run("reaction limits", func(t *testing.T) {
+		pid, tid := newID(), newID()
+		m := msg(userA, "busy")
+		repo.CreateMessage(ctx, pid, tid, m)
+		emoji := func(i int) string { return string(rune(0x1F600 + i)) }
+		for i := 0; i < MaxEmojisPerUser; i++ {
+			if err := repo.AddReaction(ctx, pid, tid, m.ID, userA, emoji(i)); err != nil {
+				t.Fatalf("AddReaction %d: %v", i, err)
+			}
+		}
+		if err := repo.AddReaction(ctx, pid, tid, m.ID, userA, emoji(MaxEmojisPerUser)); !errors.Is(err, ErrTooManyReactions) {
+			t.Fatalf("emoji beyond the per-user limit: %v", err)
+		}
+		if err := repo.AddReaction(ctx, pid, tid, m.ID, userA, emoji(0)); err != nil {
+			t.Fatalf("re-adding an emoji at the per-user limit: %v", err)
+		}
+
+		// other users fill the message up to its limit
+		for i := MaxEmojisPerUser; i < MaxEmojisPerMessage; i++ {
+			if err := repo.AddReaction(ctx, pid, tid, m.ID, newID(), emoji(i)); err != nil {
+				t.Fatalf("AddReaction %d: %v", i, err)
+			}
+		}
+		if err := repo.AddReaction(ctx, pid, tid, m.ID, userB, emoji(MaxEmojisPerMessage)); !errors.Is(err, ErrTooManyReactions) {
+			t.Fatalf("emoji beyond the per-message limit: %v", err)
+		}
+		if err := repo.AddReaction(ctx, pid, tid, m.ID, userB, emoji(0)); err != nil {
+			t.Fatalf("joining an existing emoji on a full message: %v", err)
+		}
+		got, _ := repo.GetMessage(ctx, pid, tid, m.ID)
+		if len(got.Reactions) != MaxEmojisPerMessage || got.Reactions[0].Count != 2 {
+			t.Fatalf("reactions = %+v", got.Reactions)
+		}
+	})
+
+	t.Run("concurrent reactions", func(t *testing.T) {
+		pid, tid := newID(), newID()
+		m := msg(userA, "popular")
+		repo.CreateMessage(ctx, pid, tid, m)
+		users := make([]string, 20)
+		var wg sync.WaitGroup
+		for i := range users {
+			users[i] = newID()
+			wg.Add(1)
+			go func(user string) {
+				defer wg.Done()
+				repo.AddReaction(ctx, pid, tid, m.ID, user, "👍")
+				repo.AddReaction(ctx, pid, tid, m.ID, user, "👍")
+			}(users[i])
+		}
+		wg.Wait()
+		got, _ := repo.GetMessage(ctx, pid, tid, m.ID)
+		if len(got.Reactions) != 1 || got.Reactions[0].Count != len(users) {
+			t.Fatalf("reactions = %+v", got.Reactions)
+		}
+	})
+
+	t.Run("threads and resolution", func(t *testing.T) {
+		pid, t1, t2 := newID(), newID(), newID()
+		repo.CreateMessage(ctx, pid, t1, msg(userA, "one"))
+		repo.CreateMessage(ctx, pid, t2, msg(userA, "two"))
+		repo.CreateMessage(ctx, pid, GlobalThread, msg(userA, "global"))
+
+		all, err := repo.Threads(ctx, pid, nil)
+		if err != nil || len(all) != 2 {
+			t.Fatalf("Threads(all) = %+v, %v", all, err)
+		}
+		some, _ := repo.Threads(ctx, pid, []string{t2, newID()})
+		if len(some) != 1 || some[0].ID != t2 || some[0].Messages[0].Content != "two" {
+			t.Fatalf("Threads(t2) = %+v", some)
+		}
+
+		at := time.Now().UTC().Truncate(time.Millisecond)
+		if err := repo.ResolveThread(ctx, pid, t1, Resolution{UserID: userB, At: at}); err != nil {
+			t.Fatalf("ResolveThread: %v", err)
+		}
+		// resolving a thread that does not exist is a no-op
+		repo.ResolveThread(ctx, pid, newID(), Resolution{UserID: userB, At: at})
+		ids, _ := repo.ResolvedThreadIDs(ctx, pid)
+		if len(ids) != 1 || ids[0] != t1 {
+			t.Fatalf("ResolvedThreadIDs = %v", ids)
+		}
+		resolved, _ := repo.Threads(ctx, pid, []string{t1})
+		if r := resolved[0].Resolved; r == nil || r.UserID != userB || !r.At.Equal(at) {
+			t.Fatalf("resolution = %+v", r)
+		}
+
+		// resolving again updates the resolution but is not a transition
+		repo.ResolveThread(ctx, pid, t1, Resolution{UserID: userA, At: at})
+		if err := repo.ReopenThread(ctx, pid, t1, userA, at.Add(time.Second)); err != nil {
+			t.Fatalf("ReopenThread: %v", err)
+		}
+		repo.ReopenThread(ctx, pid, t1, userA, at.Add(2*time.Second))
+		if ids, _ := repo.ResolvedThreadIDs(ctx, pid); len(ids) != 0 {
+			t.Fatalf("ResolvedThreadIDs after reopen = %v", ids)
+		}
+		history, err := repo.ThreadHistory(ctx, pid, t1)
+		if err != nil || len(history) != 2 ||
+			history[0].Action != ActionResolve || history[0].UserID != userB || !history[0].At.Equal(at) ||
+			history[1].Action != ActionReopen || history[1].UserID != userA || !history[1].At.Equal(at.Add(time.Second)) {
+			t.Fatalf("ThreadHistory = %+v, %v", history, err)
+		}
+
+		if err := repo.DeleteThread(ctx, pid, t2); err != nil {
+			t.Fatalf("DeleteThread: %v", err)
+		}
+		if msgs, _ := repo.ListMessages(ctx, pid, t2, Page{}); len(msgs) != 0 {
+			t.Fatalf("messages after DeleteThread = %+v", msgs)
+		}
+		repo.DeleteThread(ctx, pid, t1)
+		if history, _ := repo.ThreadHistory(ctx, pid, t1); len(history) != 0 {
+			t.Fatalf("history after DeleteThread = %+v", history)
+		}
+	})
+
+	t.Run("each thread", func(t *testing.T) {
+		pid, t1, t2 := newID(), newID(), newID()
+		repo.CreateMessage(ctx, pid, t2, msg(userA, "two"))
+		repo.CreateMessage(ctx, pid, t1, msg(userA, "one"))
+		repo.CreateMessage(ctx, pid, t1, msg(userB, "one again"))
+		repo.CreateMessage(ctx, pid, GlobalThread, msg(userA, "global"))
+		repo.ResolveThread(ctx, pid, t2, Resolution{UserID: userB, At: time.Now()})
+
+		want, _ := repo.Threads(ctx, pid, nil)
+		var got []Thread
+		if err := repo.EachThread(ctx, pid, func(th Thread) error {
+			got = append(got, th)
+			return nil
+		}); err != nil {
+			t.Fatalf("EachThread: %v", err)
+		}
+		if len(got) != 2 || got[0].ID >= got[1].ID || !reflect.DeepEqual(got, want) {
+			t.Fatalf("EachThread = %+v, Threads = %+v", got, want)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err := repo.EachThread(ctx, pid, func(Thread) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Fatalf("EachThread should stop at the first error, got %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("duplicate threads", func(t *testing.T) {
+		pid, open, resolved, missing := newID(), newID(), newID(), newID()
+		src := msg(userA, "copy me")
+		repo.CreateMessage(ctx, pid, open, src)
+		repo.CreateMessage(ctx, pid, open, msg(userB, "and me"))
+		repo.CreateMessage(ctx, pid, resolved, msg(userB, "resolved"))
+		repo.CreateMessage(ctx, pid, GlobalThread, msg(userB, "global"))
+		at := time.Now().UTC().Truncate(time.Millisecond)
+		repo.ResolveThread(ctx, pid, resolved, Resolution{UserID: userA, At: at})
+
+		copies, err := repo.DuplicateThreads(ctx, pid, []string{open, resolved, missing, open, GlobalThread})
+		if err != nil || len(copies) != 2 || copies[open] == "" || copies[resolved] == "" || copies[open] == open {
+			t.Fatalf("DuplicateThreads = %v, %v", copies, err)
+		}
+		again, _ := repo.DuplicateThreads(ctx, pid, []string{open})
+		if again[open] == copies[open] {
+			t.Fatalf("duplicating twice reused thread id %s", again[open])
+		}
+
+		threads, _ := repo.Threads(ctx, pid, []string{open, copies[open], copies[resolved]})
+		if len(threads) != 3 {
+			t.Fatalf("threads = %+v", threads)
+		}
+		byID := map[string]Thread{}
+		for _, th := range threads {
+			byID[th.ID] = th
+		}
+		orig, dup := byID[open], byID[copies[open]]
+		if len(dup.Messages) != 2 || dup.Resolved != nil {
+			t.Fatalf("duplicated thread = %+v", dup)
+		}
+		for i, m := range dup.Messages {
+			o := orig.Messages[i]
+			if m.ID == o.ID || m.Content != o.Content || m.UserID != o.UserID || m.Timestamp != o.Timestamp {
+				t.Fatalf("message %d: copy %+v of %+v", i, m, o)
+			}
+		}
+		if r := byID[copies[resolved]].Resolved; r == nil || r.UserID != userA || !r.At.Equal(at) {
+			t.Fatalf("expected the resolution to be copied, got %+v", r)
+		}
+
+		// copies are independent of their source
+		repo.UpdateMessage(ctx, pid, copies[open], dup.Messages[0].ID, "", "changed", clock+1)
+		if m, _ := repo.GetMessage(ctx, pid, open, src.ID); m.Content != "copy me" {
+			t.Fatalf("editing the copy changed the source: %+v", m)
+		}
+
+		none, err := repo.DuplicateThreads(ctx, pid, []string{missing})
+		if err != nil || len(none) != 0 {
+			t.Fatalf("DuplicateThreads(missing) = %v, %v", none, err)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		pid, other, open, resolved := newID(), newID(), newID(), newID()
+		figure := msg(userA, "The axis of Figure 3 is wrong")
+		later := msg(userB, "figure 3 again, see figure 2")
+		edited := msg(userA, "nothing to see")
+		deleted := msg(userA, "figure 3 deleted")
+		chat := msg(userB, "Figure 3 in chat")
+		underscored := msg(userA, "see fig_3 below")
+		repo.CreateMessage(ctx, pid, open, figure)
+		repo.CreateMessage(ctx, pid, open, underscored)
+		repo.CreateMessage(ctx, pid, resolved, later)
+		repo.CreateMessage(ctx, pid, resolved, edited)
+		repo.CreateMessage(ctx, pid, open, deleted)
+		repo.CreateMessage(ctx, pid, GlobalThread, chat)
+		repo.CreateMessage(ctx, other, open, msg(userA, "figure 3 elsewhere"))
+		repo.ResolveThread(ctx, pid, resolved, Resolution{UserID: userA, At: time.Now()})
+		repo.UpdateMessage(ctx, pid, resolved, edited.ID, "", "Figure 3, edited", clock+10)
+		repo.DeleteMessage(ctx, pid, open, deleted.ID, "", clock+11)
+		edited.Content, edited.EditedAt = "Figure 3, edited", clock+10
+
+		yes, no := true, false
+		for _, tc := range []struct {
+			name string
+			q    SearchQuery
+			want []string
+		}{
+			{"all", SearchQuery{Terms: []string{"figure", "3"}}, []string{chat.ID, edited.ID, later.ID, figure.ID}},
+			{"every term", SearchQuery{Terms: []string{"figure", "2"}}, []string{later.ID}},
+			{"whole tokens", SearchQuery{Terms: []string{"fig"}}, nil},
+			{"underscores join", SearchQuery{Terms: []string{"fig_3"}}, []string{underscored.ID}},
+			{"limit", SearchQuery{Terms: []string{"figure"}, Limit: 2}, []string{chat.ID, edited.ID}},
+			{"thread", SearchQuery{Terms: []string{"figure"}, ThreadID: open}, []string{figure.ID}},
+			{"global", SearchQuery{Terms: []string{"figure"}, ThreadID: GlobalThread}, []string{chat.ID}},
+			{"author", SearchQuery{Terms: []string{"figure"}, UserID: userB}, []string{chat.ID, later.ID}},
+			{"dates", SearchQuery{Terms: []string{"figure"}, From: figure.Timestamp, To: later.Timestamp}, []string{later.ID, figure.ID}},
+			{"resolved", SearchQuery{Terms: []string{"figure"}, Resolved: &yes}, []string{edited.ID, later.ID}},
+			{"open", SearchQuery{Terms: []string{"figure"}, Resolved: &no}, []string{figure.ID}},
+			{"old content", SearchQuery{Terms: []string{"nothing"}}, nil},
+		} {
+			hits, err := repo.Search(ctx, pid, tc.q)
+			if err != nil {
+				t.Fatalf("%s: Search: %v", tc.name, err)
+			}
+			var got []string
+			for _, h := range hits {
+				got = append(got, h.Message.ID)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+		hits, _ := repo.Search(ctx, pid, SearchQuery{Terms: []string{"edited"}})
+		if len(hits) != 1 || hits[0].ThreadID != resolved || !hits[0].Resolved || !reflect.DeepEqual(hits[0].Message, edited) {
+			t.Fatalf("hit = %+v", hits)
+		}
+
+		copies, _ := repo.DuplicateThreads(ctx, pid, []string{open})
+		dup := copies[open]
+		if hits, _ := repo.Search(ctx, pid, SearchQuery{Terms: []string{"axis"}}); len(hits) != 2 {
+			t.Fatalf("expected the duplicate to be found, got %+v", hits)
+		}
+		repo.DeleteThread(ctx, pid, dup)
+		repo.DeleteThread(ctx, pid, open)
+		if hits, _ := repo.Search(ctx, pid, SearchQuery{Terms: []string{"axis"}}); len(hits) != 0 {
+			t.Fatalf("expected no hits in deleted threads, got %+v", hits)
+		}
+	})
+
+	t.Run("delete project", func(t *testing.T) {
+		pid, other, tid := newID(), newID(), newID()
+		gone := msg(userA, "gone")
+		repo.CreateMessage(ctx, pid, tid, gone)
+		repo.CreateMessage(ctx, pid, GlobalThread, msg(userA, "gone too"))
+		repo.CreateMessage(ctx, other, tid, msg(userA, "kept"))
+		repo.UpdateMessage(ctx, pid, tid, gone.ID, "", "gone, edited", clock+1)
+		repo.ResolveThread(ctx, pid, tid, Resolution{UserID: userA, At: time.Now()})
+
+		d, err := repo.DeleteProject(ctx, pid)
+		if err != nil || d != (ProjectDeletion{Threads: 2, Messages: 2, Revisions: 1, History: 1}) {
+			t.Fatalf("DeleteProject = %+v, %v", d, err)
+		}
+		if d, err := repo.DeleteProject(ctx, pid); err != nil || d != (ProjectDeletion{}) {
+			t.Fatalf("DeleteProject again = %+v, %v", d, err)
+		}
+		if threads, _ := repo.Threads(ctx, pid, nil); len(threads) != 0 {
+			t.Fatalf("threads after DeleteProject = %+v", threads)
+		}
+		if msgs, _ := repo.ListMessages(ctx, pid, GlobalThread, Page{}); len(msgs) != 0 {
+			t.Fatalf("global messages after DeleteProject = %+v", msgs)
+		}
+		if msgs, _ := repo.ListMessages(ctx, other, tid, Page{}); len(msgs) != 1 {
+			t.Fatalf("other project's messages = %+v", msgs)
+		}
+		if hits, _ := repo.Search(ctx, pid, SearchQuery{Terms: []string{"gone"}}); len(hits) != 0 {
+			t.Fatalf("search after DeleteProject = %+v", hits)
+		}
+	})
+
+	// seedErasure gives a fresh user a message with a revision and a
+	// reaction in each of two projects, a reaction on someone else's
+	// message, and a resolve/reopen in the history.
+	seedErasure := func(t *testing.T) (user string, pids [2]string, tid string, others Message) {
+		user, pids, tid = newID(), [2]string{newID(), newID()}, newID()
+		for _, pid := range pids {
+			m := msg(user, "personal data")
+			repo.CreateMessage(ctx, pid, tid, m)
+			repo.UpdateMessage(ctx, pid, tid, m.ID, "", "personal data, edited", clock+1)
+			repo.AddReaction(ctx, pid, tid, m.ID, user, "👍")
+		}
+		others = msg(userB, "someone else's")
+		repo.CreateMessage(ctx, pids[0], tid, others)
+		repo.AddReaction(ctx, pids[0], tid, others.ID, user, "🎉")
+		repo.AddReaction(ctx, pids[0], tid, others.ID, userB, "🎉")
+		repo.ResolveThread(ctx, pids[0], tid, Resolution{UserID: user, At: time.Now()})
+		repo.ReopenThread(ctx, pids[0], tid, user, time.Now())
+		repo.ResolveThread(ctx, pids[1], tid, Resolution{UserID: user, At: time.Now()})
+		return
+	}
+
+	t.Run("erase user by deleting messages", func(t *testing.T) {
+		user, pids, tid, others := seedErasure(t)
+		e, err := repo.EraseUser(ctx, user, EraseDelete)
+		want := UserErasure{Messages: 2, Revisions: 2, Reactions: 3, Resolutions: 1, History: 3}
+		if err != nil || e != want {
+			t.Fatalf("EraseUser = %+v, %v; want %+v", e, err, want)
+		}
+		msgs, _ := repo.ListMessages(ctx, pids[0], tid, Page{})
+		if len(msgs) != 1 || msgs[0].ID != others.ID || len(msgs[0].Reactions) != 1 || !reflect.DeepEqual(msgs[0].Reactions[0].UserIDs, []string{userB}) {
+			t.Fatalf("messages after erasure = %+v", msgs)
+		}
+		if msgs, _ := repo.ListMessages(ctx, pids[1], tid, Page{}); len(msgs) != 0 {
+			t.Fatalf("messages after erasure = %+v", msgs)
+		}
+		threads, _ := repo.Threads(ctx, pids[1], []string{tid})
+		if len(threads) == 1 && (threads[0].Resolved == nil || threads[0].Resolved.UserID != DeletedUser) {
+			t.Fatalf("resolution after erasure = %+v", threads[0].Resolved)
+		}
+		for _, pid := range pids {
+			history, _ := repo.ThreadHistory(ctx, pid, tid)
+			for _, h := range history {
+				if h.UserID != DeletedUser {
+					t.Fatalf("history after erasure = %+v", history)
+				}
+			}
+		}
+		if hits, _ := repo.Search(ctx, pids[1], SearchQuery{Terms: []string{"personal"}}); len(hits) != 0 {
+			t.Fatalf("search after erasure = %+v", hits)
+		}
+		if e, err := repo.EraseUser(ctx, user, EraseDelete); err != nil || e != (UserErasure{}) {
+			t.Fatalf("EraseUser again = %+v, %v", e, err)
+		}
+	})
+
+	t.Run("erase user by anonymising messages", func(t *testing.T) {
+		user, pids, tid, _ := seedErasure(t)
+		e, err := repo.EraseUser(ctx, user, EraseAnonymise)
+		want := UserErasure{Messages: 2, Reactions: 3, Resolutions: 1, History: 3}
+		if err != nil || e != want {
+			t.Fatalf("EraseUser = %+v, %v; want %+v", e, err, want)
+		}
+		msgs, _ := repo.ListMessages(ctx, pids[1], tid, Page{})
+		if len(msgs) != 1 || msgs[0].UserID != DeletedUser || msgs[0].Content != "personal data, edited" || msgs[0].Reactions != nil {
+			t.Fatalf("messages after erasure = %+v", msgs)
+		}
+		if revs, _ := repo.MessageRevisions(ctx, pids[1], tid, msgs[0].ID); len(revs) != 1 {
+			t.Fatalf("revisions after anonymising = %+v", revs)
+		}
+		if e, err := repo.EraseUser(ctx, user, EraseAnonymise); err != nil || e != (UserErasure{}) {
+			t.Fatalf("EraseUser again = %+v, %v", e, err)
+		}
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	runConformance(t, NewMemory(store.New()))
+}
@@ -0,0 +1,563 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/search"
+	"github.com/davrot/gogotex_at_work/services/chat/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Memory is a ChatRepository on top of internal/store. The messages of a
+// thread are one JSON array under messages:<projectId>:<threadId>; a thread
+// exists while that key does. Resolution state lives under
+// resolved:<projectId>:<threadId> and its transitions under
+// history:<projectId>:<threadId>. Message revisions are kept under
+// revisions:<projectId>:<threadId>:<messageId>. The search index maps each
+// token to the messages containing it, as a JSON object of thread id to
+// message ids under search:<projectId>:<token>. Ids are free-form.
+type Memory struct {
+	s *store.Store
+	// mu serialises read-modify-write of the JSON values.
+	mu sync.Mutex
+}
+
+// NewMemory returns a repository backed by s.
+func NewMemory(s *store.Store) *Memory {
+	return &Memory{s: s}
+}
+
+func messagesKey(projectID, threadID string) string {
+	return "messages:" + projectID + ":" + threadID
+}
+
+func resolvedKey(projectID, threadID string) string {
+	return "resolved:" + projectID + ":" + threadID
+}
+
+func historyKey(projectID, threadID string) string {
+	return "history:" + projectID + ":" + threadID
+}
+
+func revisionsKey(projectID, threadID, messageID string) string {
+	return "revisions:" + projectID + ":" + threadID + ":" + messageID
+}
+
+func searchKey(projectID, token string) string {
+	return "search:" + projectID + ":" + token
+}
+
+func (m *Memory) load(projectID, threadID string) ([]Message, bool) {
+	val, ok := m.s.Get(messagesKey(projectID, threadID))
+	if !ok {
+		return nil, false
+	}
+	var msgs []Message
+	_ = json.Unmarshal([]byte(val), &msgs)
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Timestamp < msgs[j].Timestamp })
+	return msgs, true
+}
+
+func (m *Memory) save(projectID, threadID string, msgs []Message) {
+	b, _ := json.Marshal(msgs)
+	m.s.Put(messagesKey(projectID, threadID), string(b))
+}
+
+func (m *Memory) CreateMessage(ctx context.Context, projectID, threadID string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs, _ := m.load(projectID, threadID)
+	m.save(projectID, threadID, append(msgs, msg))
+	m.reindex(projectID, threadID, msg.ID, "", msg.Content)
+	return nil
+}
+
+func (m *Memory) ListMessages(ctx context.Context, projectID, threadID string, page Page) ([]Message, error) {
+	msgs, _ := m.load(projectID, threadID)
+	return pageOf(msgs, page), nil
+}
+
+// pageOf cuts page out of msgs, which are sorted oldest first.
+func pageOf(msgs []Message, page Page) []Message {
+	end := len(msgs)
+	if page.Before > 0 {
+		end = sort.Search(len(msgs), func(i int) bool { return msgs[i].Timestamp >= page.Before })
+	}
+	start := 0
+	if page.Limit > 0 && end > page.Limit {
+		start = end - page.Limit
+	}
+	return msgs[start:end]
+}
+
+func (m *Memory) GetMessage(ctx context.Context, projectID, threadID, messageID string) (Message, error) {
+	msgs, _ := m.load(projectID, threadID)
+	for _, msg := range msgs {
+		if msg.ID == messageID {
+			return msg, nil
+		}
+	}
+	return Message{}, ErrNotFound
+}
+
+func (m *Memory) UpdateMessage(ctx context.Context, projectID, threadID, messageID, userID, content string, editedAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs, _ := m.load(projectID, threadID)
+	for i := range msgs {
+		if msgs[i].ID == messageID && (userID == "" || msgs[i].UserID == userID) && msgs[i].DeletedAt == 0 {
+			m.addRevision(projectID, threadID, messageID, revisionOf(msgs[i], editedAt))
+			m.reindex(projectID, threadID, messageID, msgs[i].Content, content)
+			msgs[i].Content = content
+			msgs[i].EditedAt = editedAt
+			m.save(projectID, threadID, msgs)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) DeleteMessage(ctx context.Context, projectID, threadID, messageID, userID string, deletedAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs, _ := m.load(projectID, threadID)
+	for i := range msgs {
+		if msgs[i].ID == messageID && (userID == "" || msgs[i].UserID == userID) && msgs[i].DeletedAt == 0 {
+			m.addRevision(projectID, threadID, messageID, revisionOf(msgs[i], deletedAt))
+			m.reindex(projectID, threadID, messageID, msgs[i].Content, "")
+			msgs[i].Content = DeletedContent
+			msgs[i].DeletedAt = deletedAt
+			msgs[i].Reactions = nil
+			m.save(projectID, threadID, msgs)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) AddReaction(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error {
+	return m.react(projectID, threadID, messageID, func(rs []Reaction) ([]Reaction, error) {
+		if err := checkReactionLimits(rs, emoji, userID); err != nil {
+			return nil, err
+		}
+		return withReaction(rs, emoji, userID), nil
+	})
+}
+
+func (m *Memory) RemoveReaction(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error {
+	return m.react(projectID, threadID, messageID, func(rs []Reaction) ([]Reaction, error) {
+		return withoutReaction(rs, emoji, userID), nil
+	})
+}
+
+// react applies change to the reactions of a live message under mu.
+func (m *Memory) react(projectID, threadID, messageID string, change func([]Reaction) ([]Reaction, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs, _ := m.load(projectID, threadID)
+	for i := range msgs {
+		if msgs[i].ID == messageID && msgs[i].DeletedAt == 0 {
+			rs, err := change(msgs[i].Reactions)
+			if err != nil {
+				return err
+			}
+			msgs[i].Reactions = rs
+			m.save(projectID, threadID, msgs)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// addRevision appends r to a message's revisions; callers hold mu.
+func (m *Memory) addRevision(projectID, threadID, messageID string, r Revision) {
+	revs := m.revisions(revisionsKey(projectID, threadID, messageID))
+	b, _ := json.Marshal(append(revs, r))
+	m.s.Put(revisionsKey(projectID, threadID, messageID), string(b))
+}
+
+func (m *Memory) revisions(key string) []Revision {
+	var revs []Revision
+	if val, ok := m.s.Get(key); ok {
+		_ = json.Unmarshal([]byte(val), &revs)
+	}
+	return revs
+}
+
+func (m *Memory) MessageRevisions(ctx context.Context, projectID, threadID, messageID string) ([]Revision, error) {
+	if _, err := m.GetMessage(ctx, projectID, threadID, messageID); err != nil {
+		return nil, err
+	}
+	return m.revisions(revisionsKey(projectID, threadID, messageID)), nil
+}
+
+func (m *Memory) PurgeRevisions(ctx context.Context, replacedBefore int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var purged int64
+	for k := range m.s.List() {
+		if !strings.HasPrefix(k, "revisions:") {
+			continue
+		}
+		revs := m.revisions(k)
+		kept := revs[:0]
+		for _, r := range revs {
+			if r.ReplacedAt < replacedBefore {
+				purged++
+				continue
+			}
+			kept = append(kept, r)
+		}
+		switch {
+		case len(kept) == 0:
+			m.s.Delete(k)
+		case len(kept) < len(revs):
+			b, _ := json.Marshal(kept)
+			m.s.Put(k, string(b))
+		}
+	}
+	return purged, nil
+}
+
+// threadIDs lists the comment threads of a project.
+func (m *Memory) threadIDs(projectID string) []string {
+	prefix := messagesKey(projectID, "")
+	var ids []string
+	for k := range m.s.List() {
+		if tid, ok := strings.CutPrefix(k, prefix); ok && tid != GlobalThread {
+			ids = append(ids, tid)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (m *Memory) resolution(projectID, threadID string) *Resolution {
+	val, ok := m.s.Get(resolvedKey(projectID, threadID))
+	if !ok {
+		return nil
+	}
+	var r Resolution
+	_ = json.Unmarshal([]byte(val), &r)
+	return &r
+}
+
+func (m *Memory) Threads(ctx context.Context, projectID string, threadIDs []string) ([]Thread, error) {
+	if threadIDs == nil {
+		threadIDs = m.threadIDs(projectID)
+	}
+	out := []Thread{}
+	for _, tid := range threadIDs {
+		msgs, ok := m.load(projectID, tid)
+		if !ok || tid == GlobalThread {
+			continue
+		}
+		out = append(out, Thread{ID: tid, Messages: msgs, Resolved: m.resolution(projectID, tid)})
+	}
+	return out, nil
+}
+
+func (m *Memory) EachThread(ctx context.Context, projectID string, fn func(Thread) error) error {
+	for _, tid := range m.threadIDs(projectID) {
+		msgs, ok := m.load(projectID, tid)
+		if !ok {
+			continue
+		}
+		if err := fn(Thread{ID: tid, Messages: msgs, Resolved: m.resolution(projectID, tid)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ResolveThread(ctx context.Context, projectID, threadID string, r Resolution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.s.Get(messagesKey(projectID, threadID)); !ok {
+		return nil
+	}
+	if m.resolution(projectID, threadID) == nil {
+		m.record(projectID, threadID, ThreadEvent{Action: ActionResolve, UserID: r.UserID, At: r.At})
+	}
+	b, _ := json.Marshal(r)
+	m.s.Put(resolvedKey(projectID, threadID), string(b))
+	return nil
+}
+
+func (m *Memory) ReopenThread(ctx context.Context, projectID, threadID, userID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resolution(projectID, threadID) != nil {
+		m.record(projectID, threadID, ThreadEvent{Action: ActionReopen, UserID: userID, At: at})
+	}
+	m.s.Delete(resolvedKey(projectID, threadID))
+	return nil
+}
+
+// record appends e to a thread's history; callers hold mu.
+func (m *Memory) record(projectID, threadID string, e ThreadEvent) {
+	history := m.history(projectID, threadID)
+	b, _ := json.Marshal(append(history, e))
+	m.s.Put(historyKey(projectID, threadID), string(b))
+}
+
+func (m *Memory) history(projectID, threadID string) []ThreadEvent {
+	var history []ThreadEvent
+	if val, ok := m.s.Get(historyKey(projectID, threadID)); ok {
+		_ = json.Unmarshal([]byte(val), &history)
+	}
+	return history
+}
+
+func (m *Memory) ThreadHistory(ctx context.Context, projectID, threadID string) ([]ThreadEvent, error) {
+	return m.history(projectID, threadID), nil
+}
+
+func (m *Memory) ResolvedThreadIDs(ctx context.Context, projectID string) ([]string, error) {
+	prefix := resolvedKey(projectID, "")
+	ids := []string{}
+	for k := range m.s.List() {
+		if tid, ok := strings.CutPrefix(k, prefix); ok {
+			ids = append(ids, tid)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *Memory) DeleteThread(ctx context.Context, projectID, threadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s.Delete(messagesKey(projectID, threadID))
+	m.s.Delete(resolvedKey(projectID, threadID))
+	m.s.Delete(historyKey(projectID, threadID))
+	for k := range m.s.List() {
+		if strings.HasPrefix(k, revisionsKey(projectID, threadID, "")) {
+			m.s.Delete(k)
+		}
+		if strings.HasPrefix(k, searchKey(projectID, "")) {
+			p := m.postings(k)
+			delete(p, threadID)
+			m.savePostings(k, p)
+		}
+	}
+	return nil
+}
+
+// DuplicateThreads holds mu throughout and cannot fail half way, so the
+// copies are all or nothing.
+func (m *Memory) DuplicateThreads(ctx context.Context, projectID string, threadIDs []string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copies := map[string]string{}
+	for _, tid := range threadIDs {
+		if _, done := copies[tid]; done || tid == GlobalThread {
+			continue
+		}
+		msgs, ok := m.load(projectID, tid)
+		if !ok {
+			continue
+		}
+		newID := primitive.NewObjectID().Hex()
+		for i := range msgs {
+			msgs[i].ID = primitive.NewObjectID().Hex()
+			if msgs[i].DeletedAt == 0 {
+				m.reindex(projectID, newID, msgs[i].ID, "", msgs[i].Content)
+			}
+		}
+		m.save(projectID, newID, msgs)
+		if val, ok := m.s.Get(resolvedKey(projectID, tid)); ok {
+			m.s.Put(resolvedKey(projectID, newID), val)
+		}
+		copies[tid] = newID
+	}
+	return copies, nil
+}
+
+func (m *Memory) DeleteProject(ctx context.Context, projectID string) (ProjectDeletion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var d ProjectDeletion
+	for k, val := range m.s.List() {
+		var entries []json.RawMessage
+		switch {
+		case strings.HasPrefix(k, messagesKey(projectID, "")):
+			json.Unmarshal([]byte(val), &entries)
+			d.Threads++
+			d.Messages += int64(len(entries))
+		case strings.HasPrefix(k, "revisions:"+projectID+":"):
+			json.Unmarshal([]byte(val), &entries)
+			d.Revisions += int64(len(entries))
+		case strings.HasPrefix(k, historyKey(projectID, "")):
+			json.Unmarshal([]byte(val), &entries)
+			d.History += int64(len(entries))
+		case strings.HasPrefix(k, resolvedKey(projectID, "")), strings.HasPrefix(k, searchKey(projectID, "")):
+		default:
+			continue
+		}
+		m.s.Delete(k)
+	}
+	return d, nil
+}
+
+// EraseUser rewrites every thread, resolution and history entry that
+// names the user.
+func (m *Memory) EraseUser(ctx context.Context, userID string, mode EraseMode) (UserErasure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var e UserErasure
+	if userID == DeletedUser {
+		return e, nil
+	}
+	for k, val := range m.s.List() {
+		switch {
+		case strings.HasPrefix(k, "messages:"):
+			ids := strings.SplitN(strings.TrimPrefix(k, "messages:"), ":", 2)
+			if len(ids) != 2 {
+				continue
+			}
+			projectID, threadID := ids[0], ids[1]
+			var msgs []Message
+			json.Unmarshal([]byte(val), &msgs)
+			changed := false
+			kept := msgs[:0]
+			for _, msg := range msgs {
+				var found bool
+				if msg.Reactions, found = withoutUser(msg.Reactions, userID); found {
+					e.Reactions++
+					changed = true
+				}
+				if msg.UserID == userID {
+					e.Messages++
+					changed = true
+					if mode == EraseDelete {
+						e.Revisions += int64(len(m.revisions(revisionsKey(projectID, threadID, msg.ID))))
+						m.s.Delete(revisionsKey(projectID, threadID, msg.ID))
+						m.reindex(projectID, threadID, msg.ID, msg.Content, "")
+						continue
+					}
+					msg.UserID = DeletedUser
+				}
+				kept = append(kept, msg)
+			}
+			if changed {
+				m.save(projectID, threadID, kept)
+			}
+		case strings.HasPrefix(k, "resolved:"):
+			var r Resolution
+			if json.Unmarshal([]byte(val), &r) == nil && r.UserID == userID {
+				r.UserID = DeletedUser
+				b, _ := json.Marshal(r)
+				m.s.Put(k, string(b))
+				e.Resolutions++
+			}
+		case strings.HasPrefix(k, "history:"):
+			var history []ThreadEvent
+			json.Unmarshal([]byte(val), &history)
+			n := e.History
+			for i := range history {
+				if history[i].UserID == userID {
+					history[i].UserID = DeletedUser
+					e.History++
+				}
+			}
+			if e.History > n {
+				b, _ := json.Marshal(history)
+				m.s.Put(k, string(b))
+			}
+		}
+	}
+	return e, nil
+}
+
+// postings reads one token's entry of the search index.
+func (m *Memory) postings(key string) map[string][]string {
+	p := map[string][]string{}
+	if val, ok := m.s.Get(key); ok {
+		_ = json.Unmarshal([]byte(val), &p)
+	}
+	return p
+}
+
+func (m *Memory) savePostings(key string, p map[string][]string) {
+	if len(p) == 0 {
+		m.s.Delete(key)
+		return
+	}
+	b, _ := json.Marshal(p)
+	m.s.Put(key, string(b))
+}
+
+// reindex moves a message's search index entries from the tokens of
+// before to those of after; callers hold mu.
+func (m *Memory) reindex(projectID, threadID, messageID, before, after string) {
+	old, cur := search.Tokenize(before), search.Tokenize(after)
+	for _, t := range old {
+		if slices.Contains(cur, t) {
+			continue
+		}
+		p := m.postings(searchKey(projectID, t))
+		p[threadID] = slices.DeleteFunc(p[threadID], func(id string) bool { return id == messageID })
+		if len(p[threadID]) == 0 {
+			delete(p, threadID)
+		}
+		m.savePostings(searchKey(projectID, t), p)
+	}
+	for _, t := range cur {
+		if slices.Contains(old, t) {
+			continue
+		}
+		p := m.postings(searchKey(projectID, t))
+		p[threadID] = append(p[threadID], messageID)
+		m.savePostings(searchKey(projectID, t), p)
+	}
+}
+
+// Search intersects the index entries of the terms and filters the
+// messages they point to.
+func (m *Memory) Search(ctx context.Context, projectID string, q SearchQuery) ([]SearchHit, error) {
+	if len(q.Terms) == 0 {
+		return []SearchHit{}, nil
+	}
+	candidates := m.postings(searchKey(projectID, q.Terms[0]))
+	for _, t := range q.Terms[1:] {
+		p := m.postings(searchKey(projectID, t))
+		for tid, ids := range candidates {
+			candidates[tid] = slices.DeleteFunc(ids, func(id string) bool { return !slices.Contains(p[tid], id) })
+		}
+	}
+	hits := []SearchHit{}
+	for tid, ids := range candidates {
+		if len(ids) == 0 || (q.ThreadID != "" && tid != q.ThreadID) {
+			continue
+		}
+		resolved := m.resolution(projectID, tid) != nil
+		if q.Resolved != nil && (tid == GlobalThread || resolved != *q.Resolved) {
+			continue
+		}
+		msgs, _ := m.load(projectID, tid)
+		for _, msg := range msgs {
+			if !slices.Contains(ids, msg.ID) || msg.DeletedAt != 0 || (q.UserID != "" && msg.UserID != q.UserID) ||
+				(q.From > 0 && msg.Timestamp < q.From) || (q.To > 0 && msg.Timestamp > q.To) {
+				continue
+			}
+			hits = append(hits, SearchHit{ThreadID: tid, Resolved: resolved, Message: msg})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Message.Timestamp != hits[j].Message.Timestamp {
+			return hits[i].Message.Timestamp > hits[j].Message.Timestamp
+		}
+		return hits[i].Message.ID > hits[j].Message.ID
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
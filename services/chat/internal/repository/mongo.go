@@ -0,0 +1,924 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/davrot/gogotex_at_work/services/chat/internal/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo is a ChatRepository using the Node chat service's collections and
+// document shapes, so either implementation can read the other's data:
+//
+//	rooms:    {_id, project_id, thread_id (absent for the global thread), resolved?: {user_id, ts}}
+//	messages: {_id, room_id, user_id, content, timestamp (ms), edited_at?}
+//
+// Deleted messages keep their document as a tombstone with deleted_at set.
+// Revisions and resolve/reopen transitions, which Node does not keep, go to
+// separate collections:
+//
+//	message_revisions: {_id, message_id, room_id, content, timestamp, replaced_at}
+//	thread_history:    {_id, room_id, action, user_id?, ts}
+//
+// Project, thread, message and user ids must be ObjectIds (ErrInvalidID).
+type Mongo struct {
+	rooms     *mongo.Collection
+	messages  *mongo.Collection
+	revisions *mongo.Collection
+	history   *mongo.Collection
+}
+
+// NewMongo returns a repository on db's rooms, messages, message_revisions
+// and thread_history collections.
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{
+		rooms:     db.Collection("rooms"),
+		messages:  db.Collection("messages"),
+		revisions: db.Collection("message_revisions"),
+		history:   db.Collection("thread_history"),
+	}
+}
+
+// EnsureIndexes creates the indexes the queries rely on. It is idempotent
+// and matches the indexes of the Node service's database, plus a text index
+// on message content for Search.
+func (m *Mongo) EnsureIndexes(ctx context.Context) error {
+	_, err := m.rooms.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "project_id", Value: 1}, {Key: "thread_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.messages.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		// no stemming or stop words, to tokenize like search.Tokenize
+		{Keys: bson.D{{Key: "content", Value: "text"}}, Options: options.Index().SetDefaultLanguage("none")},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.revisions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "replaced_at", Value: 1}}},
+		{Keys: bson.D{{Key: "room_id", Value: 1}}},
+		{Keys: bson.D{{Key: "replaced_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.history.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "ts", Value: 1}},
+	})
+	return err
+}
+
+type messageDoc struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	RoomID    primitive.ObjectID `bson:"room_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Content   string             `bson:"content"`
+	Timestamp int64              `bson:"timestamp"`
+	EditedAt  int64              `bson:"edited_at,omitempty"`
+	DeletedAt int64              `bson:"deleted_at,omitempty"`
+	Reactions []reactionDoc      `bson:"reactions,omitempty"`
+}
+
+// reactionDoc is one user's emoji. The pairs are kept unique with $addToSet.
+type reactionDoc struct {
+	Emoji  string             `bson:"emoji"`
+	UserID primitive.ObjectID `bson:"user_id"`
+}
+
+func (d messageDoc) message() Message {
+	var reactions []Reaction
+	for _, r := range d.Reactions {
+		reactions = withReaction(reactions, r.Emoji, r.UserID.Hex())
+	}
+	return Message{
+		ID: d.ID.Hex(), Content: d.Content, Timestamp: d.Timestamp, UserID: d.UserID.Hex(),
+		EditedAt: d.EditedAt, DeletedAt: d.DeletedAt, Reactions: reactions,
+	}
+}
+
+type revisionDoc struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	MessageID  primitive.ObjectID `bson:"message_id"`
+	RoomID     primitive.ObjectID `bson:"room_id"`
+	Content    string             `bson:"content"`
+	Timestamp  int64              `bson:"timestamp"`
+	ReplacedAt int64              `bson:"replaced_at"`
+}
+
+type resolvedDoc struct {
+	UserID string    `bson:"user_id"`
+	TS     time.Time `bson:"ts"`
+}
+
+type historyDoc struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	RoomID primitive.ObjectID `bson:"room_id"`
+	Action string             `bson:"action"`
+	UserID string             `bson:"user_id,omitempty"`
+	TS     time.Time          `bson:"ts"`
+}
+
+type roomDoc struct {
+	ID        primitive.ObjectID  `bson:"_id"`
+	ProjectID primitive.ObjectID  `bson:"project_id"`
+	ThreadID  *primitive.ObjectID `bson:"thread_id,omitempty"`
+	Resolved  *resolvedDoc        `bson:"resolved,omitempty"`
+}
+
+func objectID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, ErrInvalidID
+	}
+	return oid, nil
+}
+
+// roomFilter selects a project's room for threadID, or its global room.
+func roomFilter(projectID, threadID string) (bson.M, error) {
+	pid, err := objectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if threadID == GlobalThread {
+		return bson.M{"project_id": pid, "thread_id": bson.M{"$exists": false}}, nil
+	}
+	tid, err := objectID(threadID)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"project_id": pid, "thread_id": tid}, nil
+}
+
+// findRoom returns the room id, or ErrNotFound.
+func (m *Mongo) findRoom(ctx context.Context, projectID, threadID string) (primitive.ObjectID, error) {
+	filter, err := roomFilter(projectID, threadID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	var room roomDoc
+	err = m.rooms.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&room)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, ErrNotFound
+	}
+	return room.ID, err
+}
+
+// findOrCreateRoom mirrors Node's ThreadManager.findOrCreateThread.
+func (m *Mongo) findOrCreateRoom(ctx context.Context, projectID, threadID string) (primitive.ObjectID, error) {
+	filter, err := roomFilter(projectID, threadID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	set := bson.M{"project_id": filter["project_id"]}
+	if threadID != GlobalThread {
+		set["thread_id"] = filter["thread_id"]
+	}
+	var room roomDoc
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = m.rooms.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&room)
+	return room.ID, err
+}
+
+func (m *Mongo) CreateMessage(ctx context.Context, projectID, threadID string, msg Message) error {
+	id, err := objectID(msg.ID)
+	if err != nil {
+		return err
+	}
+	userID, err := objectID(msg.UserID)
+	if err != nil {
+		return err
+	}
+	roomID, err := m.findOrCreateRoom(ctx, projectID, threadID)
+	if err != nil {
+		return err
+	}
+	_, err = m.messages.InsertOne(ctx, messageDoc{ID: id, RoomID: roomID, UserID: userID, Content: msg.Content, Timestamp: msg.Timestamp})
+	return err
+}
+
+func (m *Mongo) roomMessages(ctx context.Context, filter bson.M) ([]messageDoc, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := m.messages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []messageDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// ListMessages reads the page newest first along the {room_id, timestamp}
+// index, as Node's MessageManager.getMessages does, and reverses it.
+func (m *Mongo) ListMessages(ctx context.Context, projectID, threadID string, page Page) ([]Message, error) {
+	roomID, err := m.findRoom(ctx, projectID, threadID)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"room_id": roomID}
+	if page.Before > 0 {
+		filter["timestamp"] = bson.M{"$lt": page.Before}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}})
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+	cur, err := m.messages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []messageDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := make([]Message, len(docs))
+	for i, d := range docs {
+		out[len(docs)-1-i] = d.message()
+	}
+	return out, nil
+}
+
+// messageFilter selects one message of a room, optionally only userID's.
+func (m *Mongo) messageFilter(ctx context.Context, projectID, threadID, messageID, userID string) (bson.M, error) {
+	mid, err := objectID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	roomID, err := m.findRoom(ctx, projectID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": mid, "room_id": roomID}
+	if userID != "" {
+		uid, err := objectID(userID)
+		if err != nil {
+			return nil, err
+		}
+		filter["user_id"] = uid
+	}
+	return filter, nil
+}
+
+func (m *Mongo) GetMessage(ctx context.Context, projectID, threadID, messageID string) (Message, error) {
+	filter, err := m.messageFilter(ctx, projectID, threadID, messageID, "")
+	if err != nil {
+		return Message{}, err
+	}
+	var d messageDoc
+	if err := m.messages.FindOne(ctx, filter).Decode(&d); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return Message{}, ErrNotFound
+		}
+		return Message{}, err
+	}
+	return d.message(), nil
+}
+
+func (m *Mongo) UpdateMessage(ctx context.Context, projectID, threadID, messageID, userID, content string, editedAt int64) error {
+	return m.replaceMessage(ctx, projectID, threadID, messageID, userID, bson.M{"$set": bson.M{"content": content, "edited_at": editedAt}}, editedAt)
+}
+
+func (m *Mongo) DeleteMessage(ctx context.Context, projectID, threadID, messageID, userID string, deletedAt int64) error {
+	update := bson.M{
+		"$set":   bson.M{"content": DeletedContent, "deleted_at": deletedAt},
+		"$unset": bson.M{"reactions": true},
+	}
+	return m.replaceMessage(ctx, projectID, threadID, messageID, userID, update, deletedAt)
+}
+
+// replaceMessage applies update to a live message and keeps the version it
+// replaced as a revision. Both writes share a transaction where the
+// deployment supports one, so an edit is never stored without its revision.
+func (m *Mongo) replaceMessage(ctx context.Context, projectID, threadID, messageID, userID string, update bson.M, at int64) error {
+	filter, err := m.messageFilter(ctx, projectID, threadID, messageID, userID)
+	if err != nil {
+		return err
+	}
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return withOptionalTransaction(ctx, m.messages.Database().Client(), func(ctx context.Context) error {
+		var before messageDoc
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+		if err := m.messages.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
+			return err
+		}
+		r := revisionOf(before.message(), at)
+		_, err := m.revisions.InsertOne(ctx, revisionDoc{
+			ID: primitive.NewObjectID(), MessageID: before.ID, RoomID: before.RoomID,
+			Content: r.Content, Timestamp: r.Timestamp, ReplacedAt: r.ReplacedAt,
+		})
+		return err
+	})
+}
+
+// withOptionalTransaction runs fn in a transaction when the deployment
+// supports one (replica set / mongos). Standalone servers used in dev/CI
+// reject transactions, in which case fn runs without one.
+func withOptionalTransaction(ctx context.Context, client *mongo.Client, fn func(context.Context) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return fn(ctx)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 20 || cmdErr.Name == "IllegalOperation") {
+		return fn(ctx)
+	}
+	return err
+}
+
+func (m *Mongo) AddReaction(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error {
+	return m.react(ctx, projectID, threadID, messageID, userID, emoji, "$addToSet")
+}
+
+func (m *Mongo) RemoveReaction(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error {
+	return m.react(ctx, projectID, threadID, messageID, userID, emoji, "$pull")
+}
+
+// react applies op to the message's reaction set in one atomic update.
+// Additions are only applied while the emoji limits hold; when the message
+// exists but did not match, the limits were the reason.
+func (m *Mongo) react(ctx context.Context, projectID, threadID, messageID, userID, emoji, op string) error {
+	uid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	filter, err := m.messageFilter(ctx, projectID, threadID, messageID, "")
+	if err != nil {
+		return err
+	}
+	filter["deleted_at"] = bson.M{"$exists": false}
+	update := filter
+	if op == "$addToSet" {
+		update = bson.M{"$and": bson.A{filter, bson.M{"$expr": reactionLimitsExpr(emoji, uid)}}}
+	}
+	res, err := m.messages.UpdateOne(ctx, update, bson.M{op: bson.M{"reactions": reactionDoc{Emoji: emoji, UserID: uid}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if op == "$addToSet" {
+			if n, err := m.messages.CountDocuments(ctx, filter, options.Count().SetLimit(1)); err != nil {
+				return err
+			} else if n > 0 {
+				return ErrTooManyReactions
+			}
+		}
+		return ErrNotFound
+	}
+	return nil
+}
+
+// reactionLimitsExpr holds when adding uid's emoji keeps the message within
+// MaxEmojisPerMessage and MaxEmojisPerUser distinct emojis.
+func reactionLimitsExpr(emoji string, uid primitive.ObjectID) bson.M {
+	reactions := bson.M{"$ifNull": bson.A{"$reactions", bson.A{}}}
+	all := bson.M{"$map": bson.M{"input": reactions, "as": "r", "in": "$$r.emoji"}}
+	mine := bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{"input": reactions, "as": "r", "cond": bson.M{"$eq": bson.A{"$$r.user_id", uid}}}},
+		"as":    "r",
+		"in":    "$$r.emoji",
+	}}
+	distinctWith := func(emojis bson.M) bson.M {
+		return bson.M{"$size": bson.M{"$setUnion": bson.A{emojis, bson.A{emoji}}}}
+	}
+	return bson.M{"$and": bson.A{
+		bson.M{"$lte": bson.A{distinctWith(all), MaxEmojisPerMessage}},
+		bson.M{"$lte": bson.A{distinctWith(mine), MaxEmojisPerUser}},
+	}}
+}
+
+func (m *Mongo) MessageRevisions(ctx context.Context, projectID, threadID, messageID string) ([]Revision, error) {
+	msg, err := m.GetMessage(ctx, projectID, threadID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	mid, _ := objectID(msg.ID)
+	opts := options.Find().SetSort(bson.D{{Key: "replaced_at", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := m.revisions.Find(ctx, bson.M{"message_id": mid}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []revisionDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := make([]Revision, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, Revision{Content: d.Content, Timestamp: d.Timestamp, ReplacedAt: d.ReplacedAt})
+	}
+	return out, nil
+}
+
+func (m *Mongo) PurgeRevisions(ctx context.Context, replacedBefore int64) (int64, error) {
+	res, err := m.revisions.DeleteMany(ctx, bson.M{"replaced_at": bson.M{"$lt": replacedBefore}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (m *Mongo) Threads(ctx context.Context, projectID string, threadIDs []string) ([]Thread, error) {
+	pid, err := objectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"project_id": pid, "thread_id": bson.M{"$exists": true}}
+	if threadIDs != nil {
+		ids := make([]primitive.ObjectID, 0, len(threadIDs))
+		for _, id := range threadIDs {
+			if oid, err := objectID(id); err == nil {
+				ids = append(ids, oid)
+			}
+		}
+		filter["thread_id"] = bson.M{"$in": ids}
+	}
+	cur, err := m.rooms.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "thread_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var rooms []roomDoc
+	if err := cur.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	roomIDs := make([]primitive.ObjectID, 0, len(rooms))
+	for _, r := range rooms {
+		roomIDs = append(roomIDs, r.ID)
+	}
+	docs, err := m.roomMessages(ctx, bson.M{"room_id": bson.M{"$in": roomIDs}})
+	if err != nil {
+		return nil, err
+	}
+	byRoom := map[primitive.ObjectID][]Message{}
+	for _, d := range docs {
+		byRoom[d.RoomID] = append(byRoom[d.RoomID], d.message())
+	}
+	out := make([]Thread, 0, len(rooms))
+	for _, r := range rooms {
+		t := Thread{ID: r.ThreadID.Hex(), Messages: byRoom[r.ID]}
+		if r.Resolved != nil {
+			t.Resolved = &Resolution{UserID: r.Resolved.UserID, At: r.Resolved.TS}
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// EachThread walks the project's rooms with a cursor and reads each room's
+// messages separately, so only one thread is held at a time.
+func (m *Mongo) EachThread(ctx context.Context, projectID string, fn func(Thread) error) error {
+	pid, err := objectID(projectID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"project_id": pid, "thread_id": bson.M{"$exists": true}}
+	cur, err := m.rooms.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "thread_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var r roomDoc
+		if err := cur.Decode(&r); err != nil {
+			return err
+		}
+		docs, err := m.roomMessages(ctx, bson.M{"room_id": r.ID})
+		if err != nil {
+			return err
+		}
+		t := Thread{ID: r.ThreadID.Hex(), Messages: make([]Message, 0, len(docs))}
+		for _, d := range docs {
+			t.Messages = append(t.Messages, d.message())
+		}
+		if r.Resolved != nil {
+			t.Resolved = &Resolution{UserID: r.Resolved.UserID, At: r.Resolved.TS}
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *Mongo) ResolveThread(ctx context.Context, projectID, threadID string, r Resolution) error {
+	update := bson.M{"$set": bson.M{"resolved": resolvedDoc{UserID: r.UserID, TS: r.At}}}
+	return m.transition(ctx, projectID, threadID, update, ThreadEvent{Action: ActionResolve, UserID: r.UserID, At: r.At})
+}
+
+func (m *Mongo) ReopenThread(ctx context.Context, projectID, threadID, userID string, at time.Time) error {
+	update := bson.M{"$unset": bson.M{"resolved": true}}
+	return m.transition(ctx, projectID, threadID, update, ThreadEvent{Action: ActionReopen, UserID: userID, At: at})
+}
+
+// transition applies update to a room and records e when it changed the
+// room's resolved state. Both writes share a transaction where the deployment
+// supports one, so a state change is never stored without its history entry.
+// Missing rooms are ignored.
+func (m *Mongo) transition(ctx context.Context, projectID, threadID string, update bson.M, e ThreadEvent) error {
+	filter, err := roomFilter(projectID, threadID)
+	if err != nil {
+		return err
+	}
+	return withOptionalTransaction(ctx, m.rooms.Database().Client(), func(ctx context.Context) error {
+		var before roomDoc
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+		err := m.rooms.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if (before.Resolved == nil) != (e.Action == ActionResolve) {
+			return nil
+		}
+		_, err = m.history.InsertOne(ctx, historyDoc{ID: primitive.NewObjectID(), RoomID: before.ID, Action: e.Action, UserID: e.UserID, TS: e.At})
+		return err
+	})
+}
+
+func (m *Mongo) ThreadHistory(ctx context.Context, projectID, threadID string) ([]ThreadEvent, error) {
+	roomID, err := m.findRoom(ctx, projectID, threadID)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "ts", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := m.history.Find(ctx, bson.M{"room_id": roomID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []historyDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := make([]ThreadEvent, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, ThreadEvent{Action: d.Action, UserID: d.UserID, At: d.TS})
+	}
+	return out, nil
+}
+
+func (m *Mongo) ResolvedThreadIDs(ctx context.Context, projectID string) ([]string, error) {
+	pid, err := objectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"project_id": pid, "thread_id": bson.M{"$exists": true}, "resolved": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"thread_id": 1}).SetSort(bson.D{{Key: "thread_id", Value: 1}})
+	cur, err := m.rooms.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var rooms []roomDoc
+	if err := cur.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.ThreadID.Hex())
+	}
+	return ids, nil
+}
+
+func (m *Mongo) DeleteThread(ctx context.Context, projectID, threadID string) error {
+	roomID, err := m.findRoom(ctx, projectID, threadID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := m.rooms.DeleteOne(ctx, bson.M{"_id": roomID}); err != nil {
+		return err
+	}
+	if _, err := m.history.DeleteMany(ctx, bson.M{"room_id": roomID}); err != nil {
+		return err
+	}
+	if _, err := m.revisions.DeleteMany(ctx, bson.M{"room_id": roomID}); err != nil {
+		return err
+	}
+	_, err = m.messages.DeleteMany(ctx, bson.M{"room_id": roomID})
+	return err
+}
+
+// DuplicateThreads copies the rooms and their messages in one transaction,
+// which needs a replica set, as in the Node service's deployment.
+func (m *Mongo) DuplicateThreads(ctx context.Context, projectID string, threadIDs []string) (map[string]string, error) {
+	if _, err := objectID(projectID); err != nil {
+		return nil, err
+	}
+	session, err := m.rooms.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+	var copies map[string]string
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// the transaction may be retried, so start afresh
+		copies = map[string]string{}
+		for _, tid := range threadIDs {
+			if _, done := copies[tid]; done || tid == GlobalThread {
+				continue
+			}
+			newID, err := m.duplicateRoom(sc, projectID, tid)
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidID) {
+				continue
+			}
+			if err != nil {
+				return nil, &DuplicateError{ThreadID: tid, Err: err}
+			}
+			copies[tid] = newID
+		}
+		return nil, nil
+	})
+	if err != nil {
+		var dup *DuplicateError
+		if !errors.As(err, &dup) {
+			err = &DuplicateError{Err: err}
+		}
+		return nil, err
+	}
+	return copies, nil
+}
+
+// duplicateRoom copies one room, as Node's ThreadManager.duplicateThread and
+// MessageManager.duplicateRoomToOtherRoom do, and returns the new thread id.
+func (m *Mongo) duplicateRoom(ctx context.Context, projectID, threadID string) (string, error) {
+	filter, err := roomFilter(projectID, threadID)
+	if err != nil {
+		return "", err
+	}
+	var room roomDoc
+	err = m.rooms.FindOne(ctx, filter).Decode(&room)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	newThreadID := primitive.NewObjectID()
+	copied := roomDoc{ID: primitive.NewObjectID(), ProjectID: room.ProjectID, ThreadID: &newThreadID, Resolved: room.Resolved}
+	if _, err := m.rooms.InsertOne(ctx, copied); err != nil {
+		return "", err
+	}
+	docs, err := m.roomMessages(ctx, bson.M{"room_id": room.ID})
+	if err != nil {
+		return "", err
+	}
+	if len(docs) > 0 {
+		messages := make([]interface{}, 0, len(docs))
+		for _, d := range docs {
+			d.ID, d.RoomID = primitive.NewObjectID(), copied.ID
+			messages = append(messages, d)
+		}
+		if _, err := m.messages.InsertMany(ctx, messages); err != nil {
+			return "", err
+		}
+	}
+	return newThreadID.Hex(), nil
+}
+
+// Search narrows the project's rooms by thread and resolved state, then
+// finds their messages through the text index. The index is also
+// diacritic-insensitive, so matches are checked again with search.Matches.
+func (m *Mongo) Search(ctx context.Context, projectID string, q SearchQuery) ([]SearchHit, error) {
+	pid, err := objectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	hits := []SearchHit{}
+	if len(q.Terms) == 0 || (q.ThreadID == GlobalThread && q.Resolved != nil) {
+		return hits, nil
+	}
+	roomQuery := bson.M{"project_id": pid}
+	if q.ThreadID != "" {
+		if roomQuery, err = roomFilter(projectID, q.ThreadID); err != nil {
+			return nil, err
+		}
+	}
+	if q.Resolved != nil {
+		roomQuery["thread_id"] = bson.M{"$exists": true}
+		roomQuery["resolved"] = bson.M{"$exists": *q.Resolved}
+	}
+	cur, err := m.rooms.Find(ctx, roomQuery)
+	if err != nil {
+		return nil, err
+	}
+	var rooms []roomDoc
+	if err := cur.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]roomDoc, len(rooms))
+	roomIDs := make([]primitive.ObjectID, 0, len(rooms))
+	for _, r := range rooms {
+		byID[r.ID] = r
+		roomIDs = append(roomIDs, r.ID)
+	}
+
+	phrases := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		phrases[i] = `"` + t + `"`
+	}
+	filter := bson.M{
+		"room_id":    bson.M{"$in": roomIDs},
+		"$text":      bson.M{"$search": strings.Join(phrases, " ")},
+		"deleted_at": bson.M{"$exists": false},
+	}
+	if q.UserID != "" {
+		uid, err := objectID(q.UserID)
+		if err != nil {
+			return nil, err
+		}
+		filter["user_id"] = uid
+	}
+	if q.From > 0 || q.To > 0 {
+		ts := bson.M{}
+		if q.From > 0 {
+			ts["$gte"] = q.From
+		}
+		if q.To > 0 {
+			ts["$lte"] = q.To
+		}
+		filter["timestamp"] = ts
+	}
+	msgs, err := m.messages.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer msgs.Close(ctx)
+	for msgs.Next(ctx) && (q.Limit <= 0 || len(hits) < q.Limit) {
+		var d messageDoc
+		if err := msgs.Decode(&d); err != nil {
+			return nil, err
+		}
+		if !search.Matches(d.Content, q.Terms) {
+			continue
+		}
+		room := byID[d.RoomID]
+		hit := SearchHit{ThreadID: GlobalThread, Resolved: room.Resolved != nil, Message: d.message()}
+		if room.ThreadID != nil {
+			hit.ThreadID = room.ThreadID.Hex()
+		}
+		hits = append(hits, hit)
+	}
+	return hits, msgs.Err()
+}
+
+// eraseBatchSize bounds the documents DeleteProject and EraseUser touch per
+// write.
+const eraseBatchSize = 1000
+
+// eachBatch calls fn with the ids of up to eraseBatchSize documents of coll
+// matching filter until none are left. fn must make them stop matching.
+func eachBatch(ctx context.Context, coll *mongo.Collection, filter bson.M, fn func(ids []primitive.ObjectID) error) error {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(eraseBatchSize)
+	for {
+		cur, err := coll.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		ids := make([]primitive.ObjectID, len(docs))
+		for i, d := range docs {
+			ids[i] = d.ID
+		}
+		if err := fn(ids); err != nil {
+			return err
+		}
+		if len(docs) < eraseBatchSize {
+			return nil
+		}
+	}
+}
+
+// deleteBatched deletes the documents of coll matching filter, a batch at a
+// time, and returns how many it deleted.
+func deleteBatched(ctx context.Context, coll *mongo.Collection, filter bson.M) (int64, error) {
+	var n int64
+	err := eachBatch(ctx, coll, filter, func(ids []primitive.ObjectID) error {
+		res, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err == nil {
+			n += res.DeletedCount
+		}
+		return err
+	})
+	return n, err
+}
+
+// updateBatched applies update to the documents of coll matching filter, a
+// batch at a time, and returns how many it modified. update must make them
+// stop matching.
+func updateBatched(ctx context.Context, coll *mongo.Collection, filter, update bson.M) (int64, error) {
+	var n int64
+	err := eachBatch(ctx, coll, filter, func(ids []primitive.ObjectID) error {
+		res, err := coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update)
+		if err == nil {
+			n += res.ModifiedCount
+		}
+		return err
+	})
+	return n, err
+}
+
+// DeleteProject works through the project's rooms in batches, removing a
+// batch's rooms only after their messages, revisions and history, so a
+// deletion that fails part way is completed by calling it again.
+func (m *Mongo) DeleteProject(ctx context.Context, projectID string) (ProjectDeletion, error) {
+	var d ProjectDeletion
+	pid, err := objectID(projectID)
+	if err != nil {
+		return d, err
+	}
+	err = eachBatch(ctx, m.rooms, bson.M{"project_id": pid}, func(roomIDs []primitive.ObjectID) error {
+		inRooms := bson.M{"room_id": bson.M{"$in": roomIDs}}
+		for _, c := range []struct {
+			coll  *mongo.Collection
+			count *int64
+		}{{m.messages, &d.Messages}, {m.revisions, &d.Revisions}, {m.history, &d.History}} {
+			n, err := deleteBatched(ctx, c.coll, inRooms)
+			*c.count += n
+			if err != nil {
+				return err
+			}
+		}
+		res, err := m.rooms.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": roomIDs}})
+		if err == nil {
+			d.Threads += res.DeletedCount
+		}
+		return err
+	})
+	return d, err
+}
+
+// EraseUser works in batches and can be repeated after a failure: every
+// step leaves documents that no longer match it.
+func (m *Mongo) EraseUser(ctx context.Context, userID string, mode EraseMode) (UserErasure, error) {
+	var e UserErasure
+	uid, err := objectID(userID)
+	if err != nil {
+		return e, err
+	}
+	if userID == DeletedUser {
+		// already anonymous, and rewriting it would never finish
+		return e, nil
+	}
+	if e.Reactions, err = updateBatched(ctx, m.messages, bson.M{"reactions.user_id": uid},
+		bson.M{"$pull": bson.M{"reactions": bson.M{"user_id": uid}}}); err != nil {
+		return e, err
+	}
+	if mode == EraseDelete {
+		err = eachBatch(ctx, m.messages, bson.M{"user_id": uid}, func(ids []primitive.ObjectID) error {
+			n, err := deleteBatched(ctx, m.revisions, bson.M{"message_id": bson.M{"$in": ids}})
+			e.Revisions += n
+			if err != nil {
+				return err
+			}
+			res, err := m.messages.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+			if err == nil {
+				e.Messages += res.DeletedCount
+			}
+			return err
+		})
+	} else {
+		e.Messages, err = updateBatched(ctx, m.messages, bson.M{"user_id": uid}, bson.M{"$set": bson.M{"user_id": primitive.NilObjectID}})
+	}
+	if err != nil {
+		return e, err
+	}
+	// resolutions and history store user ids as strings, as Node does
+	if e.Resolutions, err = updateBatched(ctx, m.rooms, bson.M{"resolved.user_id": userID},
+		bson.M{"$set": bson.M{"resolved.user_id": DeletedUser}}); err != nil {
+		return e, err
+	}
+	e.History, err = updateBatched(ctx, m.history, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"user_id": DeletedUser}})
+	return e, err
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testMongo connects to MONGO_URI and returns a repository on a scratch
+// database that is dropped after the test.
+func testMongo(t *testing.T) *Mongo {
+	t.Helper()
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	db := client.Database("chat_repository_test")
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return NewMongo(db)
+}
+
+func TestMongoConformance(t *testing.T) {
+	m := testMongo(t)
+	if err := m.EnsureIndexes(context.Background()); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	runConformance(t, m)
+}
+
+// TestMongoNodeShape checks that documents are written in the Node chat
+// service's rooms/messages shape.
+func TestMongoNodeShape(t *testing.T) {
+	m := testMongo(t)
+	ctx := context.Background()
+	pid, tid, uid := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	msg := Message{ID: primitive.NewObjectID().Hex(), Content: "stored", UserID: uid.Hex(), Timestamp: time.Now().UnixMilli()}
+	if err := m.CreateMessage(ctx, pid.Hex(), tid.Hex(), msg); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	m.ResolveThread(ctx, pid.Hex(), tid.Hex(), Resolution{UserID: uid.Hex(), At: time.Now()})
+
+	var room bson.M
+	if err := m.rooms.FindOne(ctx, bson.M{"project_id": pid}).Decode(&room); err != nil {
+		t.Fatalf("find room: %v", err)
+	}
+	if room["thread_id"] != tid {
+		t.Fatalf("expected thread_id %v, got %#v", tid, room["thread_id"])
+	}
+	if resolved, ok := room["resolved"].(bson.M); !ok || resolved["user_id"] != uid.Hex() {
+		t.Fatalf("expected resolved.user_id, got %#v", room["resolved"])
+	}
+	var doc bson.M
+	if err := m.messages.FindOne(ctx, bson.M{"room_id": room["_id"]}).Decode(&doc); err != nil {
+		t.Fatalf("find message: %v", err)
+	}
+	if doc["user_id"] != uid || doc["content"] != "stored" || doc["timestamp"] != msg.Timestamp {
+		t.Fatalf("unexpected message document %#v", doc)
+	}
+
+	// a room for the global thread has no thread_id
+	m.CreateMessage(ctx, pid.Hex(), GlobalThread, Message{ID: primitive.NewObjectID().Hex(), Content: "hi", UserID: uid.Hex(), Timestamp: 1})
+	n, _ := m.rooms.CountDocuments(ctx, bson.M{"project_id": pid, "thread_id": bson.M{"$exists": false}})
+	if n != 1 {
+		t.Fatalf("expected one global room, got %d", n)
+	}
+}
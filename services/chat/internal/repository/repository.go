@@ -0,0 +1,325 @@
+// Package repository is the storage layer of the Go chat service. The HTTP
+// handlers only see ChatRepository; NewMemory and NewMongo implement it and
+// are checked by the same conformance suite.
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// GlobalThread is the thread id used for project chat, as opposed to review
+// comment threads (Node's ThreadManager.GLOBAL_THREAD).
+const GlobalThread = "GLOBAL"
+
+var (
+	// ErrNotFound is returned when a message or thread does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidID is returned when an id the Mongo schema stores as an
+	// ObjectId is not one.
+	ErrInvalidID = errors.New("invalid id")
+	// ErrTooManyReactions is returned when a reaction would exceed
+	// MaxEmojisPerMessage or MaxEmojisPerUser.
+	ErrTooManyReactions = errors.New("too many reactions")
+)
+
+// Limits on the distinct emojis a message carries, in total and from one user.
+const (
+	MaxEmojisPerMessage = 20
+	MaxEmojisPerUser    = 5
+)
+
+// DeletedContent replaces the content of a deleted message.
+const DeletedContent = "message deleted"
+
+// Message is a chat message in the shape Node's MessageFormatter returns.
+// A deleted message is a tombstone with DeletedContent and DeletedAt set.
+type Message struct {
+	ID        string `json:"id"`
+	Content   string `json:"content"`
+	Timestamp int64  `json:"timestamp"`
+	UserID    string `json:"user_id"`
+	EditedAt  int64  `json:"edited_at,omitempty"`
+	DeletedAt int64  `json:"deleted_at,omitempty"`
+	// Reactions are in order of each emoji's first use.
+	Reactions []Reaction `json:"reactions,omitempty"`
+}
+
+// Reaction aggregates the users who reacted to a message with one emoji.
+type Reaction struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// withReaction returns rs with userID's emoji added, or rs itself when it
+// is already there.
+func withReaction(rs []Reaction, emoji, userID string) []Reaction {
+	for i, r := range rs {
+		if r.Emoji != emoji {
+			continue
+		}
+		for _, u := range r.UserIDs {
+			if u == userID {
+				return rs
+			}
+		}
+		rs[i].UserIDs = append(r.UserIDs, userID)
+		rs[i].Count = len(rs[i].UserIDs)
+		return rs
+	}
+	return append(rs, Reaction{Emoji: emoji, Count: 1, UserIDs: []string{userID}})
+}
+
+// checkReactionLimits returns ErrTooManyReactions when adding userID's emoji
+// to rs would exceed MaxEmojisPerMessage or MaxEmojisPerUser.
+func checkReactionLimits(rs []Reaction, emoji, userID string) error {
+	mine, onMessage := 0, false
+	for _, r := range rs {
+		reacted := slices.Contains(r.UserIDs, userID)
+		if r.Emoji == emoji {
+			if reacted {
+				return nil
+			}
+			onMessage = true
+		}
+		if reacted {
+			mine++
+		}
+	}
+	if (!onMessage && len(rs) >= MaxEmojisPerMessage) || mine >= MaxEmojisPerUser {
+		return ErrTooManyReactions
+	}
+	return nil
+}
+
+// withoutReaction returns rs without userID's emoji, dropping emojis that
+// no one uses any more.
+func withoutReaction(rs []Reaction, emoji, userID string) []Reaction {
+	out := rs[:0]
+	for _, r := range rs {
+		if r.Emoji == emoji {
+			users := r.UserIDs[:0]
+			for _, u := range r.UserIDs {
+				if u != userID {
+					users = append(users, u)
+				}
+			}
+			r.UserIDs, r.Count = users, len(users)
+		}
+		if r.Count > 0 {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// Revision is an earlier version of a message, kept when it was edited or
+// deleted. Timestamp is when the version was written, ReplacedAt when it
+// stopped being current.
+type Revision struct {
+	Content    string `json:"content"`
+	Timestamp  int64  `json:"timestamp"`
+	ReplacedAt int64  `json:"replaced_at"`
+}
+
+// revisionOf returns the current version of msg as a revision.
+func revisionOf(msg Message, replacedAt int64) Revision {
+	written := msg.Timestamp
+	if msg.EditedAt != 0 {
+		written = msg.EditedAt
+	}
+	return Revision{Content: msg.Content, Timestamp: written, ReplacedAt: replacedAt}
+}
+
+// Resolution records who resolved a thread and when.
+type Resolution struct {
+	UserID string    `json:"user_id"`
+	At     time.Time `json:"ts"`
+}
+
+// Thread history actions.
+const (
+	ActionResolve = "resolve"
+	ActionReopen  = "reopen"
+)
+
+// ThreadEvent is one resolve or reopen transition of a thread.
+type ThreadEvent struct {
+	Action string    `json:"action"`
+	UserID string    `json:"user_id,omitempty"`
+	At     time.Time `json:"ts"`
+}
+
+// Page selects a window of a thread's messages: the newest Limit messages
+// older than Before. Zero values mean no bound.
+type Page struct {
+	Before int64
+	Limit  int
+}
+
+// DuplicateError reports the source thread whose copy failed, which undid
+// the copies of the others. ThreadID is empty when no single thread was
+// at fault, such as when committing failed.
+type DuplicateError struct {
+	ThreadID string
+	Err      error
+}
+
+func (e *DuplicateError) Error() string {
+	if e.ThreadID == "" {
+		return "duplicate threads: " + e.Err.Error()
+	}
+	return "duplicate thread " + e.ThreadID + ": " + e.Err.Error()
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return e.Err
+}
+
+// DeletedUser replaces an erased user's id.
+const DeletedUser = "000000000000000000000000"
+
+// EraseMode is what EraseUser does with a user's messages.
+type EraseMode string
+
+const (
+	EraseDelete    EraseMode = "delete"
+	EraseAnonymise EraseMode = "anonymise"
+)
+
+// ProjectDeletion counts what DeleteProject removed. Threads include the
+// global chat.
+type ProjectDeletion struct {
+	Threads   int64 `json:"threads"`
+	Messages  int64 `json:"messages"`
+	Revisions int64 `json:"revisions"`
+	History   int64 `json:"history"`
+}
+
+// UserErasure counts what EraseUser changed: the user's messages deleted
+// or anonymised, revisions deleted, messages the user's reactions were
+// removed from, and resolutions and history events reattributed.
+type UserErasure struct {
+	Messages    int64 `json:"messages"`
+	Revisions   int64 `json:"revisions"`
+	Reactions   int64 `json:"reactions"`
+	Resolutions int64 `json:"resolutions"`
+	History     int64 `json:"history"`
+}
+
+// withoutUser returns rs without any of userID's reactions, and whether
+// there were any.
+func withoutUser(rs []Reaction, userID string) ([]Reaction, bool) {
+	var emojis []string
+	for _, r := range rs {
+		if slices.Contains(r.UserIDs, userID) {
+			emojis = append(emojis, r.Emoji)
+		}
+	}
+	for _, e := range emojis {
+		rs = withoutReaction(rs, e, userID)
+	}
+	return rs, len(emojis) > 0
+}
+
+// SearchQuery selects messages by content. Terms are tokens as returned by
+// search.Tokenize, all of which a message must contain. The other fields
+// are optional filters: ThreadID (which may be GlobalThread), the author,
+// a timestamp range in ms (inclusive), and the resolved state, which only
+// comment threads have. Deleted messages never match.
+type SearchQuery struct {
+	Terms    []string
+	ThreadID string
+	UserID   string
+	From, To int64
+	Resolved *bool
+	Limit    int
+}
+
+// SearchHit is a message matching a SearchQuery.
+type SearchHit struct {
+	ThreadID string
+	Resolved bool
+	Message  Message
+}
+
+// Thread is a comment thread (a Node "room") with its messages, oldest first.
+type Thread struct {
+	ID       string
+	Messages []Message
+	Resolved *Resolution
+}
+
+// ChatRepository stores rooms, threads, messages and resolution state.
+// threadID may be GlobalThread wherever a single thread is addressed.
+type ChatRepository interface {
+	// CreateMessage appends m to a thread, creating the thread if needed.
+	CreateMessage(ctx context.Context, projectID, threadID string, m Message) error
+	// ListMessages returns one page of a thread's messages, oldest first.
+	ListMessages(ctx context.Context, projectID, threadID string, page Page) ([]Message, error)
+	GetMessage(ctx context.Context, projectID, threadID, messageID string) (Message, error)
+	// UpdateMessage replaces a message's content and keeps the previous
+	// version as a revision. A non-empty userID restricts the edit to that
+	// user's message; otherwise, or if the message is deleted, ErrNotFound.
+	UpdateMessage(ctx context.Context, projectID, threadID, messageID, userID, content string, editedAt int64) error
+	// DeleteMessage turns a message into a tombstone without reactions,
+	// keeping its content as a revision. It is restricted to userID's message when set, and
+	// returns ErrNotFound for a message that is missing or already deleted.
+	DeleteMessage(ctx context.Context, projectID, threadID, messageID, userID string, deletedAt int64) error
+	// AddReaction adds userID's emoji to a live message; adding it twice is a
+	// no-op. Missing or deleted messages are ErrNotFound, and an emoji beyond
+	// MaxEmojisPerMessage or MaxEmojisPerUser is ErrTooManyReactions.
+	AddReaction(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error
+	// RemoveReaction removes userID's emoji from a message, if present.
+	RemoveReaction(ctx context.Context, projectID, threadID, messageID, userID, emoji string) error
+	// MessageRevisions returns a message's earlier versions, oldest first.
+	MessageRevisions(ctx context.Context, projectID, threadID, messageID string) ([]Revision, error)
+	// PurgeRevisions removes revisions replaced before the given time
+	// (ms) in all projects, and returns how many it removed.
+	PurgeRevisions(ctx context.Context, replacedBefore int64) (int64, error)
+
+	// Threads returns the listed comment threads of a project, or all of
+	// them when threadIDs is nil. Missing threads are left out.
+	Threads(ctx context.Context, projectID string, threadIDs []string) ([]Thread, error)
+	// EachThread calls fn with each comment thread of a project, in thread
+	// id order, loading one thread at a time. It stops at fn's first error
+	// and returns it.
+	EachThread(ctx context.Context, projectID string, fn func(Thread) error) error
+	// ResolveThread marks an existing thread resolved; missing threads are
+	// ignored. Resolving an open thread adds an ActionResolve event.
+	ResolveThread(ctx context.Context, projectID, threadID string, r Resolution) error
+	// ReopenThread clears the resolution. Reopening a resolved thread adds
+	// an ActionReopen event; userID may be empty.
+	ReopenThread(ctx context.Context, projectID, threadID, userID string, at time.Time) error
+	// ThreadHistory returns a thread's resolve/reopen events, oldest first.
+	ThreadHistory(ctx context.Context, projectID, threadID string) ([]ThreadEvent, error)
+	ResolvedThreadIDs(ctx context.Context, projectID string) ([]string, error)
+	// DeleteThread removes a thread, its messages, their revisions and its
+	// history.
+	DeleteThread(ctx context.Context, projectID, threadID string) error
+	// DuplicateThreads copies the listed comment threads, all or nothing.
+	// Each copy gets a new thread id, its source's resolution and copies of
+	// its messages with new ids. The result maps each source thread that
+	// exists to its copy; missing threads are left out. On error nothing
+	// was copied and the error is a *DuplicateError.
+	DuplicateThreads(ctx context.Context, projectID string, threadIDs []string) (map[string]string, error)
+	// Search returns up to q.Limit messages of a project matching q, newest
+	// first.
+	Search(ctx context.Context, projectID string, q SearchQuery) ([]SearchHit, error)
+	// DeleteProject removes every thread and message of a project,
+	// including its global chat, with their revisions and history.
+	// Deleting a deleted project removes nothing.
+	DeleteProject(ctx context.Context, projectID string) (ProjectDeletion, error)
+	// EraseUser removes a user from every project: their messages are
+	// deleted with their revisions (EraseDelete) or attributed to
+	// DeletedUser (EraseAnonymise), their reactions are removed, and
+	// resolutions and history events name DeletedUser instead. Erasing an
+	// erased user changes nothing.
+	EraseUser(ctx context.Context, userID string, mode EraseMode) (UserErasure, error)
+}
@@ -0,0 +1,125 @@
+// Package search tokenizes chat messages for full-text search and renders
+// highlighted snippets of the matches. Tokens are lowercased and separated by
+// the characters MongoDB's text index treats as delimiters: white space,
+// dashes, quotation marks, punctuation that ends a clause or sentence, and
+// syntax characters such as @ or #. Anything else, including _, is part of a
+// token, so the memory and Mongo repositories agree on what a term matches.
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// span is a token at runes [start, end) of a text.
+type span struct {
+	start, end int
+	token      string
+}
+
+// delimiters are the Unicode properties MongoDB's text index splits on.
+var delimiters = []*unicode.RangeTable{
+	unicode.White_Space, unicode.Dash, unicode.Hyphen, unicode.Quotation_Mark,
+	unicode.Terminal_Punctuation, unicode.Sentence_Terminal, unicode.Pattern_Syntax,
+}
+
+func isTokenRune(r rune) bool {
+	return !unicode.IsControl(r) && !unicode.In(r, delimiters...)
+}
+
+func spans(text []rune) []span {
+	var out []span
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		in := i < len(text) && isTokenRune(text[i])
+		switch {
+		case in && start < 0:
+			start = i
+		case !in && start >= 0:
+			out = append(out, span{start, i, strings.ToLower(string(text[start:i]))})
+			start = -1
+		}
+	}
+	return out
+}
+
+// Tokenize returns the distinct tokens of text in order of first use.
+func Tokenize(text string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, s := range spans([]rune(text)) {
+		if !seen[s.token] {
+			seen[s.token] = true
+			out = append(out, s.token)
+		}
+	}
+	return out
+}
+
+// Matches reports whether text contains every one of terms, which are
+// tokens as returned by Tokenize.
+func Matches(text string, terms []string) bool {
+	tokens := map[string]bool{}
+	for _, t := range Tokenize(text) {
+		tokens[t] = true
+	}
+	for _, t := range terms {
+		if !tokens[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// Snippet returns about width runes of text around the first match of
+// terms, HTML-escaped, with the matches in <mark> and an ellipsis where text
+// was cut.
+func Snippet(text string, terms []string, width int) string {
+	runes := []rune(text)
+	wanted := map[string]bool{}
+	for _, t := range terms {
+		wanted[t] = true
+	}
+	var marks []span
+	for _, s := range spans(runes) {
+		if wanted[s.token] {
+			marks = append(marks, s)
+		}
+	}
+
+	start, end := 0, len(runes)
+	if len(runes) > width {
+		if len(marks) > 0 {
+			// keep a little context before the first match
+			start = max(0, marks[0].start-width/4)
+		}
+		end = min(len(runes), start+width)
+		start = max(0, end-width)
+		for start > 0 && start < end && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		for end < len(runes) && end > start && unicode.IsSpace(runes[end-1]) {
+			end--
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range marks {
+		if m.start < pos || m.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>" + html.EscapeString(string(runes[m.start:m.end])) + "</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("See Figure 3 — the figure's axis, naïve Über-label; figure 3!")
+	want := []string{"see", "figure", "3", "the", "s", "axis", "naïve", "über", "label"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if Tokenize(" ,.- ") != nil {
+		t.Fatalf("expected no tokens")
+	}
+	// like MongoDB's text index, _ joins and @, #, / and ( separate
+	got = Tokenize("fig_3 @alice #42 (see a/b)")
+	want = []string{"fig_3", "alice", "42", "see", "a", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		text  string
+		terms []string
+		want  bool
+	}{
+		{"Discussion about FIGURE 3", []string{"figure", "3"}, true},
+		{"Discussion about figure 3", []string{"figure", "4"}, false},
+		{"figures", []string{"figure"}, false},
+		{"anything", nil, true},
+	}
+	for _, tc := range cases {
+		if got := Matches(tc.text, tc.terms); got != tc.want {
+			t.Errorf("Matches(%q, %q) = %v", tc.text, tc.terms, got)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	cases := []struct {
+		text  string
+		terms []string
+		width int
+		want  string
+	}{
+		{"Fix Figure 3 <now>", []string{"figure"}, 80, "Fix <mark>Figure</mark> 3 &lt;now&gt;"},
+		{"figure figure", []string{"figure"}, 80, "<mark>figure</mark> <mark>figure</mark>"},
+		{strings.Repeat("a ", 20) + "target" + strings.Repeat(" b", 20), []string{"target"}, 20, "…a a <mark>target</mark> b b b b…"},
+		{"target" + strings.Repeat(" b", 20), []string{"target"}, 10, "<mark>target</mark> b b…"},
+		{strings.Repeat("a ", 10) + "target", []string{"target"}, 10, "…a a <mark>target</mark>"},
+	}
+	for _, tc := range cases {
+		if got := Snippet(tc.text, tc.terms, tc.width); got != tc.want {
+			t.Errorf("Snippet(%q) = %q, expected %q", tc.text, got, tc.want)
+		}
+	}
+}
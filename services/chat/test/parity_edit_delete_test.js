@@ -53,8 +53,8 @@ async function main() {
     const postBody = await post.json()
     if (post.status !== 201) throw new Error('POST failed')
 
-    // Edit message m1
-    const edit = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/messages/m1`, {
+    // Edit the posted message
+    const edit = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/messages/${postBody.id}`, {
       method: 'PUT',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ content: 'edited-parity' })
@@ -67,12 +67,13 @@ async function main() {
     if (!found) throw new Error('Edited message not found')
 
     // Delete it
-    const del = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/messages/m1`, { method: 'DELETE' })
+    const del = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/messages/${postBody.id}`, { method: 'DELETE' })
     if (del.status !== 204) throw new Error('Delete failed')
 
     const getRes2 = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/messages`)
     const getBody2 = await getRes2.json()
-    if (Array.isArray(getBody2) && getBody2.length !== 0) throw new Error('Expected empty after delete')
+    // deletes leave a tombstone
+    if (getBody2.length !== 1 || getBody2[0].content !== 'message deleted') throw new Error('Expected a tombstone after delete')
 
     console.log('Parity edit/delete roundtrip passed')
   } finally {
@@ -45,7 +45,11 @@ async function main() {
     await waitFor(`http://127.0.0.1:${port}/status`, 5000)
 
     // Resolve then Reopen
-    const res1 = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/resolve`, { method: 'POST' })
+    const res1 = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/resolve`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ user_id: '507f1f77bcf86cd799439012' }),
+    })
     if (res1.status !== 204) throw new Error('resolve failed')
     const res2 = await fetch(`http://127.0.0.1:${port}/project/abc/threads/t1/reopen`, { method: 'POST' })
     if (res2.status !== 204) throw new Error('reopen failed')
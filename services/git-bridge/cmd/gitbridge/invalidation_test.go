@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/overleaf/git-bridge/internal/ssh"
+	"github.com/overleaf/git-bridge/internal/svcauth"
+)
+
+func TestInvalidationRequiresSignature(t *testing.T) {
+	t.Setenv("SSH_LOOKUP_BASE_URL", "http://127.0.0.1:0")
+	am, err := ssh.NewAuthManagerFromEnv(http.DefaultClient)
+	if err != nil {
+		t.Fatalf("NewAuthManagerFromEnv: %v", err)
+	}
+	mux := http.NewServeMux()
+	registerInvalidation(mux, am, svcauth.NewVerifier("k1:s3cret", time.Minute))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	event := `{"version":1,"type":"ssh","id":"k1","fingerprint":"SHA256:AAA","timestamp":"2025-01-01T00:00:00Z"}`
+	post := func(client *http.Client) int {
+		resp, err := client.Post(srv.URL+"/internal/api/auth-cache/invalidate", "application/json", bytes.NewBufferString(event))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(http.DefaultClient); code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned invalidation to be rejected, got %d", code)
+	}
+	if code := post(&http.Client{Transport: &svcauth.Transport{KeyID: "k1", Secret: []byte("wrong")}}); code != http.StatusUnauthorized {
+		t.Fatalf("expected badly signed invalidation to be rejected, got %d", code)
+	}
+	if code := post(&http.Client{Transport: &svcauth.Transport{KeyID: "k1", Secret: []byte("s3cret")}}); code != http.StatusNoContent {
+		t.Fatalf("expected signed invalidation to be accepted, got %d", code)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/overleaf/git-bridge/internal/ssh"
+	"github.com/overleaf/git-bridge/internal/svcauth"
 )
 
 var version = "dev"
@@ -22,6 +23,7 @@ func main() {
 		return
 	}
 	log.Printf("Starting git-bridge (go) with config=%s", *config)
+	mux := http.NewServeMux()
 	// Initialize AuthManager and embedded SSH server if SSH_FEATURE_ENABLED=true
 	if getenv("SSH_FEATURE_ENABLED") == "true" {
 		am, err := ssh.NewAuthManagerFromEnv(nil)
@@ -39,6 +41,11 @@ func main() {
 		}
 		defer srv.Stop(context.Background())
 		log.Printf("SSH server listening on %s", sshAddr)
+		verifier := svcauth.NewVerifierFromEnv()
+		if !verifier.Configured() {
+			log.Printf("warning: SERVICE_AUTH_KEYS not set; auth cache invalidations will be rejected")
+		}
+		registerInvalidation(mux, am, verifier)
 	}
 
 	// Start a minimal HTTP server (health endpoint) on port from PORT env or default 8080
@@ -46,7 +53,6 @@ func main() {
 	if p := getenv("PORT"); p != "" {
 		port = p
 	}
-	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -59,6 +65,13 @@ func main() {
 	}
 }
 
+// registerInvalidation serves the auth.cache.invalidate.v1 events web-profile
+// pushes (HTTP fan-out). Only requests signed with one of the verifier's keys
+// may flush the cache.
+func registerInvalidation(mux *http.ServeMux, am *ssh.AuthManager, v *svcauth.Verifier) {
+	mux.Handle("/internal/api/auth-cache/invalidate", v.Require(http.HandlerFunc(am.InvalidationHandler)))
+}
+
 func getenv(k string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// contractFiles are the contracts under specs/001-ssh-git-auth/contracts that
+// describe this service. openapi.yaml and web-profile-ssh-keys.openapi.yaml
+// describe the Node web-profile shape and are deliberately not loaded.
+var contractFiles = []string{"ssh-keys.yaml", "git-tokens.yaml", "ssh-lookup.yaml"}
+
+// defaultContractsDir is where the Docker image ships contractFiles.
+const defaultContractsDir = "/etc/webprofile-api/contracts"
+
+// Contract validation modes, selected with WEBPROFILE_CONTRACT_VALIDATION.
+const (
+	contractValidationOff      = "off"
+	contractValidationRequests = "requests"
+	contractValidationStrict   = "strict"
+)
+
+// contractValidator checks requests (and, in strict mode, responses) against
+// the OpenAPI contracts. Requests to routes not described by any contract,
+// such as the admin endpoints, pass through unchecked.
+type contractValidator struct {
+	docs    []*openapi3.T
+	routers []routers.Router
+	strict  bool
+}
+
+// contractValidatorFromEnv builds the validator from environment variables:
+// - WEBPROFILE_CONTRACTS_DIR: directory holding the contract files (default defaultContractsDir)
+// - WEBPROFILE_CONTRACT_VALIDATION: off, requests (default) or strict
+//
+// Outside off mode, contracts that cannot be loaded are an error so main
+// refuses to start rather than serving unvalidated.
+func contractValidatorFromEnv() (*contractValidator, error) {
+	dir := getEnv("WEBPROFILE_CONTRACTS_DIR", defaultContractsDir)
+	mode := getEnv("WEBPROFILE_CONTRACT_VALIDATION", contractValidationRequests)
+	if mode == contractValidationOff {
+		return nil, nil
+	}
+	if mode != contractValidationRequests && mode != contractValidationStrict {
+		return nil, fmt.Errorf("unknown WEBPROFILE_CONTRACT_VALIDATION %q", mode)
+	}
+	return loadContractValidator(dir, mode == contractValidationStrict)
+}
+
+// loadContractValidator loads and validates contractFiles from dir. With
+// strict set, non-conforming responses are replaced by a 500 so tests fail.
+func loadContractValidator(dir string, strict bool) (*contractValidator, error) {
+	v := &contractValidator{strict: strict}
+	for _, name := range contractFiles {
+		loader := openapi3.NewLoader()
+		doc, err := loader.LoadFromFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("load contract %s: %w", name, err)
+		}
+		if err := doc.Validate(loader.Context); err != nil {
+			return nil, fmt.Errorf("invalid contract %s: %w", name, err)
+		}
+		router, err := gorillamux.NewRouter(doc)
+		if err != nil {
+			return nil, fmt.Errorf("route contract %s: %w", name, err)
+		}
+		v.docs = append(v.docs, doc)
+		v.routers = append(v.routers, router)
+	}
+	return v, nil
+}
+
+func (v *contractValidator) findRoute(r *http.Request) (*routers.Route, map[string]string) {
+	for _, router := range v.routers {
+		if route, params, err := router.FindRoute(r); err == nil {
+			return route, params
+		}
+	}
+	return nil, nil
+}
+
+// contractOptions skips security checks; authMiddleware has already run.
+var contractOptions = &openapi3filter.Options{
+	AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	MultiError:         true,
+}
+
+// middleware rejects requests that do not conform to the contract with 400.
+// A nil validator is a no-op, so newRouter can always install it.
+func (v *contractValidator) middleware(next http.Handler) http.Handler {
+	if v == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params := v.findRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		in := &openapi3filter.RequestValidationInput{Request: r, PathParams: params, Route: route, Options: contractOptions}
+		if err := openapi3filter.ValidateRequest(r.Context(), in); err != nil {
+			writeContractError(w, http.StatusBadRequest, "request does not match contract", err)
+			return
+		}
+		if !v.strict {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if err := validateContractResponse(r.Context(), in, rec); err != nil {
+			log.Printf("contract violation %s %s -> %d: %v", r.Method, r.URL.Path, rec.status, err)
+			writeContractError(w, http.StatusInternalServerError, "response does not match contract", err)
+			return
+		}
+		rec.copyTo(w)
+	})
+}
+
+func validateContractResponse(ctx context.Context, in *openapi3filter.RequestValidationInput, rec *bufferedResponse) error {
+	out := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: in,
+		Status:                 rec.status,
+		Header:                 rec.header,
+		Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+		Options:                contractOptions,
+	}
+	return openapi3filter.ValidateResponse(ctx, out)
+}
+
+func writeContractError(w http.ResponseWriter, status int, message string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+		"detail":  strings.TrimSpace(err.Error()),
+	})
+}
+
+// bufferedResponse holds a handler's response until it has been validated.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wrote {
+		b.status = status
+		b.wrote = true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for k, vs := range b.header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const testContractsDir = "../../../../specs/001-ssh-git-auth/contracts"
+
+// walkState carries ids created by earlier walk steps to later ones.
+type walkState struct {
+	keyID, fingerprint, tokenID, token string
+}
+
+// contractWalk exercises every contract operation in dependency order. Each
+// step's response is checked by the strict validator before it is returned.
+var contractWalk = []struct {
+	op    string
+	build func(s *walkState) (method, path string, body interface{})
+	after func(t *testing.T, s *walkState, out map[string]interface{})
+}{
+	{op: "POST /internal/api/users/{userId}/ssh-keys", build: func(s *walkState) (string, string, interface{}) {
+		return "POST", "/internal/api/users/contract-walk/ssh-keys", map[string]string{"public_key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIcontractwalk", "key_name": "walk"}
+	}, after: func(t *testing.T, s *walkState, out map[string]interface{}) {
+		s.keyID, _ = out["id"].(string)
+		s.fingerprint, _ = out["fingerprint"].(string)
+	}},
+	{op: "GET /internal/api/ssh-keys/{fingerprint}", build: func(s *walkState) (string, string, interface{}) {
+		return "GET", "/internal/api/ssh-keys/" + url.PathEscape(s.fingerprint), nil
+	}},
+	{op: "POST /internal/api/ssh-keys/usage", build: func(s *walkState) (string, string, interface{}) {
+		return "POST", "/internal/api/ssh-keys/usage", map[string]string{"fingerprint": s.fingerprint, "sourceIp": "192.0.2.1"}
+	}},
+	{op: "GET /internal/api/users/{userId}/ssh-keys", build: func(s *walkState) (string, string, interface{}) {
+		return "GET", "/internal/api/users/contract-walk/ssh-keys?limit=10&sort=-created&type=ssh-ed25519", nil
+	}},
+	{op: "POST /internal/api/users/{userId}/ssh-keys/import", build: func(s *walkState) (string, string, interface{}) {
+		file := "# laptop\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIcontractwalk\nno-pty,command=\"git\" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIcontractwalk2 ci@example\n"
+		return "POST", "/internal/api/users/contract-walk/ssh-keys/import", map[string]string{"authorized_keys": file}
+	}},
+	{op: "GET /internal/api/users/{userId}.keys", build: func(s *walkState) (string, string, interface{}) {
+		return "GET", "/internal/api/users/contract-walk.keys?type=ssh-ed25519", nil
+	}},
+	{op: "POST /internal/api/users/{userId}/git-tokens", build: func(s *walkState) (string, string, interface{}) {
+		return "POST", "/internal/api/users/contract-walk/git-tokens", map[string]interface{}{"label": "walk", "scopes": []string{"repo:read"}}
+	}, after: func(t *testing.T, s *walkState, out map[string]interface{}) {
+		s.tokenID, _ = out["id"].(string)
+		s.token, _ = out["token"].(string)
+	}},
+	{op: "GET /internal/api/users/{userId}/git-tokens", build: func(s *walkState) (string, string, interface{}) {
+		return "GET", "/internal/api/users/contract-walk/git-tokens?active=true&scope=repo:read", nil
+	}},
+	{op: "POST /internal/api/tokens/introspect", build: func(s *walkState) (string, string, interface{}) {
+		return "POST", "/internal/api/tokens/introspect", map[string]string{"token": s.token, "sourceIp": "192.0.2.1"}
+	}},
+	{op: "GET /internal/api/users/{userId}/git-tokens/{tokenId}/usage", build: func(s *walkState) (string, string, interface{}) {
+		return "GET", "/internal/api/users/contract-walk/git-tokens/" + s.tokenID + "/usage?limit=5", nil
+	}},
+	{op: "POST /internal/api/users/{userId}/git-tokens/{tokenId}/rotate", build: func(s *walkState) (string, string, interface{}) {
+		return "POST", "/internal/api/users/contract-walk/git-tokens/" + s.tokenID + "/rotate", map[string]int{"gracePeriodSeconds": 0}
+	}, after: func(t *testing.T, s *walkState, out map[string]interface{}) {
+		s.tokenID, _ = out["id"].(string)
+	}},
+	{op: "DELETE /internal/api/users/{userId}/git-tokens/{tokenId}", build: func(s *walkState) (string, string, interface{}) {
+		return "DELETE", "/internal/api/users/contract-walk/git-tokens/" + s.tokenID, nil
+	}},
+	{op: "DELETE /internal/api/users/{userId}/ssh-keys/{keyId}", build: func(s *walkState) (string, string, interface{}) {
+		return "DELETE", "/internal/api/users/contract-walk/ssh-keys/" + s.keyID, nil
+	}},
+}
+
+func loadTestContracts(t *testing.T) *contractValidator {
+	t.Helper()
+	v, err := loadContractValidator(testContractsDir, true)
+	if err != nil {
+		t.Fatalf("load contracts: %v", err)
+	}
+	return v
+}
+
+// useContracts installs v for the duration of the test and enables Basic auth.
+func useContracts(t *testing.T, v *contractValidator) {
+	t.Helper()
+	prev := contracts
+	contracts = v
+	t.Cleanup(func() { contracts = prev })
+	t.Setenv("WEBPROFILE_ADMIN_USER", "contract")
+	t.Setenv("WEBPROFILE_ADMIN_PASS", "contract")
+}
+
+func contractRequest(t *testing.T, method, path string, body interface{}) *http.Request {
+	t.Helper()
+	var req *http.Request
+	if body != nil {
+		b, _ := json.Marshal(body)
+		req = httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	req.SetBasicAuth("contract", "contract")
+	return req
+}
+
+func TestContractWalkCoversEveryOperation(t *testing.T) {
+	v := loadTestContracts(t)
+	walked := map[string]bool{}
+	for _, step := range contractWalk {
+		walked[step.op] = true
+	}
+	router := newRouter(context.Background(), nil)
+	for _, doc := range v.docs {
+		for path, item := range doc.Paths.Map() {
+			for method := range item.Operations() {
+				op := method + " " + path
+				if !walked[op] {
+					t.Errorf("contract operation %s has no walk step", op)
+				}
+				concrete := strings.NewReplacer("{userId}", "u1", "{keyId}", "k1", "{tokenId}", "t1", "{fingerprint}", "SHA256:x").Replace(path)
+				var m mux.RouteMatch
+				if !router.Match(httptest.NewRequest(method, concrete, nil), &m) || m.MatchErr != nil {
+					t.Errorf("contract operation %s is not routed by newRouter", op)
+				}
+			}
+		}
+	}
+}
+
+func TestContractRejectsNonConformingRequests(t *testing.T) {
+	useContracts(t, loadTestContracts(t))
+	// nil client: every case must be rejected before a handler touches Mongo
+	router := newRouter(context.Background(), nil)
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"camelCase ssh key body", "POST", "/internal/api/users/u1/ssh-keys", map[string]string{"publicKey": "ssh-ed25519 AAAA", "keyName": "k"}},
+		{"limit out of range", "GET", "/internal/api/users/u1/ssh-keys?limit=0", nil},
+		{"unknown sort", "GET", "/internal/api/users/u1/git-tokens?sort=label", nil},
+		{"non-boolean filter", "GET", "/internal/api/users/u1/git-tokens?active=maybe", nil},
+		{"scopes not an array", "POST", "/internal/api/users/u1/git-tokens", map[string]string{"scopes": "repo:read"}},
+		{"introspect without token", "POST", "/internal/api/tokens/introspect", map[string]string{"sourceIp": "192.0.2.1"}},
+		{"negative grace period", "POST", "/internal/api/users/u1/git-tokens/t1/rotate", map[string]int{"gracePeriodSeconds": -1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, contractRequest(t, c.method, c.path, c.body))
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var out map[string]string
+			json.NewDecoder(rr.Body).Decode(&out)
+			if out["message"] != "request does not match contract" {
+				t.Fatalf("unexpected body: %v", out)
+			}
+		})
+	}
+}
+
+func TestContractStrictModeRejectsNonConformingResponses(t *testing.T) {
+	v := loadTestContracts(t)
+	r := mux.NewRouter()
+	r.Use(v.middleware)
+	r.HandleFunc("/internal/api/users/{userId}/ssh-keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id": 5, "keyName": "drifted"}]`))
+	}).Methods("GET")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/internal/api/users/u1/ssh-keys", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for drifted response, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// outside strict mode the handler's response is passed through as is
+	v.strict = false
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/internal/api/users/u1/ssh-keys", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 without strict mode, got %d", rr.Code)
+	}
+}
+
+func TestContractValidatorFromEnvFailsWithoutContracts(t *testing.T) {
+	t.Setenv("WEBPROFILE_CONTRACTS_DIR", t.TempDir())
+	for _, mode := range []string{"", contractValidationRequests, contractValidationStrict} {
+		t.Setenv("WEBPROFILE_CONTRACT_VALIDATION", mode)
+		if _, err := contractValidatorFromEnv(); err == nil {
+			t.Fatalf("mode %q: expected an error for a directory without contracts", mode)
+		}
+	}
+	t.Setenv("WEBPROFILE_CONTRACT_VALIDATION", contractValidationOff)
+	if v, err := contractValidatorFromEnv(); err != nil || v != nil {
+		t.Fatalf("off mode: got %v, %v; want no validator", v, err)
+	}
+	t.Setenv("WEBPROFILE_CONTRACTS_DIR", testContractsDir)
+	t.Setenv("WEBPROFILE_CONTRACT_VALIDATION", contractValidationStrict)
+	if v, err := contractValidatorFromEnv(); err != nil || v == nil || !v.strict {
+		t.Fatalf("strict mode: got %v, %v", v, err)
+	}
+}
+
+func TestContractWalkIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	db := client.Database("sharelatex")
+	db.Collection(collName).DeleteMany(ctx, bson.M{"userId": "contract-walk"})
+	db.Collection("personalaccesstokens").DeleteMany(ctx, bson.M{"userId": "contract-walk"})
+
+	useContracts(t, loadTestContracts(t))
+	router := newRouter(ctx, client)
+	state := &walkState{}
+	for _, step := range contractWalk {
+		method, path, body := step.build(state)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, contractRequest(t, method, path, body))
+		if rr.Code >= 400 {
+			t.Fatalf("%s: status %d: %s", step.op, rr.Code, rr.Body.String())
+		}
+		if step.after != nil {
+			var out map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+				t.Fatalf("%s: decode %q: %v", step.op, rr.Body.String(), err)
+			}
+			step.after(t, state, out)
+		}
+		t.Logf("%s -> %d", step.op, rr.Code)
+	}
+}
@@ -4,7 +4,16 @@ cd "$(dirname "$0")"
 # start server in background
 # Use GO_RUN_TIMEOUT to prevent a `go run` process from hanging indefinitely (e.g. '30s')
 GO_RUN_TIMEOUT="${GO_RUN_TIMEOUT:-30s}"
-GO_CMD="timeout $GO_RUN_TIMEOUT go run main.go"
+# Basic auth is opt-in; enable the dev credentials used by the checks below
+export WEBPROFILE_ADMIN_USER="${WEBPROFILE_ADMIN_USER:-overleaf}"
+export WEBPROFILE_ADMIN_PASS="${WEBPROFILE_ADMIN_PASS:-overleaf}"
+# Signed service auth for the Node WebProfileClient check at the end
+export SERVICE_AUTH_KEY_ID="${SERVICE_AUTH_KEY_ID:-integration-web}"
+export SERVICE_AUTH_SECRET="${SERVICE_AUTH_SECRET:-$(head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n')}"
+export SERVICE_AUTH_KEYS="$SERVICE_AUTH_KEY_ID:$SERVICE_AUTH_SECRET"
+# Contract validation is on by default; point it at the contracts in the repo
+export WEBPROFILE_CONTRACTS_DIR="${WEBPROFILE_CONTRACTS_DIR:-$(cd ../../../../specs/001-ssh-git-auth/contracts && pwd)}"
+GO_CMD="timeout $GO_RUN_TIMEOUT go run ."
 $GO_CMD > /tmp/go_webprofile_integration.log 2>&1 &
 PID=$!
 trap 'kill $PID || true; wait $PID 2>/dev/null || true' EXIT
@@ -72,6 +81,19 @@ if ! grep -q '"message"[[:space:]]*:[[:space:]]*"invalid token format"' /tmp/int
   exit 1
 fi
 
+# Node WebProfileClient -> webprofile-api with signing on (Basic auth is not
+# sent by the client when SERVICE_AUTH_KEY_ID/SERVICE_AUTH_SECRET are set)
+WEB_DIR="$(cd ../../../web && pwd)"
+if [ -x "$WEB_DIR/node_modules/.bin/mocha" ]; then
+  if ! (cd "$WEB_DIR" && TARGET_BASE_URL=http://localhost:3900 node_modules/.bin/mocha --exit test/contract/src/WebProfileClientSignedContractTest.mjs); then
+    echo "Signed Node WebProfileClient checks failed"
+    cat /tmp/go_webprofile_integration.log || true
+    exit 1
+  fi
+else
+  echo "Skipping signed Node WebProfileClient checks: run npm install in services/web first"
+fi
+
 echo "Integration tests passed"
 
 # cleanup
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overleaf/git-bridge/internal/svcauth"
+)
+
+// cacheInvalidation is an auth.cache.invalidate.v1 event (see
+// specs/auth-cache-invalidate.v1.json). git-bridge instances drop cached
+// lookups for the referenced token or SSH key when they receive one.
+type cacheInvalidation struct {
+	Version     int    `json:"version" bson:"version"`
+	Type        string `json:"type" bson:"type"`
+	ID          string `json:"id" bson:"id"`
+	HashPrefix  string `json:"hashPrefix,omitempty" bson:"hashPrefix,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty" bson:"fingerprint,omitempty"`
+	Reason      string `json:"reason,omitempty" bson:"reason,omitempty"`
+	Timestamp   string `json:"timestamp" bson:"timestamp"`
+}
+
+func newTokenInvalidation(id, hashPrefix, reason string) cacheInvalidation {
+	return cacheInvalidation{Version: 1, Type: "token", ID: id, HashPrefix: hashPrefix, Reason: reason, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+}
+
+func newSSHInvalidation(id, fingerprint, reason string) cacheInvalidation {
+	return cacheInvalidation{Version: 1, Type: "ssh", ID: id, Fingerprint: fingerprint, Reason: reason, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+}
+
+// invalidationPublisher delivers cache invalidation events. Implementations
+// must be safe to retry: the outbox redelivers on any error.
+type invalidationPublisher interface {
+	Publish(ctx context.Context, ev cacheInvalidation) error
+}
+
+// logPublisher only logs events; it is the default until a broker is configured.
+type logPublisher struct{}
+
+func (logPublisher) Publish(ctx context.Context, ev cacheInvalidation) error {
+	b, _ := json.Marshal(ev)
+	log.Printf("auth.cache.invalidate.v1 %s", b)
+	return nil
+}
+
+// httpPublisher fans events out to every configured git-bridge endpoint,
+// signing each request with keyID and secret. A failure on any endpoint fails
+// the publish so the outbox retries; receivers treat duplicate invalidations
+// as no-ops.
+type httpPublisher struct {
+	client *http.Client
+	urls   []string
+	keyID  string
+	secret []byte
+}
+
+func (p *httpPublisher) Publish(ctx context.Context, ev cacheInvalidation) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, u := range p.urls {
+		req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(b))
+		if err != nil {
+			failed = append(failed, u)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := svcauth.SignRequest(req, p.keyID, p.secret, b); err != nil {
+			return err
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			failed = append(failed, u)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			failed = append(failed, u)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("invalidation fan-out failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// memoryPublisher records events in memory; used by tests.
+type memoryPublisher struct {
+	mu     sync.Mutex
+	events []cacheInvalidation
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, ev cacheInvalidation) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, ev)
+	return nil
+}
+
+func (p *memoryPublisher) Events() []cacheInvalidation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]cacheInvalidation(nil), p.events...)
+}
+
+// invalidationPublisherFromEnv selects the publisher from environment variables:
+// - AUTH_CACHE_PUBLISHER: log (default), http or memory
+// - AUTH_CACHE_INVALIDATE_URLS: comma-separated endpoints for http
+// - SERVICE_AUTH_KEY_ID / SERVICE_AUTH_SECRET: the key http requests are signed with
+func invalidationPublisherFromEnv() (invalidationPublisher, error) {
+	switch kind := getEnv("AUTH_CACHE_PUBLISHER", "log"); kind {
+	case "log":
+		return logPublisher{}, nil
+	case "memory":
+		return &memoryPublisher{}, nil
+	case "http":
+		var urls []string
+		for _, u := range strings.Split(getEnv("AUTH_CACHE_INVALIDATE_URLS", ""), ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("AUTH_CACHE_INVALIDATE_URLS required for http publisher")
+		}
+		keyID, secret := getEnv("SERVICE_AUTH_KEY_ID", ""), getEnv("SERVICE_AUTH_SECRET", "")
+		if keyID == "" || secret == "" {
+			return nil, fmt.Errorf("SERVICE_AUTH_KEY_ID and SERVICE_AUTH_SECRET required for http publisher")
+		}
+		return &httpPublisher{client: &http.Client{Timeout: 5 * time.Second}, urls: urls, keyID: keyID, secret: []byte(secret)}, nil
+	default:
+		return nil, fmt.Errorf("unknown AUTH_CACHE_PUBLISHER %q", kind)
+	}
+}
+
+var invalidations invalidationPublisher = logPublisher{}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/overleaf/git-bridge/internal/svcauth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestInvalidationEventShape(t *testing.T) {
+	b, _ := json.Marshal(newSSHInvalidation("k1", "SHA256:abc", "deleted"))
+	var out map[string]interface{}
+	json.Unmarshal(b, &out)
+	// required by specs/auth-cache-invalidate.v1.json
+	for _, f := range []string{"version", "type", "id", "timestamp"} {
+		if _, ok := out[f]; !ok {
+			t.Fatalf("missing required field %s in %s", f, b)
+		}
+	}
+	if out["type"] != "ssh" || out["fingerprint"] != "SHA256:abc" || out["version"] != float64(1) {
+		t.Fatalf("unexpected event: %s", b)
+	}
+	if _, err := time.Parse(time.RFC3339, out["timestamp"].(string)); err != nil {
+		t.Fatalf("timestamp not RFC3339: %v", err)
+	}
+}
+
+func TestHTTPPublisherFanOut(t *testing.T) {
+	var okCalls int32
+	// receivers check the signature as git-bridge does
+	verifier := svcauth.NewVerifier("k1:s3cret", time.Minute)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifier.Verify(r, time.Now()); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&okCalls, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ok.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	ev := newTokenInvalidation("t1", "abcd1234", "revoked")
+	p := &httpPublisher{client: ok.Client(), urls: []string{ok.URL, ok.URL}, keyID: "k1", secret: []byte("s3cret")}
+	if err := p.Publish(context.Background(), ev); err != nil {
+		t.Fatalf("expected fan-out to succeed, got %v", err)
+	}
+	if atomic.LoadInt32(&okCalls) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", okCalls)
+	}
+
+	// any failing endpoint fails the publish so the outbox retries
+	p2 := &httpPublisher{client: ok.Client(), urls: []string{ok.URL, down.URL}, keyID: "k1", secret: []byte("s3cret")}
+	if err := p2.Publish(context.Background(), ev); err == nil {
+		t.Fatalf("expected error when an endpoint fails")
+	}
+
+	// a publisher signing with the wrong secret is refused
+	p3 := &httpPublisher{client: ok.Client(), urls: []string{ok.URL}, keyID: "k1", secret: []byte("wrong")}
+	if err := p3.Publish(context.Background(), ev); err == nil {
+		t.Fatalf("expected error when the signature is rejected")
+	}
+}
+
+func TestMemoryPublisherAndBackoff(t *testing.T) {
+	p := &memoryPublisher{}
+	p.Publish(context.Background(), newTokenInvalidation("t1", "abcd1234", "revoked"))
+	if ev := p.Events(); len(ev) != 1 || ev[0].HashPrefix != "abcd1234" {
+		t.Fatalf("unexpected events: %+v", ev)
+	}
+	if outboxBackoff(1) != time.Second || outboxBackoff(3) != 4*time.Second {
+		t.Fatalf("unexpected backoff progression")
+	}
+	if outboxBackoff(50) != 5*time.Minute {
+		t.Fatalf("expected backoff to cap at 5m")
+	}
+}
+
+func TestDispatchOutboxClaimsEachRowOnceIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	db := client.Database("sharelatex_outbox_test")
+	defer db.Drop(ctx)
+	coll := db.Collection(outboxCollName)
+	for i := 0; i < 20; i++ {
+		if err := enqueueInvalidation(ctx, db, newTokenInvalidation(fmt.Sprint("t", i), "abcd1234", "revoked")); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	bad, err := coll.InsertOne(ctx, bson.M{"event": "not an event", "createdAt": time.Now(), "nextAttemptAt": time.Now()})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// two dispatchers racing over the same rows publish each event once
+	pubs := []*memoryPublisher{{}, {}}
+	var wg sync.WaitGroup
+	for _, p := range pubs {
+		wg.Add(1)
+		go func(p *memoryPublisher) {
+			defer wg.Done()
+			dispatchOutbox(ctx, coll, p, time.Now())
+		}(p)
+	}
+	wg.Wait()
+	seen := map[string]int{}
+	for _, p := range pubs {
+		for _, ev := range p.Events() {
+			seen[ev.ID]++
+		}
+	}
+	if len(seen) != 20 {
+		t.Fatalf("expected 20 distinct events, got %d", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Fatalf("event %s published %d times", id, n)
+		}
+	}
+
+	var row bson.M
+	if err := coll.FindOne(ctx, bson.M{"_id": bad.InsertedID}).Decode(&row); err != nil {
+		t.Fatalf("find undecodable row: %v", err)
+	}
+	if _, ok := row["failedAt"]; !ok {
+		t.Fatalf("undecodable row not marked failed: %v", row)
+	}
+}
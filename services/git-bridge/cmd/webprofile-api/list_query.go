@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// List endpoints page with an opaque keyset cursor over (sort field, _id).
+// The body stays a plain JSON array for compatibility; the cursor for the next
+// page, if any, is returned in the X-Next-Cursor header.
+const (
+	defaultPageSize  = 100
+	maxPageSize      = 500
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+var errInvalidListQuery = errors.New("invalid list query")
+
+type listCursor struct {
+	V  time.Time `json:"v"`
+	ID string    `json:"id"`
+}
+
+// listQuery is the parsed limit/sort/cursor part of a list request.
+type listQuery struct {
+	Limit int64
+	Field string
+	Desc  bool
+	After *listCursor
+}
+
+// parseListQuery reads `limit`, `cursor` and `sort` (one of the keys of
+// sortFields, optionally prefixed with '-' for descending).
+func parseListQuery(r *http.Request, sortFields map[string]string, defaultSort string) (listQuery, error) {
+	q := r.URL.Query()
+	lq := listQuery{Limit: defaultPageSize}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return lq, errInvalidListQuery
+		}
+		lq.Limit = n
+	}
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = defaultSort
+	}
+	if strings.HasPrefix(sort, "-") {
+		lq.Desc = true
+		sort = sort[1:]
+	}
+	field, ok := sortFields[sort]
+	if !ok {
+		return lq, errInvalidListQuery
+	}
+	lq.Field = field
+	if v := q.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return lq, errInvalidListQuery
+		}
+		var c listCursor
+		if err := json.Unmarshal(b, &c); err != nil {
+			return lq, errInvalidListQuery
+		}
+		if _, err := primitive.ObjectIDFromHex(c.ID); err != nil {
+			return lq, errInvalidListQuery
+		}
+		lq.After = &c
+	}
+	return lq, nil
+}
+
+// pipeline builds the aggregation for one page. Documents missing the sort
+// field (e.g. never-used tokens when sorting by lastUsedAt) sort as the epoch.
+// One extra document is fetched to detect whether a next page exists.
+func (lq listQuery) pipeline(match bson.M) mongo.Pipeline {
+	dir := 1
+	cmp := "$gt"
+	if lq.Desc {
+		dir = -1
+		cmp = "$lt"
+	}
+	p := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{"_sortKey": bson.M{"$ifNull": bson.A{"$" + lq.Field, time.Unix(0, 0).UTC()}}}}},
+	}
+	if lq.After != nil {
+		id, _ := primitive.ObjectIDFromHex(lq.After.ID)
+		p = append(p, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"_sortKey": bson.M{cmp: lq.After.V}},
+			bson.M{"_sortKey": lq.After.V, "_id": bson.M{cmp: id}},
+		}}}})
+	}
+	p = append(p,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_sortKey", Value: dir}, {Key: "_id", Value: dir}}}},
+		bson.D{{Key: "$limit", Value: lq.Limit + 1}},
+	)
+	return p
+}
+
+func encodeListCursor(v time.Time, id primitive.ObjectID) string {
+	b, _ := json.Marshal(listCursor{V: v, ID: id.Hex()})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeInvalidListQuery(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"message": errInvalidListQuery.Error()})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseListQuery(t *testing.T) {
+	req := httptest.NewRequest("GET", "/internal/api/users/u1/git-tokens", nil)
+	lq, err := parseListQuery(req, tokenSortFields, "created")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if lq.Limit != defaultPageSize || lq.Field != "createdAt" || lq.Desc || lq.After != nil {
+		t.Fatalf("unexpected defaults: %+v", lq)
+	}
+
+	id := primitive.NewObjectID()
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	req2 := httptest.NewRequest("GET", "/x?limit=10&sort=-lastUsed&cursor="+encodeListCursor(at, id), nil)
+	lq2, err := parseListQuery(req2, tokenSortFields, "created")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if lq2.Limit != 10 || lq2.Field != "lastUsedAt" || !lq2.Desc {
+		t.Fatalf("unexpected query: %+v", lq2)
+	}
+	if lq2.After == nil || lq2.After.ID != id.Hex() || !lq2.After.V.Equal(at) {
+		t.Fatalf("cursor did not round-trip: %+v", lq2.After)
+	}
+
+	for _, bad := range []string{"?limit=0", "?limit=100000", "?sort=hash", "?cursor=not-a-cursor!"} {
+		if _, err := parseListQuery(httptest.NewRequest("GET", "/x"+bad, nil), tokenSortFields, "created"); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestTokenDTOHidesHashMaterial(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	rec := tokenRecord{ID: primitive.NewObjectID(), TokenID: "0123456789abcdef", Label: "ci", Active: true, ExpiresAt: &past}
+	d := rec.dto(time.Now())
+	if !d.Expired {
+		t.Fatalf("expected expired token to be flagged")
+	}
+	b, _ := json.Marshal(d)
+	var out map[string]interface{}
+	json.Unmarshal(b, &out)
+	for _, f := range []string{"hash", "hashPrefix", "hashParams", "algorithm"} {
+		if _, ok := out[f]; ok {
+			t.Fatalf("list DTO must not expose %s: %s", f, b)
+		}
+	}
+	if out["id"] != rec.ID.Hex() {
+		t.Fatalf("expected hex id, got %v", out["id"])
+	}
+	// the public structured id stands in for hashPrefix as the display prefix
+	if out["tokenId"] != "0123456789abcdef" {
+		t.Fatalf("expected public tokenId, got %v", out["tokenId"])
+	}
+}
+
+func TestTokenListFilter(t *testing.T) {
+	now := time.Now()
+	f, err := tokenListFilter(httptest.NewRequest("GET", "/x?active=true&expired=true&scope=repo:read", nil), "u1", now)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if f["active"] != true || f["scopes"] != "repo:read" || f["expiresAt"] == nil {
+		t.Fatalf("unexpected filter: %+v", f)
+	}
+	if _, err := tokenListFilter(httptest.NewRequest("GET", "/x?active=maybe", nil), "u1", now); err == nil {
+		t.Fatalf("expected error for non-boolean active")
+	}
+}
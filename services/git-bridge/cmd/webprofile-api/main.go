@@ -3,22 +3,26 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
-	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/crypto/pbkdf2"
+	"github.com/overleaf/git-bridge/internal/svcauth"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -46,26 +50,49 @@ type SSHKey struct {
 	CreatedAt  time.Time   `bson:"createdAt,omitempty" json:"created_at"`
 	UpdatedAt  time.Time   `bson:"updatedAt,omitempty" json:"updated_at"`
 	UserID     interface{} `bson:"userId,omitempty" json:"userId"`
+	ExpiresAt  *time.Time  `bson:"expiresAt,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time  `bson:"lastUsedAt,omitempty" json:"last_used_at,omitempty"`
+	DisabledAt *time.Time  `bson:"disabledAt,omitempty" json:"disabled_at,omitempty"`
+	// LegacyFingerprint is set on keys migrated from the whole-line fingerprint
+	LegacyFingerprint string `bson:"legacyFingerprint,omitempty" json:"-"`
 }
 
+// computeFingerprint returns the OpenSSH SHA256 fingerprint of the decoded key
+// blob, matching `ssh-keygen -l`, the Node web service and git-bridge. Input
+// without a decodable blob falls back to hashing the whole string.
 func computeFingerprint(publicKey string) string {
-	h := sha256.Sum256([]byte(publicKey))
+	data := []byte(publicKey)
+	if fields := strings.Fields(publicKey); len(fields) >= 2 {
+		if blob, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+			data = blob
+		}
+	}
+	h := sha256.Sum256(data)
 	enc := base64.StdEncoding.EncodeToString(h[:])
 	return "SHA256:" + enc
 }
 
-// requireAuth enforces a simple Basic auth check for parity with the Node web service.
-// In dev/CI the default credentials are overleaf:overleaf; these can be overridden with
-// WEBPROFILE_ADMIN_USER and WEBPROFILE_ADMIN_PASS env vars.
+// requireAuth authenticates internal API calls. Services sign requests with an
+// HMAC key (see internal/svcauth); a signed request that fails verification is
+// rejected with 401. Basic auth is only accepted when WEBPROFILE_ADMIN_USER and
+// WEBPROFILE_ADMIN_PASS are both set explicitly (dev/CI); there is no default.
 func requireAuth(w http.ResponseWriter, r *http.Request) bool {
-	adminUser := getEnv("WEBPROFILE_ADMIN_USER", "overleaf")
-	adminPass := getEnv("WEBPROFILE_ADMIN_PASS", "overleaf")
-	user, pass, ok := r.BasicAuth()
-	if ok && user == adminUser && pass == adminPass {
+	if r.Header.Get(svcauth.HeaderKeyID) != "" {
+		if err := serviceAuth.Verify(r, time.Now()); err != nil {
+			log.Printf("service auth rejected %s %s: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"message": "invalid service signature"})
+			return false
+		}
 		return true
 	}
-	// Fallback: if X-Service-Origin header present, allow (internal service calls)
-	if r.Header.Get("X-Service-Origin") != "" {
+	adminUser := getEnv("WEBPROFILE_ADMIN_USER", "")
+	adminPass := getEnv("WEBPROFILE_ADMIN_PASS", "")
+	user, pass, ok := r.BasicAuth()
+	if ok && adminUser != "" && adminPass != "" &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(adminUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(adminPass)) == 1 {
 		return true
 	}
 	// Mirror Node behaviour: unauthenticated requests are redirected to login (302)
@@ -75,62 +102,202 @@ func requireAuth(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
+// authMiddleware applies requireAuth to every internal route.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth(w, r) { return }
+		next.ServeHTTP(w, r)
+	})
+}
+
 func main() {
-	ctx := context.Background()
+	// ctx ends on SIGINT/SIGTERM and stops the background loops; requests
+	// in flight during shutdown keep an uncancelled context
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	clientOpts := options.Client().ApplyURI(mongoURI)
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		log.Fatalf("mongo connect: %v", err)
 	}
-	defer client.Disconnect(ctx)
+	defer client.Disconnect(context.Background())
+
+	tokenPolicy, err = tokenPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("token policy: %v", err)
+	}
+	argon2Params, err = argon2ParamsFromEnv()
+	if err != nil {
+		log.Fatalf("token hashing: %v", err)
+	}
+	sshKeyPolicy, err = sshKeyPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("ssh key policy: %v", err)
+	}
+	usageRetention, err := envDays("TOKEN_USAGE_HISTORY_DAYS", 90)
+	if err != nil {
+		log.Fatalf("token usage: %v", err)
+	}
 
 	coll := client.Database("sharelatex").Collection(collName)
 	// Ensure unique index on fingerprint for idempotency
 	go ensureIndex(ctx, coll)
+	go ensureLegacyFingerprintIndex(ctx, coll)
+	go runSSHKeyFingerprintBackfill(ctx, client.Database("sharelatex"), coll)
+	go ensureSSHKeyLifecycleIndexes(ctx, coll)
+	go ensureTokenIndexes(ctx, client.Database("sharelatex").Collection("personalaccesstokens"))
+	go ensureUsageIndexes(ctx, client.Database("sharelatex").Collection("personalaccesstokenusage"), usageRetention)
+
+	// Token usage is aggregated in memory and flushed periodically
+	usageRecorder = newTokenUsageRecorder(client.Database("sharelatex"))
+	go usageRecorder.run(ctx)
+
+	// Rotated tokens lapse once their grace period ends
+	go runRotationSweeper(ctx, client.Database("sharelatex").Collection("personalaccesstokens"))
+
+	// Expiring keys are announced to their owners; unused keys may be disabled
+	go runSSHKeySweeper(ctx, coll)
+
+	// Cache invalidations are delivered from the outbox by a background dispatcher
+	pub, err := invalidationPublisherFromEnv()
+	if err != nil {
+		log.Fatalf("invalidation publisher: %v", err)
+	}
+	invalidations = pub
+	go ensureOutboxIndexes(ctx, client.Database("sharelatex").Collection(outboxCollName))
+	go runOutboxDispatcher(ctx, client.Database("sharelatex"), invalidations)
+
+	contracts, err = contractValidatorFromEnv()
+	if err != nil {
+		log.Fatalf("contract validation: %v", err)
+	}
+
+	serviceAuth.WithNonceStore(mongoNonceStore{coll: client.Database("sharelatex").Collection(nonceCollName)})
+	go ensureNonceIndexes(ctx, client.Database("sharelatex").Collection(nonceCollName))
+	if !serviceAuth.Configured() {
+		log.Printf("warning: SERVICE_AUTH_KEYS not set; signed service requests will be rejected")
+	}
 
-	r := mux.NewRouter()
+	srv := &http.Server{Addr: ":3900", Handler: newRouter(context.WithoutCancel(ctx), client)}
+	go func() {
+		log.Printf("webprofile-api listening on %s (mongo=%s)", srv.Addr, mongoURI)
+		if err := serve(srv); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("http serve: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	// usage recorded by the last requests is still pending
+	usageRecorder.flush(shutdownCtx)
+}
+
+// contracts is set by main; when nil, requests are not checked against the
+// OpenAPI contracts.
+var contracts *contractValidator
+
+// newRouter registers all internal routes behind authMiddleware and, when
+// configured, contract validation.
+func newRouter(ctx context.Context, client *mongo.Client) *mux.Router {
+	var coll *mongo.Collection
+	if client != nil {
+		coll = client.Database("sharelatex").Collection(collName)
+	}
+	// SkipClean: base64 fingerprints may contain "//", which must not be collapsed
+	r := mux.NewRouter().SkipClean(true)
+	r.Use(authMiddleware)
+	r.Use(contracts.middleware)
 	r.HandleFunc("/internal/api/users/{userId}/ssh-keys", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		listHandler(ctx, w, r, coll)
 	}).Methods("GET")
 
 	r.HandleFunc("/internal/api/users/{userId}/ssh-keys", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		createHandler(ctx, w, r, coll)
 	}).Methods("POST")
 
 	r.HandleFunc("/internal/api/users/{userId}/ssh-keys/{keyId}", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		deleteHandler(ctx, w, r, coll)
 	}).Methods("DELETE")
 
+	// fingerprint lookup and usage reports from git-bridge; fingerprints may
+	// contain '/' so the lookup variable spans the rest of the path
+	r.HandleFunc("/internal/api/ssh-keys/usage", func(w http.ResponseWriter, r *http.Request) {
+		sshKeyUsageHandler(ctx, w, r, coll)
+	}).Methods("POST")
+
+	r.HandleFunc("/internal/api/ssh-keys/{fingerprint:.+}", func(w http.ResponseWriter, r *http.Request) {
+		sshKeyLookupHandler(ctx, w, r, coll)
+	}).Methods("GET")
+
+	// bulk authorized_keys import and GitHub-style export
+	r.HandleFunc("/internal/api/users/{userId}/ssh-keys/import", func(w http.ResponseWriter, r *http.Request) {
+		importKeysHandler(ctx, w, r, coll)
+	}).Methods("POST")
+
+	r.HandleFunc("/internal/api/users/{userId}.keys", func(w http.ResponseWriter, r *http.Request) {
+		exportKeysHandler(ctx, w, r, coll)
+	}).Methods("GET")
+
 	// token introspection (private API) — expect JSON { token }
 	r.HandleFunc("/internal/api/tokens/introspect", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		tokenIntrospectHandler(ctx, w, r, client)
 	}).Methods("POST")
 
 	// Token management endpoints (create/list/revoke) for git tokens
 	r.HandleFunc("/internal/api/users/{userId}/git-tokens", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		tokenCreateHandler(ctx, w, r, client)
 	}).Methods("POST")
 
 	r.HandleFunc("/internal/api/users/{userId}/git-tokens", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		tokenListHandler(ctx, w, r, client)
 	}).Methods("GET")
 
 	r.HandleFunc("/internal/api/users/{userId}/git-tokens/{tokenId}", func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth(w, r) { return }
 		tokenRevokeHandler(ctx, w, r, client)
 	}).Methods("DELETE")
 
-	srv := &http.Server{Addr: ":3900", Handler: r}
-	log.Printf("webprofile-api listening on %s (mongo=%s)", srv.Addr, mongoURI)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("http serve: %v", err)
+	r.HandleFunc("/internal/api/users/{userId}/git-tokens/{tokenId}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		tokenRotateHandler(ctx, w, r, client)
+	}).Methods("POST")
+
+	// admin report: hash algorithm distribution across active tokens
+	r.HandleFunc("/internal/api/admin/tokens/hash-report", func(w http.ResponseWriter, r *http.Request) {
+		tokenHashReportHandler(ctx, w, r, client)
+	}).Methods("GET")
+
+	r.HandleFunc("/internal/api/users/{userId}/git-tokens/{tokenId}/usage", func(w http.ResponseWriter, r *http.Request) {
+		tokenUsageHandler(ctx, w, r, client)
+	}).Methods("GET")
+	return r
+}
+
+// serve starts srv, with TLS when WEBPROFILE_TLS_CERT/WEBPROFILE_TLS_KEY are
+// set. WEBPROFILE_TLS_CLIENT_CA additionally requires client certificates
+// signed by that CA (mTLS).
+func serve(srv *http.Server) error {
+	certFile, keyFile := getEnv("WEBPROFILE_TLS_CERT", ""), getEnv("WEBPROFILE_TLS_KEY", "")
+	if certFile == "" {
+		return srv.ListenAndServe()
 	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := getEnv("WEBPROFILE_TLS_CLIENT_CA", ""); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	srv.TLSConfig = cfg
+	return srv.ListenAndServeTLS(certFile, keyFile)
 }
 
 func ensureIndex(ctx context.Context, coll *mongo.Collection) {
@@ -141,27 +308,109 @@ func ensureIndex(ctx context.Context, coll *mongo.Collection) {
 	} else {
 		log.Printf("ensured unique index on fingerprint")
 	}
+	// list pagination: keyset over (userId, createdAt, _id)
+	byUser := mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}
+	if _, err := coll.Indexes().CreateOne(ctx, byUser); err != nil {
+		log.Printf("ensureIndex: %v", err)
+	}
+}
+
+// ensureTokenIndexes creates the unique tokenId index used by structured token
+// introspection (sparse, so legacy tokens without a tokenId are allowed) and
+// the TTL index that sweeps expired tokens.
+func ensureTokenIndexes(ctx context.Context, coll *mongo.Collection) {
+	idx := mongo.IndexModel{Keys: bson.D{{Key: "tokenId", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)}
+	_, err := coll.Indexes().CreateOne(ctx, idx)
+	if err != nil {
+		log.Printf("ensureTokenIndexes: %v", err)
+	} else {
+		log.Printf("ensured unique index on tokenId")
+	}
+	byUser := mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}
+	if _, err := coll.Indexes().CreateOne(ctx, byUser); err != nil {
+		log.Printf("ensureTokenIndexes: %v", err)
+	}
+	// TTL index: MongoDB removes tokens once expiresAt + retention has passed;
+	// documents without expiresAt are never removed
+	if err := ensureTTLIndex(ctx, coll, "expiresAt", tokenPolicy.Retention); err != nil {
+		log.Printf("ensureTokenIndexes: %v", err)
+	} else {
+		log.Printf("ensured TTL index on expiresAt (retention=%s)", tokenPolicy.Retention)
+	}
+}
+
+// ensureTTLIndex creates a TTL index on field. When the index already exists
+// with another expireAfterSeconds (the retention setting was changed on an
+// existing deployment) MongoDB rejects the create with IndexOptionsConflict,
+// so the existing index is updated in place with collMod instead.
+func ensureTTLIndex(ctx context.Context, coll *mongo.Collection, field string, after time.Duration) error {
+	secs := int32(after.Seconds())
+	ttl := mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}, Options: options.Index().SetExpireAfterSeconds(secs)}
+	_, err := coll.Indexes().CreateOne(ctx, ttl)
+	if !isIndexOptionsConflict(err) {
+		return err
+	}
+	return coll.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: coll.Name()},
+		{Key: "index", Value: bson.D{
+			{Key: "keyPattern", Value: bson.D{{Key: field, Value: 1}}},
+			{Key: "expireAfterSeconds", Value: secs},
+		}},
+	}).Err()
+}
+
+func isIndexOptionsConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Name == "IndexOptionsConflict")
 }
 
 // tokenIntrospectHandler implements a provider-agnostic token introspection
-// endpoint. It checks the `personalaccesstokens` collection for candidates
-// matching the hashPrefix and verifies the supplied token using the stored
-// algorithm (argon2id, bcrypt or pbkdf2 fallback).
+// endpoint. Structured `ogt_` tokens are looked up by their embedded tokenId
+// and verified against a single stored hash. Legacy hex tokens fall back to
+// checking the `personalaccesstokens` collection for candidates matching the
+// hashPrefix and verifying each using the stored algorithm (argon2id, bcrypt
+// or pbkdf2 fallback).
 func tokenIntrospectHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, client *mongo.Client) {
 	var req struct{
 		Token string `json:"token"`
+		// SourceIP is optionally supplied by the calling service for usage tracking
+		SourceIP string `json:"sourceIp"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
 		http.Error(w, "token required", http.StatusBadRequest)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if isStructuredToken(req.Token) {
+		tokenId, ok := parseStructuredToken(req.Token)
+		if !ok {
+			writeInvalidTokenFormat(w)
+			return
+		}
+		coll := client.Database("sharelatex").Collection("personalaccesstokens")
+		var doc bson.M
+		err := coll.FindOne(ctx, bson.M{"tokenId": tokenId, "active": true}).Decode(&doc)
+		if err != nil && err != mongo.ErrNoDocuments {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		storedHash, _ := doc["hash"].(string)
+		if err == nil && verifyTokenHash(req.Token, storedHash) {
+			if needsRehash(storedHash) {
+				scheduleTokenHashUpgrade(ctx, coll, doc, req.Token)
+			}
+			writeIntrospection(w, doc, req.SourceIP)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+		return
+	}
 
-	// Reject obviously malformed tokens (tokens are hex strings)
+	// Reject obviously malformed tokens (legacy tokens are hex strings)
 	isHex, _ := regexp.MatchString("^[0-9a-fA-F]+$", req.Token)
 	if !isHex {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"message": "invalid token format"})
+		writeInvalidTokenFormat(w)
 		return
 	}
 
@@ -185,50 +434,44 @@ func tokenIntrospectHandler(ctx context.Context, w http.ResponseWriter, r *http.
 			continue
 		}
 		storedHash, _ := doc["hash"].(string)
-		ok := false
-		if strings.HasPrefix(storedHash, "$argon2") {
-			if match, err := argon2id.ComparePasswordAndHash(req.Token, storedHash); err == nil && match {
-				ok = true
-			}
-		} else if strings.HasPrefix(storedHash, "$2") {
-			if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(req.Token)); err == nil {
-				ok = true
-			}
-		} else if strings.HasPrefix(storedHash, "pbkdf2$") {
-			parts := strings.Split(storedHash, "$")
-			if len(parts) >= 3 {
-				salt, _ := hex.DecodeString(parts[1])
-				expected := parts[2]
-				derived := pbkdf2.Key([]byte(req.Token), salt, 100000, 64, sha256.New)
-				if hex.EncodeToString(derived) == expected {
-					ok = true
-				}
+		if verifyTokenHash(req.Token, storedHash) {
+			// transparently move legacy bcrypt/pbkdf2 (or stale argon2id) hashes to current params
+			if needsRehash(storedHash) {
+				scheduleTokenHashUpgrade(ctx, coll, doc, req.Token)
 			}
-		}
-		if ok {
-			// check expiry
-			expiresAtRaw := doc["expiresAt"]
-			if expiresAtRaw != nil {
-				if exp, ok2 := expiresAtRaw.(primitive.DateTime); ok2 {
-					if time.Now().After(exp.Time()) {
-						json.NewEncoder(w).Encode(map[string]interface{}{"active":false})
-						return
-					}
-				}
-			}
-			info := map[string]interface{}{
-				"active": true,
-				"userId": fmt.Sprintf("%v", doc["userId"]),
-				"scopes": doc["scopes"],
-				"expiresAt": doc["expiresAt"],
-			}
-			json.NewEncoder(w).Encode(info)
+			writeIntrospection(w, doc, req.SourceIP)
 			return
 		}
 	}
 	json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
 }
 
+func writeInvalidTokenFormat(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"message": "invalid token format"})
+}
+
+// writeIntrospection encodes the introspection result for a verified token
+// document, reporting inactive when the token has expired. Active results are
+// handed to the usage recorder.
+func writeIntrospection(w http.ResponseWriter, doc bson.M, sourceIP string) {
+	if exp, ok := doc["expiresAt"].(primitive.DateTime); ok {
+		if time.Now().After(exp.Time()) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"active":false})
+			return
+		}
+	}
+	info := map[string]interface{}{
+		"active": true,
+		"userId": fmt.Sprintf("%v", doc["userId"]),
+		"scopes": doc["scopes"],
+		"expiresAt": doc["expiresAt"],
+	}
+	usageRecorder.Record(doc, sourceIP)
+	json.NewEncoder(w).Encode(info)
+}
+
 // Token creation, listing and revocation handlers
 func tokenCreateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, client *mongo.Client) {
 	vars := mux.Vars(r)
@@ -242,63 +485,188 @@ func tokenCreateHandler(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		http.Error(w, "invalid body", http.StatusBadRequest)
 		return
 	}
-	// generate plaintext token — use crypto/rand for randomness
-	var randBytes = make([]byte, 32)
-	var plain string
-	if _, err := rand.Read(randBytes); err != nil {
-		// fallback to time-based digest if crypto/rand unavailable
-		plain = fmt.Sprintf("%s-%d", userId, time.Now().UnixNano())
-		// compute token as sha256(plain)
-		hs := sha256.Sum256([]byte(plain))
-		plain = hex.EncodeToString(hs[:])
-	} else {
-		plain = hex.EncodeToString(randBytes)
+	now := time.Now()
+	expiresAt, err := tokenPolicy.resolveExpiry(now, req.ExpiresAt)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+		return
+	}
+	doc, plain, err := issueToken(userId, req.Label, req.Scopes, expiresAt, now)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	coll := client.Database("sharelatex").Collection("personalaccesstokens")
+	res, err := coll.InsertOne(ctx, doc)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
-	// compute hash and prefix
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": res.InsertedID.(primitive.ObjectID).Hex(), "tokenId": doc["tokenId"], "token": plain, "accessTokenPartial": doc["hashPrefix"], "expiresAt": doc["expiresAt"]})
+}
+
+// issueToken generates a structured ogt_ token and the document to persist for
+// it. The tokenId is stored in clear so introspection can fetch a single
+// record instead of scanning by prefix; the plaintext is returned only here.
+func issueToken(userId, label string, scopes []string, expiresAt, now time.Time) (bson.M, string, error) {
+	tokenId, plain, err := generateStructuredToken()
+	if err != nil {
+		return nil, "", err
+	}
+	// compute hash prefix (kept for cache invalidation and display)
 	h := sha256.Sum256([]byte(plain))
 	hh := hex.EncodeToString(h[:])
 	prefix := hh[:8]
 	// choose algorithm argon2id by default
-	hash, _ := argon2id.CreateHash(plain, argon2id.DefaultParams)
-
-	coll := client.Database("sharelatex").Collection("personalaccesstokens")
+	hash, err := hashToken(plain)
+	if err != nil {
+		return nil, "", err
+	}
 	doc := bson.M{
+		"_id": primitive.NewObjectID(),
 		"userId": userId,
-		"label": req.Label,
+		"tokenId": tokenId,
+		"label": label,
 		"hash": hash,
 		"hashPrefix": prefix,
 		"algorithm": "argon2id",
-		"scopes": req.Scopes,
+		"hashParams": argon2ParamsString(argon2Params),
+		"scopes": scopes,
 		"active": true,
-		"createdAt": time.Now(),
+		"createdAt": now,
 	}
-	res, err := coll.InsertOne(ctx, doc)
-	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+	if !expiresAt.IsZero() {
+		doc["expiresAt"] = expiresAt
+	}
+	return doc, plain, nil
+}
+
+// tokenDTO is the public shape of a token in list responses. It never carries
+// hash material (hash, hashPrefix, algorithm parameters).
+type tokenDTO struct {
+	ID          string     `json:"id"`
+	TokenID     string     `json:"tokenId,omitempty"`
+	Label       string     `json:"label"`
+	Scopes      []string   `json:"scopes"`
+	Active      bool       `json:"active"`
+	Expired     bool       `json:"expired"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP  string     `json:"lastUsedIP,omitempty"`
+	UsageCount  int64      `json:"usageCount"`
+	RotatedFrom string     `json:"rotatedFrom,omitempty"`
+	RotatedTo   string     `json:"rotatedTo,omitempty"`
+}
+
+type tokenRecord struct {
+	ID          primitive.ObjectID  `bson:"_id"`
+	TokenID     string              `bson:"tokenId"`
+	Label       string              `bson:"label"`
+	Scopes      []string            `bson:"scopes"`
+	Active      bool                `bson:"active"`
+	CreatedAt   time.Time           `bson:"createdAt"`
+	ExpiresAt   *time.Time          `bson:"expiresAt"`
+	LastUsedAt  *time.Time          `bson:"lastUsedAt"`
+	LastUsedIP  string              `bson:"lastUsedIP"`
+	UsageCount  int64               `bson:"usageCount"`
+	RotatedFrom *primitive.ObjectID `bson:"rotatedFrom"`
+	RotatedTo   *primitive.ObjectID `bson:"rotatedTo"`
+	SortKey     time.Time           `bson:"_sortKey"`
+}
+
+func (t tokenRecord) dto(now time.Time) tokenDTO {
+	d := tokenDTO{
+		ID: t.ID.Hex(), TokenID: t.TokenID, Label: t.Label, Scopes: t.Scopes, Active: t.Active,
+		CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP, UsageCount: t.UsageCount,
+	}
+	if d.Scopes == nil {
+		d.Scopes = []string{}
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{"id": fmt.Sprintf("%v", res.InsertedID), "token": plain, "accessTokenPartial": prefix})
+	// expired tokens remain listed until the TTL index removes them
+	d.Expired = t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+	if t.RotatedFrom != nil {
+		d.RotatedFrom = t.RotatedFrom.Hex()
+	}
+	if t.RotatedTo != nil {
+		d.RotatedTo = t.RotatedTo.Hex()
+	}
+	return d
+}
+
+var tokenSortFields = map[string]string{"created": "createdAt", "lastUsed": "lastUsedAt"}
+
+// tokenListFilter builds the match stage from the `active`, `expired` and
+// `scope` query parameters.
+func tokenListFilter(r *http.Request, userId string, now time.Time) (bson.M, error) {
+	q := r.URL.Query()
+	filter := bson.M{"userId": userId}
+	if v := q.Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errInvalidListQuery
+		}
+		filter["active"] = b
+	}
+	if v := q.Get("expired"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errInvalidListQuery
+		}
+		if b {
+			filter["expiresAt"] = bson.M{"$lte": now}
+		} else {
+			filter["$or"] = bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}}
+		}
+	}
+	if v := q.Get("scope"); v != "" {
+		filter["scopes"] = v
+	}
+	return filter, nil
 }
 
 func tokenListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, client *mongo.Client) {
 	vars := mux.Vars(r)
 	userId := vars["userId"]
+	now := time.Now()
+	lq, err := parseListQuery(r, tokenSortFields, "created")
+	if err != nil {
+		writeInvalidListQuery(w)
+		return
+	}
+	filter, err := tokenListFilter(r, userId, now)
+	if err != nil {
+		writeInvalidListQuery(w)
+		return
+	}
 	coll := client.Database("sharelatex").Collection("personalaccesstokens")
-	filter := bson.M{"userId": userId}
-	cur, err := coll.Find(ctx, filter)
+	cur, err := coll.Aggregate(ctx, lq.pipeline(filter))
 	if err != nil {
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 	defer cur.Close(ctx)
-	out := []bson.M{}
+	out := []tokenDTO{}
+	var last tokenRecord
+	more := false
 	for cur.Next(ctx) {
-		var d bson.M
-		if err := cur.Decode(&d); err != nil { continue }
-		// mask hash
-		if d["hash"] != nil { d["hash"] = nil }
-		out = append(out, d)
+		var t tokenRecord
+		if err := cur.Decode(&t); err != nil { continue }
+		if int64(len(out)) == lq.Limit {
+			more = true
+			break
+		}
+		out = append(out, t.dto(now))
+		last = t
+	}
+	if more {
+		w.Header().Set(nextCursorHeader, encodeListCursor(last.SortKey, last.ID))
 	}
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(out)
 }
 
@@ -306,15 +674,29 @@ func tokenRevokeHandler(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	userId := vars["userId"]
 	tokenId := vars["tokenId"]
-	coll := client.Database("sharelatex").Collection("personalaccesstokens")
 	id, err := primitive.ObjectIDFromHex(tokenId)
 	if err != nil {
 		http.Error(w, "invalid token id", http.StatusBadRequest)
 		return
 	}
-	res := coll.FindOneAndUpdate(ctx, bson.M{"_id": id, "userId": userId}, bson.M{"$set": bson.M{"active": false}})
-	if res.Err() != nil {
-		if res.Err() == mongo.ErrNoDocuments {
+	db := client.Database("sharelatex")
+	coll := db.Collection("personalaccesstokens")
+	// revoke and enqueue the cache invalidation atomically (outbox)
+	err = withOptionalTransaction(ctx, client, func(tx context.Context) error {
+		var prev struct {
+			Active     bool   `bson:"active"`
+			HashPrefix string `bson:"hashPrefix"`
+		}
+		if err := coll.FindOneAndUpdate(tx, bson.M{"_id": id, "userId": userId}, bson.M{"$set": bson.M{"active": false}}).Decode(&prev); err != nil {
+			return err
+		}
+		if !prev.Active {
+			return nil
+		}
+		return enqueueInvalidation(tx, db, newTokenInvalidation(id.Hex(), prev.HashPrefix, "revoked"))
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
@@ -324,12 +706,35 @@ func tokenRevokeHandler(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
+var sshKeySortFields = map[string]string{"created": "createdAt", "lastUsed": "lastUsedAt"}
+
+// sshKeyListFilter builds the match stage; `type` restricts to one or more
+// comma-separated key types (e.g. ssh-ed25519,ecdsa-sha2-nistp256).
+func sshKeyListFilter(r *http.Request, userId string) bson.M {
+	filter := bson.M{"userId": userId}
+	if v := r.URL.Query().Get("type"); v != "" {
+		var types []string
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, regexp.QuoteMeta(t))
+			}
+		}
+		filter["publicKey"] = primitive.Regex{Pattern: "^(" + strings.Join(types, "|") + ") "}
+	}
+	return filter
+}
+
 func listHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
 	vars := mux.Vars(r)
 	userId := vars["userId"]
-	filter := bson.M{"userId": userId}
+	lq, err := parseListQuery(r, sshKeySortFields, "created")
+	if err != nil {
+		writeInvalidListQuery(w)
+		return
+	}
+	filter := sshKeyListFilter(r, userId)
 
-	cur, err := coll.Find(ctx, filter)
+	cur, err := coll.Aggregate(ctx, lq.pipeline(filter))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("find error: %v", err), http.StatusInternalServerError)
 		return
@@ -337,24 +742,99 @@ func listHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, co
 	defer cur.Close(ctx)
 
 	out := []SSHKey{}
+	var lastKey time.Time
+	var lastID primitive.ObjectID
+	more := false
 	for cur.Next(ctx) {
-		var s SSHKey
+		var s struct {
+			SSHKey  `bson:",inline"`
+			SortKey time.Time `bson:"_sortKey"`
+		}
 		if err := cur.Decode(&s); err != nil {
 			log.Printf("decode err: %v", err)
 			continue
 		}
-		out = append(out, s)
+		if int64(len(out)) == lq.Limit {
+			more = true
+			break
+		}
+		out = append(out, s.SSHKey)
+		lastKey = s.SortKey
+		lastID, _ = s.ID.(primitive.ObjectID)
+	}
+	if more && !lastID.IsZero() {
+		w.Header().Set(nextCursorHeader, encodeListCursor(lastKey, lastID))
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(out)
 }
 
+var (
+	errInvalidPublicKey  = errors.New("invalid public key")
+	errKeyOwnedElsewhere = errors.New("key already registered to another user")
+	// Accept typical public key prefixes: ssh-*, ecdsa-*
+	publicKeyRe = regexp.MustCompile(`^(ssh-[a-z0-9-]+|ecdsa-[^\s]+|ssh-ed25519) `)
+)
+
+// validatePublicKey rejects private-key submissions and malformed public keys.
+func validatePublicKey(publicKey string) error {
+	if strings.Contains(publicKey, "PRIVATE KEY") || strings.HasPrefix(strings.TrimSpace(publicKey), "-----BEGIN") {
+		return errInvalidPublicKey
+	}
+	if !publicKeyRe.MatchString(publicKey) {
+		return errInvalidPublicKey
+	}
+	return nil
+}
+
+// upsertSSHKey stores a validated key for userId, idempotent by fingerprint.
+// created reports whether this call inserted the key, taken from the upsert
+// result rather than the document's age. A fingerprint already registered to
+// another user hits the unique index and returns errKeyOwnedElsewhere without
+// touching or returning that user's key; callers answer 409.
+func upsertSSHKey(ctx context.Context, coll *mongo.Collection, userId, keyName, publicKey string, expiresAt time.Time) (SSHKey, bool, error) {
+	var doc SSHKey
+	fingerprint := computeFingerprint(publicKey)
+	now := time.Now()
+	filter := bson.M{"fingerprint": fingerprint, "userId": userId}
+	// Re-adding a key the user already has renews it: the requested expiry
+	// replaces the stored one and an expired or auto-disabled key is re-enabled.
+	set := bson.M{"updatedAt": now}
+	unset := bson.M{"disabledAt": true, "disabledReason": true, "expiryWarnedAt": true}
+	if expiresAt.IsZero() {
+		unset["expiresAt"] = true
+	} else {
+		set["expiresAt"] = expiresAt
+	}
+	insert := bson.M{"keyName": keyName, "publicKey": publicKey, "createdAt": now}
+	update := bson.M{"$setOnInsert": insert, "$set": set, "$unset": unset}
+
+	res, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// either another user owns the fingerprint, or a concurrent upsert
+		// for this user won the race; only the latter is returned
+		if findErr := coll.FindOne(ctx, filter).Decode(&doc); findErr == nil {
+			return doc, false, nil
+		}
+		return doc, false, errKeyOwnedElsewhere
+	}
+	if err != nil {
+		return doc, false, err
+	}
+	if err := coll.FindOne(ctx, filter).Decode(&doc); err != nil {
+		log.Printf("upsert ssh key %s for %s: fetch after upsert: %v", fingerprint, userId, err)
+		return doc, false, err
+	}
+	return doc, res.UpsertedCount == 1, nil
+}
+
 func createHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
 	vars := mux.Vars(r)
 	userId := vars["userId"]
 	var req struct {
-		PublicKey string `json:"public_key"`
-		KeyName   string `json:"key_name"`
+		PublicKey string     `json:"public_key"`
+		KeyName   string     `json:"key_name"`
+		ExpiresAt *time.Time `json:"expires_at"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid body", http.StatusBadRequest)
@@ -364,57 +844,43 @@ func createHandler(ctx context.Context, w http.ResponseWriter, r *http.Request,
 		http.Error(w, "public_key required", http.StatusBadRequest)
 		return
 	}
-	// Reject private-key submissions and malformed public keys
-	if strings.Contains(req.PublicKey, "PRIVATE KEY") || strings.HasPrefix(strings.TrimSpace(req.PublicKey), "-----BEGIN") {
+	if err := validatePublicKey(req.PublicKey); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"message": "invalid public key"})
-		return
-	}
-	// Accept typical public key prefixes: ssh-*, ecdsa-*
-	matched, _ := regexp.MatchString(`^(ssh-[a-z0-9-]+|ecdsa-[^\s]+|ssh-ed25519) `, req.PublicKey)
-	if !matched {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"message": "invalid public key"})
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
 		return
 	}
 
-	fingerprint := computeFingerprint(req.PublicKey)
-	now := time.Now()
-	filter := bson.M{"fingerprint": fingerprint}
-	update := bson.M{"$setOnInsert": bson.M{"userId": userId, "keyName": req.KeyName, "publicKey": req.PublicKey, "createdAt": now}, "$set": bson.M{"updatedAt": now}}
-	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
-
-	res := coll.FindOneAndUpdate(ctx, filter, update, opts)
-	var doc SSHKey
-	if err := res.Decode(&doc); err != nil {
-		// If decode fails, attempt a fetch (fallback)
-		// This mirrors the Node fallback behaviour for driver visibility quirk
-		fetch := coll.FindOne(ctx, filter)
-		if fetchErr := fetch.Decode(&doc); fetchErr != nil {
-			log.Printf("upsert no doc and fetch failed: upsertErr=%v fetchErr=%v", err, fetchErr)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"message": "expires_at must be in the future"})
 			return
 		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	doc, created, err := upsertSSHKey(ctx, coll, userId, req.KeyName, req.PublicKey, expiresAt)
+	if err == errKeyOwnedElsewhere {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
-	createdNow := (time.Since(doc.CreatedAt) < 5*time.Second)
-	if createdNow {
+	w.Header().Set("Content-Type", "application/json")
+	if created {
 		w.WriteHeader(http.StatusCreated)
 	} else {
 		w.WriteHeader(http.StatusOK)
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":         doc.ID,
-		"key_name":   doc.KeyName,
-		"label":      doc.Label,
-		"public_key": doc.PublicKey,
-		"fingerprint": doc.Fingerprint,
-		"created_at": doc.CreatedAt,
-		"updated_at": doc.UpdatedAt,
-		"userId":     doc.UserID,
-	})
+	json.NewEncoder(w).Encode(doc)
 }
 
 func deleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
@@ -427,13 +893,20 @@ func deleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	if oid, err := primitive.ObjectIDFromHex(keyId); err == nil {
 		filter = bson.M{"_id": oid, "userId": userId}
 	}
-	res := coll.FindOneAndDelete(ctx, filter)
-	if res.Err() != nil {
-		if res.Err() == mongo.ErrNoDocuments {
+	// delete and enqueue the cache invalidation atomically (outbox)
+	err := withOptionalTransaction(ctx, coll.Database().Client(), func(tx context.Context) error {
+		var doc SSHKey
+		if err := coll.FindOneAndDelete(tx, filter).Decode(&doc); err != nil {
+			return err
+		}
+		return enqueueInvalidation(tx, coll.Database(), newSSHInvalidation(keyId, doc.Fingerprint, "deleted"))
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		log.Printf("delete error: %v", res.Err())
+		log.Printf("delete error: %v", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
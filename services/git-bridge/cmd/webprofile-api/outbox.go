@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cache invalidation events are written to an outbox collection together with
+// the change that causes them, then delivered by a background dispatcher that
+// retries with backoff. A broker outage therefore delays invalidations but
+// never loses them.
+const outboxCollName = "authcacheoutbox"
+
+// withOptionalTransaction runs fn in a transaction when the deployment
+// supports one (replica set / mongos). Standalone servers used in dev/CI
+// reject transactions, in which case fn runs without one.
+func withOptionalTransaction(ctx context.Context, client *mongo.Client, fn func(context.Context) error) error {
+	sess, err := client.StartSession()
+	if err != nil {
+		return fn(ctx)
+	}
+	defer sess.EndSession(ctx)
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 20 || cmdErr.Name == "IllegalOperation") {
+		return fn(ctx)
+	}
+	return err
+}
+
+// enqueueInvalidation appends ev to the outbox. Call it inside the same
+// withOptionalTransaction callback as the change it describes.
+func enqueueInvalidation(ctx context.Context, db *mongo.Database, ev cacheInvalidation) error {
+	now := time.Now()
+	_, err := db.Collection(outboxCollName).InsertOne(ctx, bson.M{
+		"event":         ev,
+		"createdAt":     now,
+		"nextAttemptAt": now,
+		"attempts":      0,
+	})
+	return err
+}
+
+// outboxBackoff returns the delay before retry number attempts (1-based).
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second << uint(attempts-1)
+	if attempts > 9 || d > 5*time.Minute {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// runOutboxDispatcher delivers pending events every OUTBOX_POLL_SECONDS
+// (default 2) until ctx is cancelled.
+func runOutboxDispatcher(ctx context.Context, db *mongo.Database, pub invalidationPublisher) {
+	t := time.NewTicker(time.Duration(envInt("OUTBOX_POLL_SECONDS", 2)) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			dispatchOutbox(ctx, db.Collection(outboxCollName), pub, time.Now())
+		}
+	}
+}
+
+// outboxLease is how long a dispatcher owns a claimed row. A row whose
+// dispatcher died mid-publish becomes claimable again once it lapses.
+const outboxLease = time.Minute
+
+// claimOutboxRow leases the oldest due, unleased row so that concurrent
+// webprofile-api instances never publish the same event at once. It returns
+// mongo.ErrNoDocuments when nothing is due.
+func claimOutboxRow(ctx context.Context, coll *mongo.Collection, now time.Time) (bson.Raw, error) {
+	filter := bson.M{
+		"sentAt":        bson.M{"$exists": false},
+		"failedAt":      bson.M{"$exists": false},
+		"nextAttemptAt": bson.M{"$lte": now},
+		"$or":           bson.A{bson.M{"leasedUntil": bson.M{"$exists": false}}, bson.M{"leasedUntil": bson.M{"$lte": now}}},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).SetReturnDocument(options.After)
+	return coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"leasedUntil": now.Add(outboxLease)}}, opts).Raw()
+}
+
+// dispatchOutbox claims and publishes up to 100 due events. Rows that cannot
+// be decoded are marked failedAt and never retried; publish failures release
+// the lease and back off.
+func dispatchOutbox(ctx context.Context, coll *mongo.Collection, pub invalidationPublisher, now time.Time) {
+	for i := 0; i < 100; i++ {
+		raw, err := claimOutboxRow(ctx, coll, now)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("outbox claim: %v", err)
+			return
+		}
+		id := raw.Lookup("_id")
+		var rec struct {
+			Event    cacheInvalidation `bson:"event"`
+			Attempts int               `bson:"attempts"`
+		}
+		if err := bson.Unmarshal(raw, &rec); err != nil {
+			log.Printf("outbox decode %v: %v; marking failed", id, err)
+			markOutboxRow(ctx, coll, id, bson.M{"$set": bson.M{"failedAt": time.Now(), "lastError": err.Error()}, "$unset": bson.M{"leasedUntil": true}})
+			continue
+		}
+		if err := pub.Publish(ctx, rec.Event); err != nil {
+			attempts := rec.Attempts + 1
+			log.Printf("outbox publish %v (attempt %d): %v", id, attempts, err)
+			markOutboxRow(ctx, coll, id, bson.M{
+				"$set": bson.M{
+					"attempts":      attempts,
+					"nextAttemptAt": now.Add(outboxBackoff(attempts)),
+					"lastError":     err.Error(),
+				},
+				"$unset": bson.M{"leasedUntil": true},
+			})
+			continue
+		}
+		markOutboxRow(ctx, coll, id, bson.M{"$set": bson.M{"sentAt": time.Now()}, "$unset": bson.M{"leasedUntil": true}})
+	}
+}
+
+func markOutboxRow(ctx context.Context, coll *mongo.Collection, id bson.RawValue, update bson.M) {
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		log.Printf("outbox update %v: %v", id, err)
+	}
+}
+
+// ensureOutboxIndexes indexes pending events and expires delivered ones
+// after a day.
+func ensureOutboxIndexes(ctx context.Context, coll *mongo.Collection) {
+	pending := mongo.IndexModel{Keys: bson.D{{Key: "sentAt", Value: 1}, {Key: "nextAttemptAt", Value: 1}}}
+	ttl := mongo.IndexModel{Keys: bson.D{{Key: "sentAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(86400).SetName("sentAt_ttl")}
+	if _, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{pending, ttl}); err != nil {
+		log.Printf("ensureOutboxIndexes: %v", err)
+	} else {
+		log.Printf("ensured outbox indexes")
+	}
+}
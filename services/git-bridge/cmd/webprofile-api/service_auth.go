@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/overleaf/git-bridge/internal/svcauth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// serviceAuth verifies HMAC-signed requests (see internal/svcauth), configured
+// by SERVICE_AUTH_KEYS and SERVICE_AUTH_MAX_SKEW_SECONDS. main swaps its
+// in-memory nonce store for mongoNonceStore so every instance sees the same
+// nonces.
+var serviceAuth = svcauth.NewVerifierFromEnv()
+
+const nonceCollName = "serviceauthnonces"
+
+// mongoNonceStore records accepted nonces in a collection shared by all
+// webprofile-api instances, so a request replayed to another instance is
+// rejected too. The nonce key is the _id, making the insert the atomic
+// check; a TTL index drops nonces once they can no longer pass the
+// timestamp check.
+type mongoNonceStore struct {
+	coll *mongo.Collection
+}
+
+func (s mongoNonceStore) Use(ctx context.Context, key string, now, expires time.Time) error {
+	_, err := s.coll.InsertOne(ctx, bson.M{"_id": key, "expiresAt": expires})
+	if mongo.IsDuplicateKeyError(err) {
+		return svcauth.ErrReplayedNonce
+	}
+	return err
+}
+
+func ensureNonceIndexes(ctx context.Context, coll *mongo.Collection) {
+	if err := ensureTTLIndex(ctx, coll, "expiresAt", 0); err != nil {
+		log.Printf("ensureNonceIndexes: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/overleaf/git-bridge/internal/svcauth"
+)
+
+func TestRequireAuthVerifiesSignedRequests(t *testing.T) {
+	orig := serviceAuth
+	defer func() { serviceAuth = orig }()
+	serviceAuth = svcauth.NewVerifier("web:s3cret", time.Minute)
+
+	body := `{"token":"abc"}`
+	req := httptest.NewRequest("POST", "/internal/api/tokens/introspect", bytes.NewBufferString(body))
+	if err := svcauth.SignRequest(req, "web", []byte("s3cret"), []byte(body)); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	if !requireAuth(httptest.NewRecorder(), req) {
+		t.Fatalf("expected a signed request to authenticate")
+	}
+
+	// a signed request that fails verification is a 401, not the login redirect
+	bad := httptest.NewRequest("POST", "/internal/api/tokens/introspect", bytes.NewBufferString(body))
+	if err := svcauth.SignRequest(bad, "web", []byte("wrong"), []byte(body)); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	if requireAuth(rr, bad) || rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad signature, got %d", rr.Code)
+	}
+}
+
+func TestRequireAuthRejectsServiceOriginBypass(t *testing.T) {
+	req := httptest.NewRequest("GET", "/internal/api/users/u1/ssh-keys", nil)
+	req.Header.Set("X-Service-Origin", "anything")
+	rr := httptest.NewRecorder()
+	if requireAuth(rr, req) {
+		t.Fatalf("X-Service-Origin alone must not authenticate")
+	}
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected 302 redirect, got %d", rr.Code)
+	}
+
+	// no default Basic credentials
+	req2 := httptest.NewRequest("GET", "/internal/api/users/u1/ssh-keys", nil)
+	req2.SetBasicAuth("overleaf", "overleaf")
+	if requireAuth(httptest.NewRecorder(), req2) {
+		t.Fatalf("default basic credentials must not authenticate")
+	}
+}
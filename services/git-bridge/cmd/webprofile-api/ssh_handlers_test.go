@@ -3,16 +3,23 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestCreateMalformedPublicKey(t *testing.T) {
@@ -112,3 +119,81 @@ func TestCreateAndDeleteIntegration(t *testing.T) {
 	// cleanup
 	coll.DeleteMany(ctx, bson.M{"userId": "int-ssh-user"})
 }
+
+func TestReAddExpiredKeyRenewsItIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	coll := client.Database("sharelatex").Collection("usersshkeys")
+	ensureIndex(ctx, coll)
+	user := bson.M{"userId": "int-ssh-readd"}
+	coll.DeleteMany(ctx, user)
+	defer coll.DeleteMany(ctx, user)
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sshPub, _ := ssh.NewPublicKey(pub)
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	fp := computeFingerprint(line)
+	past := time.Now().Add(-time.Hour)
+	if _, err := coll.InsertOne(ctx, bson.M{"userId": "int-ssh-readd", "keyName": "old", "publicKey": line, "fingerprint": fp,
+		"expiresAt": past, "disabledAt": past, "disabledReason": "unused", "expiryWarnedAt": past}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/internal/api/users/int-ssh-readd/ssh-keys", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"userId": "int-ssh-readd"})
+		rr := httptest.NewRecorder()
+		createHandler(ctx, rr, req, coll)
+		return rr
+	}
+	lookup := func() int {
+		req := httptest.NewRequest("GET", "/internal/api/ssh-keys/"+fp, nil)
+		req = mux.SetURLVars(req, map[string]string{"fingerprint": fp})
+		rr := httptest.NewRecorder()
+		sshKeyLookupHandler(ctx, rr, req, coll)
+		return rr.Code
+	}
+	if code := lookup(); code != http.StatusNotFound {
+		t.Fatalf("expired key lookup: expected 404, got %d", code)
+	}
+
+	future := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	body, _ := json.Marshal(map[string]interface{}{"public_key": line, "key_name": "again", "expires_at": future})
+	if rr := create(string(body)); rr.Code != http.StatusOK {
+		t.Fatalf("re-add: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var doc bson.M
+	if err := coll.FindOne(ctx, bson.M{"fingerprint": fp}).Decode(&doc); err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	for _, f := range []string{"disabledAt", "disabledReason", "expiryWarnedAt"} {
+		if _, ok := doc[f]; ok {
+			t.Fatalf("re-add left %s set: %v", f, doc)
+		}
+	}
+	if got := doc["expiresAt"].(primitive.DateTime).Time(); !got.Equal(future) {
+		t.Fatalf("expiresAt = %v, want %v", got, future)
+	}
+	if code := lookup(); code != http.StatusOK {
+		t.Fatalf("renewed key lookup: expected 200, got %d", code)
+	}
+
+	// re-adding without an expiry clears it
+	body, _ = json.Marshal(map[string]interface{}{"public_key": line, "key_name": "again"})
+	if rr := create(string(body)); rr.Code != http.StatusOK {
+		t.Fatalf("second re-add: expected 200, got %d", rr.Code)
+	}
+	doc = bson.M{}
+	coll.FindOne(ctx, bson.M{"fingerprint": fp}).Decode(&doc)
+	if _, ok := doc["expiresAt"]; ok {
+		t.Fatalf("re-add without expires_at kept expiresAt: %v", doc)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Completed one-off migrations are recorded in migrationsCollName by ID so
+// that later starts skip them.
+const (
+	migrationsCollName           = "webprofilemigrations"
+	fingerprintBackfillMigration = "ssh-key-fingerprint-v2"
+)
+
+// legacyFingerprint is the fingerprint earlier webprofile-api versions stored:
+// sha256 over the whole key line instead of the decoded blob.
+func legacyFingerprint(publicKey string) string {
+	h := sha256.Sum256([]byte(publicKey))
+	return "SHA256:" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// fingerprintFilter matches a key by its canonical fingerprint or, for keys
+// migrated by backfillSSHKeyFingerprints, the legacy one they were stored under.
+func fingerprintFilter(fp string) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"fingerprint": fp}, bson.M{"legacyFingerprint": fp}}}
+}
+
+// runSSHKeyFingerprintBackfill runs backfillSSHKeyFingerprints once per
+// deployment and records its completion in migrationsCollName. A failed run
+// is retried on the next start.
+func runSSHKeyFingerprintBackfill(ctx context.Context, db *mongo.Database, coll *mongo.Collection) {
+	migrations := db.Collection(migrationsCollName)
+	err := migrations.FindOne(ctx, bson.M{"_id": fingerprintBackfillMigration}).Err()
+	if err == nil {
+		return
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Printf("ssh key fingerprint backfill: %v", err)
+		return
+	}
+	n, err := backfillSSHKeyFingerprints(ctx, coll)
+	if err != nil {
+		log.Printf("ssh key fingerprint backfill: migrated %d keys before %v", n, err)
+		return
+	}
+	log.Printf("ssh key fingerprint backfill: migrated %d keys", n)
+	marker := bson.M{"$setOnInsert": bson.M{"completedAt": time.Now(), "migrated": n}}
+	if _, err := migrations.UpdateOne(ctx, bson.M{"_id": fingerprintBackfillMigration}, marker, options.Update().SetUpsert(true)); err != nil {
+		log.Printf("ssh key fingerprint backfill: record completion: %v", err)
+	}
+}
+
+// backfillSSHKeyFingerprints rewrites keys stored under their legacy
+// fingerprint to the canonical one and keeps the old value in
+// legacyFingerprint, so lookups by either form keep working. Keys whose
+// canonical fingerprint is already taken (the same key was added again after
+// the format change) are left alone and logged. It is idempotent.
+func backfillSSHKeyFingerprints(ctx context.Context, coll *mongo.Collection) (migrated int, err error) {
+	filter := bson.M{"publicKey": bson.M{"$exists": true}, "legacyFingerprint": bson.M{"$exists": false}}
+	opts := options.Find().SetProjection(bson.M{"publicKey": 1, "fingerprint": 1})
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var k SSHKey
+		if err := cur.Decode(&k); err != nil {
+			return migrated, err
+		}
+		want := computeFingerprint(k.PublicKey)
+		if k.Fingerprint == want || k.Fingerprint != legacyFingerprint(k.PublicKey) {
+			continue
+		}
+		update := bson.M{"$set": bson.M{"fingerprint": want, "legacyFingerprint": k.Fingerprint}}
+		_, err := coll.UpdateOne(ctx, bson.M{"_id": k.ID, "fingerprint": k.Fingerprint}, update)
+		if mongo.IsDuplicateKeyError(err) {
+			log.Printf("ssh key fingerprint backfill: %v: %s already registered; leaving legacy fingerprint", k.ID, want)
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cur.Err()
+}
+
+func ensureLegacyFingerprintIndex(ctx context.Context, coll *mongo.Collection) {
+	idx := mongo.IndexModel{Keys: bson.D{{Key: "legacyFingerprint", Value: 1}}, Options: options.Index().SetSparse(true)}
+	if _, err := coll.Indexes().CreateOne(ctx, idx); err != nil {
+		log.Printf("ensureLegacyFingerprintIndex: %v", err)
+	}
+}
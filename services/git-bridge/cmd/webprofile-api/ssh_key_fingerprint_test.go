@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const fingerprintTestKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGq1mB0L3LtW0cPjzqR8uGa0lq0m4A3b5o0k1i0Uu9Xy legacy@test"
+
+func TestLegacyFingerprintDiffersFromCanonical(t *testing.T) {
+	legacy, canonical := legacyFingerprint(fingerprintTestKey), computeFingerprint(fingerprintTestKey)
+	if legacy == canonical {
+		t.Fatalf("legacy and canonical fingerprints should differ, both %s", legacy)
+	}
+	if !fingerprintRe.MatchString(legacy) {
+		t.Fatalf("legacy fingerprint %s rejected by fingerprintRe", legacy)
+	}
+}
+
+func TestBackfillSSHKeyFingerprintsIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	coll := client.Database("sharelatex").Collection("usersshkeys")
+	ensureIndex(ctx, coll)
+	user := bson.M{"userId": "int-backfill"}
+	coll.DeleteMany(ctx, user)
+	defer coll.DeleteMany(ctx, user)
+
+	legacy, canonical := legacyFingerprint(fingerprintTestKey), computeFingerprint(fingerprintTestKey)
+	if _, err := coll.InsertOne(ctx, bson.M{"userId": "int-backfill", "publicKey": fingerprintTestKey, "fingerprint": legacy}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if n, err := backfillSSHKeyFingerprints(ctx, coll); err != nil || n != 1 {
+		t.Fatalf("backfill migrated %d, err %v; want 1", n, err)
+	}
+	if n, err := backfillSSHKeyFingerprints(ctx, coll); err != nil || n != 0 {
+		t.Fatalf("second backfill migrated %d, err %v; want 0", n, err)
+	}
+
+	for _, fp := range []string{canonical, legacy} {
+		req := httptest.NewRequest("GET", "/internal/api/ssh-keys/"+fp, nil)
+		req = mux.SetURLVars(req, map[string]string{"fingerprint": fp})
+		rr := httptest.NewRecorder()
+		sshKeyLookupHandler(ctx, rr, req, coll)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("lookup by %s: expected 200, got %d", fp, rr.Code)
+		}
+	}
+}
+
+func TestSSHKeyFingerprintBackfillRunsOnceIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	db := client.Database("sharelatex_backfill_test")
+	defer db.Drop(ctx)
+	coll := db.Collection("usersshkeys")
+	ensureIndex(ctx, coll)
+
+	legacy := legacyFingerprint(fingerprintTestKey)
+	coll.InsertOne(ctx, bson.M{"userId": "u1", "publicKey": fingerprintTestKey, "fingerprint": legacy})
+	runSSHKeyFingerprintBackfill(ctx, db, coll)
+	if n, _ := coll.CountDocuments(ctx, bson.M{"legacyFingerprint": legacy}); n != 1 {
+		t.Fatalf("first run did not migrate the legacy key")
+	}
+	if n, _ := db.Collection(migrationsCollName).CountDocuments(ctx, bson.M{"_id": fingerprintBackfillMigration}); n != 1 {
+		t.Fatalf("first run did not record its completion")
+	}
+
+	// once recorded, later starts do not scan the collection again
+	coll.DeleteMany(ctx, bson.M{})
+	coll.InsertOne(ctx, bson.M{"userId": "u2", "publicKey": fingerprintTestKey, "fingerprint": legacy})
+	runSSHKeyFingerprintBackfill(ctx, db, coll)
+	if n, _ := coll.CountDocuments(ctx, bson.M{"fingerprint": legacy}); n != 1 {
+		t.Fatalf("backfill ran again after completion was recorded")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Bulk import accepts a pasted authorized_keys file. Each key line gets its
+// own result so one bad line does not fail the whole import.
+const (
+	maxImportBodyBytes = 1 << 20
+	maxImportKeys      = 100
+)
+
+// Per-line import outcomes.
+const (
+	importCreated  = "created"
+	importExists   = "exists"
+	importInvalid  = "invalid"
+	importConflict = "conflict"
+	importError    = "error"
+)
+
+type importResult struct {
+	Line        int         `json:"line"`
+	Status      string      `json:"status"`
+	ID          interface{} `json:"id,omitempty"`
+	Fingerprint string      `json:"fingerprint,omitempty"`
+	Message     string      `json:"message,omitempty"`
+}
+
+// authorizedKeyLine is one non-blank, non-comment line of an authorized_keys file.
+type authorizedKeyLine struct {
+	Line      int
+	PublicKey string
+	Comment   string
+}
+
+// parseAuthorizedKeys splits an authorized_keys file into key lines. Leading
+// options (e.g. `command="...",no-pty`) are dropped: they restrict sshd, not
+// git-bridge, and are not stored.
+func parseAuthorizedKeys(text string) []authorizedKeyLine {
+	var out []authorizedKeyLine
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(make([]byte, 64*1024), maxImportBodyBytes)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !publicKeyRe.MatchString(line) {
+			line = stripAuthorizedKeyOptions(line)
+		}
+		fields := strings.Fields(line)
+		entry := authorizedKeyLine{Line: n, PublicKey: line}
+		if len(fields) > 2 {
+			entry.Comment = strings.Join(fields[2:], " ")
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// stripAuthorizedKeyOptions removes the options field, which ends at the
+// first space outside double quotes.
+func stripAuthorizedKeyOptions(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ' ', '\t':
+			if !quoted {
+				return strings.TrimSpace(line[i:])
+			}
+		}
+	}
+	return line
+}
+
+// importKeysHandler stores every valid key from an authorized_keys file and
+// reports a result per key line. The key comment becomes the key name.
+func importKeysHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
+	userId := mux.Vars(r)["userId"]
+	var req struct {
+		AuthorizedKeys string `json:"authorized_keys"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	lines := parseAuthorizedKeys(req.AuthorizedKeys)
+	if len(lines) > maxImportKeys {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("too many keys (max %d)", maxImportKeys)})
+		return
+	}
+
+	counts := map[string]int{}
+	results := []importResult{}
+	for _, l := range lines {
+		res := importResult{Line: l.Line}
+		if err := validatePublicKey(l.PublicKey); err != nil {
+			res.Status, res.Message = importInvalid, err.Error()
+		} else if doc, created, err := upsertSSHKey(ctx, coll, userId, l.Comment, l.PublicKey, time.Time{}); err == errKeyOwnedElsewhere {
+			res.Status, res.Message = importConflict, err.Error()
+		} else if err != nil {
+			log.Printf("import key line %d for %s: %v", l.Line, userId, err)
+			res.Status, res.Message = importError, "internal error"
+		} else {
+			res.Status = importExists
+			if created {
+				res.Status = importCreated
+			}
+			res.ID, res.Fingerprint = doc.ID, doc.Fingerprint
+		}
+		counts[res.Status]++
+		results = append(results, res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"created":  counts[importCreated],
+		"existing": counts[importExists],
+		"failed":   len(results) - counts[importCreated] - counts[importExists],
+		"results":  results,
+	})
+}
+
+// exportKeysHandler writes the user's active keys in authorized_keys format, oldest
+// first, like GitHub's /users/{name}.keys. `type` restricts the output to one
+// or more comma-separated key types.
+func exportKeysHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
+	userId := mux.Vars(r)["userId"]
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).SetProjection(bson.M{"publicKey": 1})
+	filter := activeSSHKeyFilter(time.Now())
+	for k, v := range sshKeyListFilter(r, userId) {
+		filter[k] = v
+	}
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close(ctx)
+	var b strings.Builder
+	for cur.Next(ctx) {
+		var k SSHKey
+		if err := cur.Decode(&k); err != nil || k.PublicKey == "" {
+			continue
+		}
+		b.WriteString(strings.TrimSpace(k.PublicKey))
+		b.WriteString("\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
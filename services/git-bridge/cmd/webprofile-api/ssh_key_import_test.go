@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	file := strings.Join([]string{
+		"# work laptop",
+		"",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIone alice@laptop",
+		`command="echo \"hi there\"",no-pty ssh-rsa AAAAB3NzaC1yc2EAAAADAQABtwo ci key`,
+		"ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYthree",
+		"not a key",
+	}, "\n")
+	got := parseAuthorizedKeys(file)
+	want := []authorizedKeyLine{
+		{Line: 3, PublicKey: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIone alice@laptop", Comment: "alice@laptop"},
+		{Line: 4, PublicKey: "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABtwo ci key", Comment: "ci key"},
+		{Line: 5, PublicKey: "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYthree"},
+		{Line: 6, PublicKey: "a key"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if err := validatePublicKey(got[3].PublicKey); err != errInvalidPublicKey {
+		t.Errorf("expected garbage line to be invalid, got %v", err)
+	}
+}
+
+func TestImportKeysRejectsTooManyLines(t *testing.T) {
+	// runs without MongoDB: the limit is checked before any key is stored
+	lines := make([]string, maxImportKeys+1)
+	for i := range lines {
+		lines[i] = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIkey"
+	}
+	b, _ := json.Marshal(map[string]string{"authorized_keys": strings.Join(lines, "\n")})
+	req := httptest.NewRequest("POST", "/internal/api/users/u1/ssh-keys/import", bytes.NewReader(b))
+	rr := httptest.NewRecorder()
+	importKeysHandler(context.Background(), rr, req, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestImportKeysCreatedOnceAndConflictIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	coll := client.Database("sharelatex").Collection("usersshkeys")
+	ensureIndex(ctx, coll)
+	users := bson.M{"userId": bson.M{"$in": []string{"int-import-a", "int-import-b"}}}
+	coll.DeleteMany(ctx, users)
+	defer coll.DeleteMany(ctx, users)
+
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIimportonce a@laptop"
+	importAs := func(userId string) map[string]interface{} {
+		b, _ := json.Marshal(map[string]string{"authorized_keys": key})
+		req := httptest.NewRequest("POST", "/internal/api/users/"+userId+"/ssh-keys/import", bytes.NewReader(b))
+		req = mux.SetURLVars(req, map[string]string{"userId": userId})
+		rr := httptest.NewRecorder()
+		importKeysHandler(ctx, rr, req, coll)
+		var out map[string]interface{}
+		json.NewDecoder(rr.Body).Decode(&out)
+		return out
+	}
+
+	// importing the same key twice in quick succession creates it once
+	if out := importAs("int-import-a"); out["created"] != float64(1) {
+		t.Fatalf("expected first import to create the key, got %v", out)
+	}
+	if out := importAs("int-import-a"); out["created"] != float64(0) || out["existing"] != float64(1) {
+		t.Fatalf("expected second import to report the key as existing, got %v", out)
+	}
+
+	// another user's import reports a conflict and never sees the owner's key
+	out := importAs("int-import-b")
+	results, _ := out["results"].([]interface{})
+	if len(results) != 1 {
+		t.Fatalf("expected one result, got %v", out)
+	}
+	r := results[0].(map[string]interface{})
+	if r["status"] != importConflict || r["id"] != nil {
+		t.Fatalf("expected a conflict without the owner's id, got %v", r)
+	}
+
+	// the single-key endpoint answers 409 for the same case
+	b, _ := json.Marshal(map[string]string{"public_key": key, "key_name": "b"})
+	req := httptest.NewRequest("POST", "/internal/api/users/int-import-b/ssh-keys", bytes.NewReader(b))
+	req = mux.SetURLVars(req, map[string]string{"userId": "int-import-b"})
+	rr := httptest.NewRecorder()
+	createHandler(ctx, rr, req, coll)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a key owned by another user, got %d", rr.Code)
+	}
+}
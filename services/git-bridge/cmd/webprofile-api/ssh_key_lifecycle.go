@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fingerprintRe matches the canonical SHA256:<base64> form (32-byte digest).
+var fingerprintRe = regexp.MustCompile(`^SHA256:[A-Za-z0-9+/]{43}=$`)
+
+// activeSSHKeyFilter matches keys that may authenticate at now: not disabled
+// and either without expiry or expiring later.
+func activeSSHKeyFilter(now time.Time) bson.M {
+	return bson.M{
+		"disabledAt": bson.M{"$exists": false},
+		"$or":        bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}},
+	}
+}
+
+// sshKeyLookupHandler resolves a fingerprint to its owner for git-bridge.
+// Keys migrated from the legacy fingerprint also match on their old value.
+// Expired and disabled keys are reported as not found. expiresAt is included
+// so callers can stop caching the result once the key expires.
+func sshKeyLookupHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
+	fp := mux.Vars(r)["fingerprint"]
+	if !fingerprintRe.MatchString(fp) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"message": "invalid fingerprint format"})
+		return
+	}
+	filter := bson.M{"$and": bson.A{activeSSHKeyFilter(time.Now()), fingerprintFilter(fp)}}
+	var k SSHKey
+	if err := coll.FindOne(ctx, filter).Decode(&k); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	out := map[string]interface{}{"userId": userIDString(k.UserID)}
+	if k.ExpiresAt != nil {
+		out["expiresAt"] = k.ExpiresAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// userIDString renders a stored userId, which is a string for keys created
+// here and an ObjectId for keys created by the Node web service.
+func userIDString(v interface{}) string {
+	if oid, ok := v.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// sshKeyUsageHandler records a successful SSH authentication reported by
+// git-bridge. $max keeps lastUsedAt monotonic when reports arrive out of order.
+func sshKeyUsageHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, coll *mongo.Collection) {
+	var req struct {
+		Fingerprint string `json:"fingerprint"`
+		SourceIP    string `json:"sourceIp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !fingerprintRe.MatchString(req.Fingerprint) {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	update := bson.M{"$max": bson.M{"lastUsedAt": time.Now()}}
+	if req.SourceIP != "" {
+		update["$set"] = bson.M{"lastUsedIP": req.SourceIP}
+	}
+	res, err := coll.UpdateOne(ctx, fingerprintFilter(req.Fingerprint), update)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if res.MatchedCount == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sshKeyLifecyclePolicy configures the key sweeper. A zero UnusedDisable
+// turns off auto-disabling; a zero ExpiryWarning turns off expiry notices.
+type sshKeyLifecyclePolicy struct {
+	ExpiryWarning time.Duration
+	UnusedDisable time.Duration
+}
+
+// sshKeyPolicy is replaced by main with the configured policy.
+var sshKeyPolicy = sshKeyLifecyclePolicy{ExpiryWarning: 7 * 24 * time.Hour}
+
+// sshKeyPolicyFromEnv reads the policy from environment variables:
+//   - SSH_KEY_EXPIRY_WARNING_DAYS (default 7)
+//   - SSH_KEY_UNUSED_DISABLE_DAYS (default 0, disabled)
+func sshKeyPolicyFromEnv() (sshKeyLifecyclePolicy, error) {
+	var p sshKeyLifecyclePolicy
+	var err error
+	if p.ExpiryWarning, err = envDays("SSH_KEY_EXPIRY_WARNING_DAYS", 7); err != nil {
+		return p, err
+	}
+	if p.UnusedDisable, err = envDays("SSH_KEY_UNUSED_DISABLE_DAYS", 0); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// runSSHKeySweeper warns owners of expiring keys and disables unused keys
+// every SSH_KEY_SWEEP_SECONDS (default 3600).
+func runSSHKeySweeper(ctx context.Context, coll *mongo.Collection) {
+	t := time.NewTicker(time.Duration(envInt("SSH_KEY_SWEEP_SECONDS", 3600)) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			warnExpiringSSHKeys(ctx, coll, time.Now())
+			disableUnusedSSHKeys(ctx, coll, time.Now())
+		}
+	}
+}
+
+type sweptSSHKey struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	UserID      interface{}        `bson:"userId"`
+	KeyName     string             `bson:"keyName"`
+	Fingerprint string             `bson:"fingerprint"`
+	ExpiresAt   *time.Time         `bson:"expiresAt"`
+}
+
+// warnExpiringSSHKeys notifies owners once per key when it enters the warning
+// window. expiryWarnedAt is only set after the notification was accepted, so
+// a failed delivery is retried on the next sweep.
+func warnExpiringSSHKeys(ctx context.Context, coll *mongo.Collection, now time.Time) {
+	if sshKeyPolicy.ExpiryWarning == 0 {
+		return
+	}
+	filter := bson.M{
+		"disabledAt":     bson.M{"$exists": false},
+		"expiryWarnedAt": bson.M{"$exists": false},
+		"expiresAt":      bson.M{"$gt": now, "$lte": now.Add(sshKeyPolicy.ExpiryWarning)},
+	}
+	cur, err := coll.Find(ctx, filter)
+	if err != nil {
+		log.Printf("ssh key sweep: %v", err)
+		return
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var k sweptSSHKey
+		if err := cur.Decode(&k); err != nil {
+			continue
+		}
+		n := userNotification{
+			Key:         "ssh-key-expiring-" + k.ID.Hex(),
+			TemplateKey: "notification_ssh_key_expiring",
+			MessageOpts: map[string]interface{}{"keyName": k.KeyName, "fingerprint": k.Fingerprint, "expiresAt": k.ExpiresAt},
+			Expires:     k.ExpiresAt,
+		}
+		if err := notifications.Notify(ctx, userIDString(k.UserID), n); err != nil {
+			log.Printf("ssh key expiry notice %s: %v", k.ID.Hex(), err)
+			continue
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": k.ID}, bson.M{"$set": bson.M{"expiryWarnedAt": now}}); err != nil {
+			log.Printf("ssh key expiry notice %s: %v", k.ID.Hex(), err)
+		}
+	}
+}
+
+// disableUnusedSSHKeys disables keys not used (or, if never used, created)
+// within the configured period, invalidates bridge caches through the outbox
+// and notifies the owner.
+func disableUnusedSSHKeys(ctx context.Context, coll *mongo.Collection, now time.Time) {
+	if sshKeyPolicy.UnusedDisable == 0 {
+		return
+	}
+	cutoff := now.Add(-sshKeyPolicy.UnusedDisable)
+	filter := bson.M{
+		"disabledAt": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"lastUsedAt": bson.M{"$lt": cutoff}},
+			bson.M{"lastUsedAt": bson.M{"$exists": false}, "createdAt": bson.M{"$lt": cutoff}},
+		},
+	}
+	cur, err := coll.Find(ctx, filter)
+	if err != nil {
+		log.Printf("ssh key sweep: %v", err)
+		return
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var k sweptSSHKey
+		if err := cur.Decode(&k); err != nil {
+			continue
+		}
+		disabled := false
+		err := withOptionalTransaction(ctx, coll.Database().Client(), func(tx context.Context) error {
+			res, err := coll.UpdateOne(tx, bson.M{"_id": k.ID, "disabledAt": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"disabledAt": now, "disabledReason": "unused"}})
+			if err != nil || res.ModifiedCount == 0 {
+				return err
+			}
+			disabled = true
+			return enqueueInvalidation(tx, coll.Database(), newSSHInvalidation(k.ID.Hex(), k.Fingerprint, "disabled"))
+		})
+		if err != nil {
+			log.Printf("ssh key disable %s: %v", k.ID.Hex(), err)
+			continue
+		}
+		if !disabled {
+			continue
+		}
+		log.Printf("disabled unused ssh key %s for user %s", k.ID.Hex(), userIDString(k.UserID))
+		n := userNotification{
+			Key:         "ssh-key-disabled-" + k.ID.Hex(),
+			TemplateKey: "notification_ssh_key_disabled",
+			MessageOpts: map[string]interface{}{"keyName": k.KeyName, "fingerprint": k.Fingerprint, "unusedDays": int(sshKeyPolicy.UnusedDisable.Hours() / 24)},
+		}
+		if err := notifications.Notify(ctx, userIDString(k.UserID), n); err != nil {
+			log.Printf("ssh key disable notice %s: %v", k.ID.Hex(), err)
+		}
+	}
+}
+
+// userNotification is the body accepted by the notifications service's
+// POST /user/:user_id endpoint.
+type userNotification struct {
+	Key         string                 `json:"key"`
+	TemplateKey string                 `json:"templateKey"`
+	MessageOpts map[string]interface{} `json:"messageOpts"`
+	Expires     *time.Time             `json:"expires,omitempty"`
+}
+
+// notificationsClient posts user notifications. With no base URL configured
+// notifications are only logged.
+type notificationsClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+// notifications reads NOTIFICATIONS_URL (e.g. http://notifications:3042).
+var notifications = &notificationsClient{client: &http.Client{Timeout: 5 * time.Second}, baseURL: getEnv("NOTIFICATIONS_URL", "")}
+
+func (n *notificationsClient) Notify(ctx context.Context, userId string, note userNotification) error {
+	if n.baseURL == "" {
+		log.Printf("notification for %s: %s (%s)", userId, note.TemplateKey, note.Key)
+		return nil
+	}
+	b, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(n.baseURL, "/") + "/user/" + userId
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notifications service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ensureSSHKeyLifecycleIndexes supports the sweeper queries.
+func ensureSSHKeyLifecycleIndexes(ctx context.Context, coll *mongo.Collection) {
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "lastUsedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+	}
+	if _, err := coll.Indexes().CreateMany(ctx, models); err != nil {
+		log.Printf("ensureSSHKeyLifecycleIndexes: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestComputeFingerprintMatchesOpenSSH(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(ssh.MarshalAuthorizedKey(sshPub))
+	sum := sha256.Sum256(sshPub.Marshal())
+	want := "SHA256:" + base64.StdEncoding.EncodeToString(sum[:])
+	if got := computeFingerprint(line[:len(line)-1] + " alice@laptop"); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if !fingerprintRe.MatchString(want) {
+		t.Fatalf("canonical fingerprint %s rejected by fingerprintRe", want)
+	}
+}
+
+func TestSSHKeyLookupRejectsMalformedFingerprint(t *testing.T) {
+	req := httptest.NewRequest("GET", "/internal/api/ssh-keys/SHA256:short", nil)
+	req = mux.SetURLVars(req, map[string]string{"fingerprint": "SHA256:short"})
+	rr := httptest.NewRecorder()
+	sshKeyLookupHandler(context.Background(), rr, req, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestNotificationsClientPostsUserNotification(t *testing.T) {
+	var gotPath string
+	var got userNotification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	exp := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	n := &notificationsClient{client: srv.Client(), baseURL: srv.URL}
+	err := n.Notify(context.Background(), "507f1f77bcf86cd799439011", userNotification{
+		Key:         "ssh-key-expiring-k1",
+		TemplateKey: "notification_ssh_key_expiring",
+		MessageOpts: map[string]interface{}{"keyName": "laptop"},
+		Expires:     &exp,
+	})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if gotPath != "/user/507f1f77bcf86cd799439011" {
+		t.Fatalf("unexpected path %s", gotPath)
+	}
+	if got.Key != "ssh-key-expiring-k1" || got.TemplateKey != "notification_ssh_key_expiring" || got.Expires == nil || !got.Expires.Equal(exp) {
+		t.Fatalf("unexpected notification %+v", got)
+	}
+}
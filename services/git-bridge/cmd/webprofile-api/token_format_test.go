@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStructuredTokenRoundTrip(t *testing.T) {
+	tokenId, plain, err := generateStructuredToken()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.HasPrefix(plain, tokenPrefix) {
+		t.Fatalf("expected %s prefix, got %s", tokenPrefix, plain)
+	}
+	got, ok := parseStructuredToken(plain)
+	if !ok {
+		t.Fatalf("expected generated token to parse: %s", plain)
+	}
+	if got != tokenId {
+		t.Fatalf("expected tokenId %s, got %s", tokenId, got)
+	}
+}
+
+func TestStructuredTokenBadChecksum(t *testing.T) {
+	_, plain, err := generateStructuredToken()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	// flip the last checksum character
+	last := plain[len(plain)-1]
+	repl := byte('0')
+	if last == '0' {
+		repl = '1'
+	}
+	tampered := plain[:len(plain)-1] + string(repl)
+	if _, ok := parseStructuredToken(tampered); ok {
+		t.Fatalf("expected tampered token to be rejected")
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -80,3 +83,74 @@ func TestTokenCreateAndIntrospectIntegration(t *testing.T) {
 	// cleanup
 	coll.DeleteMany(ctx, bson.M{"userId": "int-test-user"})
 }
+
+func TestTokenCreateRejectsPastExpiry(t *testing.T) {
+	// Policy validation happens before any DB access
+	body := bytes.NewBufferString(`{"label":"l","expiresAt":"2000-01-01T00:00:00Z"}`)
+	req, _ := http.NewRequest("POST", "/internal/api/users/u1/git-tokens", body)
+	rr := httptest.NewRecorder()
+	tokenCreateHandler(context.Background(), rr, req, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for past expiresAt, got %d", rr.Code)
+	}
+	var out map[string]string
+	json.NewDecoder(rr.Body).Decode(&out)
+	if out["message"] != errExpiryInPast.Error() {
+		t.Fatalf("unexpected body: %v", out)
+	}
+}
+
+func TestTokenRotateValidation(t *testing.T) {
+	// id and grace validation happen before any DB access
+	req, _ := http.NewRequest("POST", "/internal/api/users/u1/git-tokens/not-an-id/rotate", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "u1", "tokenId": "not-an-id"})
+	rr := httptest.NewRecorder()
+	tokenRotateHandler(context.Background(), rr, req, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid token id, got %d", rr.Code)
+	}
+
+	body := bytes.NewBufferString(`{"gracePeriodSeconds":-1}`)
+	req2, _ := http.NewRequest("POST", "/internal/api/users/u1/git-tokens/507f1f77bcf86cd799439011/rotate", body)
+	req2 = mux.SetURLVars(req2, map[string]string{"userId": "u1", "tokenId": "507f1f77bcf86cd799439011"})
+	rr2 := httptest.NewRecorder()
+	tokenRotateHandler(context.Background(), rr2, req2, nil)
+	if rr2.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for negative grace period, got %d", rr2.Code)
+	}
+}
+
+func TestTokenRotateRejectsExpiredTokenIntegration(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set; skipping integration test")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongo connect: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	coll := client.Database("sharelatex").Collection("personalaccesstokens")
+	coll.DeleteMany(ctx, bson.M{"userId": "int-rotate-expired"})
+	defer coll.DeleteMany(ctx, bson.M{"userId": "int-rotate-expired"})
+
+	doc, _, err := issueToken("int-rotate-expired", "old", []string{"repo:read"}, time.Now().Add(-time.Minute), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	if _, err := coll.InsertOne(ctx, doc); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	id := doc["_id"].(primitive.ObjectID).Hex()
+	req := httptest.NewRequest("POST", "/internal/api/users/int-rotate-expired/git-tokens/"+id+"/rotate", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "int-rotate-expired", "tokenId": id})
+	rr := httptest.NewRecorder()
+	tokenRotateHandler(ctx, rr, req, client)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 rotating an expired token, got %d", rr.Code)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{"userId": "int-rotate-expired"}); n != 1 {
+		t.Fatalf("expected no successor, found %d tokens", n)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// argon2Params are the parameters used for new hashes and hash upgrades.
+// They are encoded in the PHC hash string and mirrored in `hashParams`.
+// main replaces the defaults with argon2ParamsFromEnv.
+var argon2Params = &argon2id.Params{Memory: 64 * 1024, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+// argon2ParamsFromEnv reads the argon2id cost from environment variables:
+// - TOKEN_ARGON2_MEMORY_KB (default 65536, 8*parallelism to 4194304)
+// - TOKEN_ARGON2_ITERATIONS (default 1, 1 to 64)
+// - TOKEN_ARGON2_PARALLELISM (default 2, 1 to 255)
+//
+// Out-of-range or malformed values are an error rather than silently
+// replaced, so a typo cannot quietly weaken or inflate the hash cost.
+func argon2ParamsFromEnv() (*argon2id.Params, error) {
+	memory, err := envIntRange("TOKEN_ARGON2_MEMORY_KB", 64*1024, 8, 4*1024*1024)
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := envIntRange("TOKEN_ARGON2_ITERATIONS", 1, 1, 64)
+	if err != nil {
+		return nil, err
+	}
+	parallelism, err := envIntRange("TOKEN_ARGON2_PARALLELISM", 2, 1, math.MaxUint8)
+	if err != nil {
+		return nil, err
+	}
+	if memory < 8*parallelism {
+		return nil, fmt.Errorf("TOKEN_ARGON2_MEMORY_KB must be at least 8 * TOKEN_ARGON2_PARALLELISM (%d)", 8*parallelism)
+	}
+	return &argon2id.Params{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}, nil
+}
+
+// envIntRange reads a whole number between lo and hi, defaulting to d when unset.
+func envIntRange(k string, d, lo, hi int) (int, error) {
+	n, err := strconv.Atoi(getEnv(k, strconv.Itoa(d)))
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be a number between %d and %d", k, lo, hi)
+	}
+	return n, nil
+}
+
+func envInt(k string, d int) int {
+	n, err := strconv.Atoi(getEnv(k, ""))
+	if err != nil || n <= 0 {
+		return d
+	}
+	return n
+}
+
+// argon2ParamsString renders params in the same m=,t=,p= form as the PHC string.
+func argon2ParamsString(p *argon2id.Params) string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+}
+
+// hashToken hashes a plaintext token with the current argon2id parameters.
+func hashToken(token string) (string, error) {
+	return argon2id.CreateHash(token, argon2Params)
+}
+
+// verifyTokenHash checks a plaintext token against a stored hash, dispatching
+// on the hash format (argon2id, bcrypt or pbkdf2$salt$hex).
+func verifyTokenHash(token, storedHash string) bool {
+	if strings.HasPrefix(storedHash, "$argon2") {
+		match, err := argon2id.ComparePasswordAndHash(token, storedHash)
+		return err == nil && match
+	}
+	if strings.HasPrefix(storedHash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(token)) == nil
+	}
+	if strings.HasPrefix(storedHash, "pbkdf2$") {
+		parts := strings.Split(storedHash, "$")
+		if len(parts) >= 3 {
+			salt, _ := hex.DecodeString(parts[1])
+			expected := parts[2]
+			derived := pbkdf2.Key([]byte(token), salt, 100000, 64, sha256.New)
+			return hex.EncodeToString(derived) == expected
+		}
+	}
+	return false
+}
+
+// needsRehash reports whether a verified hash should be replaced: any
+// non-argon2id hash, or an argon2id hash with parameters other than current.
+func needsRehash(storedHash string) bool {
+	if !strings.HasPrefix(storedHash, "$argon2id$") {
+		return true
+	}
+	p, _, _, err := argon2id.DecodeHash(storedHash)
+	if err != nil {
+		return true
+	}
+	return p.Memory != argon2Params.Memory || p.Iterations != argon2Params.Iterations || p.Parallelism != argon2Params.Parallelism
+}
+
+// maxConcurrentHashUpgrades bounds how many argon2id upgrades run at once;
+// each one allocates the full argon2 memory cost.
+const maxConcurrentHashUpgrades = 2
+
+// hashUpgrades deduplicates background hash upgrades. A hot legacy token is
+// verified many times before its upgrade lands, and each verification would
+// otherwise start another full-cost argon2id hash.
+var hashUpgrades = newHashUpgrader(maxConcurrentHashUpgrades)
+
+type hashUpgrader struct {
+	mu       sync.Mutex
+	inFlight map[interface{}]bool
+	slots    chan struct{}
+}
+
+func newHashUpgrader(n int) *hashUpgrader {
+	return &hashUpgrader{inFlight: make(map[interface{}]bool), slots: make(chan struct{}, n)}
+}
+
+// acquire claims the upgrade of the token with the given id. It fails when an
+// upgrade for that token is already running or all slots are busy; the token
+// is simply upgraded on a later verification.
+func (u *hashUpgrader) acquire(id interface{}) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.inFlight[id] {
+		return false
+	}
+	select {
+	case u.slots <- struct{}{}:
+	default:
+		return false
+	}
+	u.inFlight[id] = true
+	return true
+}
+
+func (u *hashUpgrader) release(id interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.inFlight, id)
+	<-u.slots
+}
+
+// scheduleTokenHashUpgrade starts upgradeTokenHash in the background unless an
+// upgrade of the same token is already running or the upgrader is saturated.
+func scheduleTokenHashUpgrade(ctx context.Context, coll *mongo.Collection, doc bson.M, token string) {
+	id := doc["_id"]
+	if !hashUpgrades.acquire(id) {
+		return
+	}
+	go func() {
+		defer hashUpgrades.release(id)
+		upgradeTokenHash(ctx, coll, doc, token)
+	}()
+}
+
+// upgradeTokenHash re-hashes a successfully verified token with the current
+// argon2id parameters. The update is conditional on the stored hash being
+// unchanged, so concurrent verifications upgrade at most once and a revoke or
+// rotation in between is never overwritten.
+func upgradeTokenHash(ctx context.Context, coll *mongo.Collection, doc bson.M, token string) {
+	oldHash, _ := doc["hash"].(string)
+	newHash, err := hashToken(token)
+	if err != nil {
+		log.Printf("token hash upgrade: %v", err)
+		return
+	}
+	filter := bson.M{"_id": doc["_id"], "hash": oldHash}
+	update := bson.M{"$set": bson.M{
+		"hash":           newHash,
+		"algorithm":      "argon2id",
+		"hashParams":     argon2ParamsString(argon2Params),
+		"hashUpgradedAt": time.Now(),
+	}}
+	res, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("token hash upgrade %v: %v", doc["_id"], err)
+		return
+	}
+	if res.ModifiedCount == 1 {
+		log.Printf("upgraded token hash %v from %v", doc["_id"], doc["algorithm"])
+	}
+}
+
+// tokenHashReportHandler reports the hash algorithm distribution across active
+// tokens, so operators can track progress of transparent upgrades.
+func tokenHashReportHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, client *mongo.Client) {
+	coll := client.Database("sharelatex").Collection("personalaccesstokens")
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"active": true}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"algorithm": "$algorithm", "hashParams": "$hashParams"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close(ctx)
+	current := argon2ParamsString(argon2Params)
+	total := int64(0)
+	out := []map[string]interface{}{}
+	for cur.Next(ctx) {
+		var row struct {
+			ID struct {
+				Algorithm  string `bson:"algorithm"`
+				HashParams string `bson:"hashParams"`
+			} `bson:"_id"`
+			Count int64 `bson:"count"`
+		}
+		if err := cur.Decode(&row); err != nil {
+			continue
+		}
+		algorithm := row.ID.Algorithm
+		if algorithm == "" {
+			algorithm = "unknown"
+		}
+		total += row.Count
+		out = append(out, map[string]interface{}{
+			"algorithm": algorithm,
+			"params":    row.ID.HashParams,
+			"count":     row.Count,
+			"current":   algorithm == "argon2id" && row.ID.HashParams == current,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":         total,
+		"currentParams": current,
+		"algorithms":    out,
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestVerifyTokenHashAlgorithms(t *testing.T) {
+	token := "0123456789abcdef"
+
+	bc, _ := bcrypt.GenerateFromPassword([]byte(token), bcrypt.MinCost)
+	salt := []byte("0123456789abcdef")
+	pb := "pbkdf2$" + hex.EncodeToString(salt) + "$" + hex.EncodeToString(pbkdf2.Key([]byte(token), salt, 100000, 64, sha256.New))
+	ar, err := hashToken(token)
+	if err != nil {
+		t.Fatalf("hashToken: %v", err)
+	}
+
+	for name, h := range map[string]string{"bcrypt": string(bc), "pbkdf2": pb, "argon2id": ar} {
+		if !verifyTokenHash(token, h) {
+			t.Fatalf("%s: expected token to verify", name)
+		}
+		if verifyTokenHash("wrong", h) {
+			t.Fatalf("%s: expected wrong token to fail", name)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, err := hashToken("tok")
+	if err != nil {
+		t.Fatalf("hashToken: %v", err)
+	}
+	if needsRehash(current) {
+		t.Fatalf("hash with current params should not need rehash")
+	}
+	stale, _ := argon2id.CreateHash("tok", &argon2id.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if !needsRehash(stale) {
+		t.Fatalf("argon2id hash with stale params should need rehash")
+	}
+	for _, legacy := range []string{"$2a$10$abcdefghijklmnopqrstuv", "pbkdf2$00$00"} {
+		if !needsRehash(legacy) {
+			t.Fatalf("legacy hash %q should need rehash", legacy)
+		}
+	}
+}
+
+func TestArgon2ParamsFromEnv(t *testing.T) {
+	t.Setenv("TOKEN_ARGON2_MEMORY_KB", "")
+	t.Setenv("TOKEN_ARGON2_ITERATIONS", "3")
+	t.Setenv("TOKEN_ARGON2_PARALLELISM", "255")
+	p, err := argon2ParamsFromEnv()
+	if err != nil {
+		t.Fatalf("argon2ParamsFromEnv: %v", err)
+	}
+	if p.Memory != 64*1024 || p.Iterations != 3 || p.Parallelism != 255 {
+		t.Fatalf("unexpected params %+v", p)
+	}
+
+	for k, bad := range map[string]string{
+		"TOKEN_ARGON2_PARALLELISM": "256",
+		"TOKEN_ARGON2_ITERATIONS":  "0",
+		"TOKEN_ARGON2_MEMORY_KB":   "64mb",
+	} {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv(k, bad)
+			if _, err := argon2ParamsFromEnv(); err == nil {
+				t.Fatalf("%s=%q: expected an error", k, bad)
+			}
+		})
+	}
+
+	// argon2 needs at least 8 KiB of memory per lane
+	t.Setenv("TOKEN_ARGON2_MEMORY_KB", "1024")
+	if _, err := argon2ParamsFromEnv(); err == nil {
+		t.Fatalf("expected memory below 8*parallelism to be rejected")
+	}
+}
+
+func TestHashUpgraderDeduplicates(t *testing.T) {
+	u := newHashUpgrader(2)
+	if !u.acquire("a") {
+		t.Fatalf("expected first upgrade of a to start")
+	}
+	if u.acquire("a") {
+		t.Fatalf("expected a second upgrade of a to be skipped while in flight")
+	}
+	if !u.acquire("b") {
+		t.Fatalf("expected an upgrade of b to start")
+	}
+	if u.acquire("c") {
+		t.Fatalf("expected c to be skipped while all slots are busy")
+	}
+	u.release("a")
+	if !u.acquire("a") {
+		t.Fatalf("expected a to be upgradable again after release")
+	}
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestTokenIntrospect_InvalidFormat(t *testing.T) {
@@ -35,3 +41,118 @@ func TestTokenIntrospect_InvalidFormat(t *testing.T) {
 		t.Fatalf("unexpected message: %v", out)
 	}
 }
+
+func TestTokenIntrospect_MalformedStructuredToken(t *testing.T) {
+	body := bytes.NewBufferString(`{"token":"ogt_0123456789abcdef_deadbeef_00000000"}`)
+	req, err := http.NewRequest("POST", "/internal/api/tokens/introspect", body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+
+	// Structured tokens are validated before any DB access
+	tokenIntrospectHandler(context.Background(), rr, req, nil)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	var out map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid json body: %v", err)
+	}
+	if out["message"] != "invalid token format" {
+		t.Fatalf("unexpected message: %v", out)
+	}
+}
+
+func TestTokenIntrospect_StructuredBadChecksum(t *testing.T) {
+	_, plain, err := generateStructuredToken()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	tampered := plain[:len(plain)-8] + "00000000"
+	if tampered == plain {
+		tampered = plain[:len(plain)-8] + "11111111"
+	}
+	body := bytes.NewBufferString(`{"token":"` + tampered + `"}`)
+	req := httptest.NewRequest("POST", "/internal/api/tokens/introspect", body)
+	rr := httptest.NewRecorder()
+
+	// A well-formed token with a bad checksum never reaches the DB
+	tokenIntrospectHandler(context.Background(), rr, req, nil)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestParseStructuredToken(t *testing.T) {
+	body := tokenPrefix + "0123456789abcdef_" + strings.Repeat("ab", 32)
+	valid := body + "_" + tokenChecksum(body)
+	if id, ok := parseStructuredToken(valid); !ok || id != "0123456789abcdef" {
+		t.Fatalf("expected %s to parse, got %q %v", valid, id, ok)
+	}
+	for _, bad := range []string{
+		body + "_00000000",
+		strings.ToUpper(valid),
+		strings.Replace(valid, "0123456789abcdef", "0123456789abcde", 1),
+		valid + "0",
+		"xgt_" + strings.TrimPrefix(valid, tokenPrefix),
+	} {
+		if _, ok := parseStructuredToken(bad); ok {
+			t.Fatalf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestStructuredTokenVerifiesAgainstIssuedHash(t *testing.T) {
+	doc, plain, err := issueToken("u1", "ci", []string{"git:read"}, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	tokenId, ok := parseStructuredToken(plain)
+	if !ok || tokenId != doc["tokenId"] {
+		t.Fatalf("issued token does not parse to its tokenId: %s", plain)
+	}
+	storedHash, _ := doc["hash"].(string)
+	if !verifyTokenHash(plain, storedHash) {
+		t.Fatalf("expected issued token to verify against its stored hash")
+	}
+
+	// same tokenId, different secret, valid checksum: the hash compare fails
+	body := tokenPrefix + tokenId + "_" + hex.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	forged := body + "_" + tokenChecksum(body)
+	if id, ok := parseStructuredToken(forged); !ok || id != tokenId {
+		t.Fatalf("forged token should be well formed")
+	}
+	if verifyTokenHash(forged, storedHash) {
+		t.Fatalf("expected a token with another secret not to verify")
+	}
+}
+
+func TestWriteIntrospection_Expired(t *testing.T) {
+	doc := bson.M{
+		"_id":       primitive.NewObjectID(),
+		"userId":    "u1",
+		"active":    true,
+		"expiresAt": primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute)),
+	}
+	rr := httptest.NewRecorder()
+	writeIntrospection(rr, doc, "")
+	var out map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid json body: %v", err)
+	}
+	if out["active"] != false || len(out) != 1 {
+		t.Fatalf("expected an expired token to be inactive, got %v", out)
+	}
+
+	doc["expiresAt"] = primitive.NewDateTimeFromTime(time.Now().Add(time.Hour))
+	rr = httptest.NewRecorder()
+	writeIntrospection(rr, doc, "")
+	out = nil
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if out["active"] != true || out["userId"] != "u1" {
+		t.Fatalf("expected an unexpired token to be active, got %v", out)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// tokenLifetimePolicy bounds the lifetime of personal access tokens. When the
+// caller does not ask for an expiry, a zero Default falls back to Max, and
+// tokens only never expire when Max is zero as well; a zero Max disables the
+// upper bound. Retention is how long expired
+// tokens are kept before MongoDB's TTL monitor deletes them.
+type tokenLifetimePolicy struct {
+	Default   time.Duration
+	Max       time.Duration
+	Retention time.Duration
+}
+
+// maxPolicyDays bounds every policy setting. MongoDB stores a TTL index's
+// expireAfterSeconds as an int32, so Retention cannot be longer than about 68
+// years; the same bound keeps the other durations from overflowing.
+const maxPolicyDays = math.MaxInt32 / (24 * 60 * 60)
+
+var (
+	errExpiryInPast = errors.New("expiresAt must be in the future")
+	errExpiryTooFar = errors.New("expiresAt exceeds maximum token lifetime")
+	// tokenPolicy is replaced by main with the configured policy.
+	tokenPolicy = tokenLifetimePolicy{
+		Default:   90 * 24 * time.Hour,
+		Max:       365 * 24 * time.Hour,
+		Retention: 30 * 24 * time.Hour,
+	}
+)
+
+// tokenPolicyFromEnv reads the policy from environment variables:
+//   - TOKEN_DEFAULT_LIFETIME_DAYS (default 90)
+//   - TOKEN_MAX_LIFETIME_DAYS (default 365)
+//   - TOKEN_EXPIRED_RETENTION_DAYS (default 30)
+//
+// Each must be a whole number of days between 0 and maxPolicyDays.
+func tokenPolicyFromEnv() (tokenLifetimePolicy, error) {
+	var p tokenLifetimePolicy
+	var err error
+	if p.Default, err = envDays("TOKEN_DEFAULT_LIFETIME_DAYS", 90); err != nil {
+		return p, err
+	}
+	if p.Max, err = envDays("TOKEN_MAX_LIFETIME_DAYS", 365); err != nil {
+		return p, err
+	}
+	if p.Retention, err = envDays("TOKEN_EXPIRED_RETENTION_DAYS", 30); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func envDays(k string, d int) (time.Duration, error) {
+	n, err := strconv.Atoi(getEnv(k, strconv.Itoa(d)))
+	if err != nil || n < 0 || n > maxPolicyDays {
+		return 0, fmt.Errorf("%s must be a number of days between 0 and %d", k, maxPolicyDays)
+	}
+	return time.Duration(n) * 24 * time.Hour, nil
+}
+
+// resolveExpiry returns the expiry to persist for a new token. A nil request
+// uses the policy default capped by Max, or Max itself when there is no
+// default; the zero time means no expiry.
+func (p tokenLifetimePolicy) resolveExpiry(now time.Time, requested *time.Time) (time.Time, error) {
+	if requested == nil {
+		if p.Default == 0 {
+			if p.Max > 0 {
+				return now.Add(p.Max), nil
+			}
+			return time.Time{}, nil
+		}
+		if p.Max > 0 && p.Default > p.Max {
+			return now.Add(p.Max), nil
+		}
+		return now.Add(p.Default), nil
+	}
+	if !requested.After(now) {
+		return time.Time{}, errExpiryInPast
+	}
+	if p.Max > 0 && requested.Sub(now) > p.Max {
+		return time.Time{}, errExpiryTooFar
+	}
+	return *requested, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestResolveExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := tokenLifetimePolicy{Default: 30 * 24 * time.Hour, Max: 90 * 24 * time.Hour}
+
+	got, err := p.resolveExpiry(now, nil)
+	if err != nil || !got.Equal(now.Add(p.Default)) {
+		t.Fatalf("expected default expiry, got %v err=%v", got, err)
+	}
+
+	past := now.Add(-time.Hour)
+	if _, err := p.resolveExpiry(now, &past); err != errExpiryInPast {
+		t.Fatalf("expected errExpiryInPast, got %v", err)
+	}
+
+	far := now.Add(91 * 24 * time.Hour)
+	if _, err := p.resolveExpiry(now, &far); err != errExpiryTooFar {
+		t.Fatalf("expected errExpiryTooFar, got %v", err)
+	}
+
+	ok := now.Add(10 * 24 * time.Hour)
+	got, err = p.resolveExpiry(now, &ok)
+	if err != nil || !got.Equal(ok) {
+		t.Fatalf("expected requested expiry, got %v err=%v", got, err)
+	}
+
+	// no default but a maximum: tokens still expire, at the maximum
+	maxOnly := tokenLifetimePolicy{Max: 90 * 24 * time.Hour}
+	got, err = maxOnly.resolveExpiry(now, nil)
+	if err != nil || !got.Equal(now.Add(maxOnly.Max)) {
+		t.Fatalf("expected max expiry without a default, got %v err=%v", got, err)
+	}
+
+	unbounded := tokenLifetimePolicy{}
+	got, err = unbounded.resolveExpiry(now, nil)
+	if err != nil || !got.IsZero() {
+		t.Fatalf("expected no expiry without default/max, got %v err=%v", got, err)
+	}
+}
+
+func TestTokenPolicyFromEnv(t *testing.T) {
+	t.Setenv("TOKEN_DEFAULT_LIFETIME_DAYS", "")
+	t.Setenv("TOKEN_MAX_LIFETIME_DAYS", "0")
+	t.Setenv("TOKEN_EXPIRED_RETENTION_DAYS", strconv.Itoa(maxPolicyDays))
+	p, err := tokenPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("tokenPolicyFromEnv: %v", err)
+	}
+	if p.Default != 90*24*time.Hour || p.Max != 0 || int64(p.Retention.Seconds()) > math.MaxInt32 {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+
+	// values that would wrap the TTL index's int32 expireAfterSeconds, or the
+	// Duration itself, are rejected rather than truncated
+	for _, bad := range []string{strconv.Itoa(maxPolicyDays + 1), "200000", "-1", "thirty"} {
+		t.Setenv("TOKEN_EXPIRED_RETENTION_DAYS", bad)
+		if _, err := tokenPolicyFromEnv(); err == nil {
+			t.Fatalf("retention %q: expected an error", bad)
+		}
+	}
+}
+
+func TestIsIndexOptionsConflict(t *testing.T) {
+	if !isIndexOptionsConflict(mongo.CommandError{Code: 85, Name: "IndexOptionsConflict"}) {
+		t.Fatalf("expected code 85 to be an options conflict")
+	}
+	for _, err := range []error{nil, mongo.CommandError{Code: 86, Name: "IndexKeySpecsConflict"}, errors.New("boom")} {
+		if isIndexOptionsConflict(err) {
+			t.Fatalf("expected %v not to be an options conflict", err)
+		}
+	}
+}
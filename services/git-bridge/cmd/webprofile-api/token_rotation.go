@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rotationGrace is how long a rotated token keeps working alongside its
+// successor, from TOKEN_ROTATION_GRACE_SECONDS (default 86400). Callers may
+// request a shorter window per rotation.
+var rotationGrace = time.Duration(envInt("TOKEN_ROTATION_GRACE_SECONDS", 86400)) * time.Second
+
+// errAlreadyRotated is returned inside the rotation transaction when another
+// rotation claimed the token first.
+var errAlreadyRotated = errors.New("token already rotated")
+
+// tokenRotateHandler issues a successor for an active, unexpired token with
+// the same label and scopes. An expired token cannot be revived this way. The
+// old token stays valid until the grace period ends; both records are linked
+// via rotatedTo/rotatedFrom so the chain can be walked.
+func tokenRotateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, client *mongo.Client) {
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+	oldId, err := primitive.ObjectIDFromHex(vars["tokenId"])
+	if err != nil {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		GracePeriodSeconds *int64 `json:"gracePeriodSeconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+	}
+	grace := rotationGrace
+	if req.GracePeriodSeconds != nil {
+		g := time.Duration(*req.GracePeriodSeconds) * time.Second
+		if g < 0 || g > rotationGrace {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"message": "gracePeriodSeconds out of range"})
+			return
+		}
+		grace = g
+	}
+
+	coll := client.Database("sharelatex").Collection("personalaccesstokens")
+	var old struct {
+		Label     string     `bson:"label"`
+		Scopes    []string   `bson:"scopes"`
+		ExpiresAt *time.Time `bson:"expiresAt"`
+	}
+	now := time.Now()
+	unexpired := bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}}
+	if err := coll.FindOne(ctx, bson.M{"_id": oldId, "userId": userId, "active": true, "$or": unexpired}).Decode(&old); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt, err := tokenPolicy.resolveExpiry(now, nil)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	doc, plain, err := issueToken(userId, old.Label, old.Scopes, expiresAt, now)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	newId := doc["_id"].(primitive.ObjectID)
+	doc["rotatedFrom"] = oldId
+
+	// Claim the old token and insert its successor together; the rotatedTo
+	// guard makes concurrent rotations of the same token fail with 409
+	// instead of forking the chain.
+	graceUntil := now.Add(grace)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(graceUntil) {
+		graceUntil = *old.ExpiresAt
+	}
+	claim := bson.M{"_id": oldId, "userId": userId, "active": true, "rotatedTo": bson.M{"$exists": false}, "$or": unexpired}
+	update := bson.M{"$set": bson.M{"rotatedTo": newId, "rotatedAt": now, "graceUntil": graceUntil, "expiresAt": graceUntil}}
+	err = withOptionalTransaction(ctx, client, func(tx context.Context) error {
+		res, err := coll.UpdateOne(tx, claim, update)
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			return errAlreadyRotated
+		}
+		_, err = coll.InsertOne(tx, doc)
+		return err
+	})
+	if err == errAlreadyRotated {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("rotate %s: %v", oldId.Hex(), err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":                 newId.Hex(),
+		"tokenId":            doc["tokenId"],
+		"token":              plain,
+		"accessTokenPartial": doc["hashPrefix"],
+		"expiresAt":          doc["expiresAt"],
+		"rotatedFrom":        oldId.Hex(),
+		"previousValidUntil": graceUntil,
+	})
+}
+
+// runRotationSweeper deactivates rotated tokens whose grace period has ended
+// and enqueues a token cache invalidation for each, every
+// TOKEN_ROTATION_SWEEP_SECONDS (default 60).
+func runRotationSweeper(ctx context.Context, coll *mongo.Collection) {
+	t := time.NewTicker(time.Duration(envInt("TOKEN_ROTATION_SWEEP_SECONDS", 60)) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			sweepRotatedTokens(ctx, coll, time.Now())
+		}
+	}
+}
+
+func sweepRotatedTokens(ctx context.Context, coll *mongo.Collection, now time.Time) {
+	filter := bson.M{"active": true, "rotatedTo": bson.M{"$exists": true}, "graceUntil": bson.M{"$lte": now}}
+	cur, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "hashPrefix": 1}))
+	if err != nil {
+		log.Printf("rotation sweep: %v", err)
+		return
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var d struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			HashPrefix string             `bson:"hashPrefix"`
+		}
+		if err := cur.Decode(&d); err != nil {
+			continue
+		}
+		// only the sweeper that flips active enqueues, so events are not duplicated
+		err := withOptionalTransaction(ctx, coll.Database().Client(), func(tx context.Context) error {
+			res, err := coll.UpdateOne(tx, bson.M{"_id": d.ID, "active": true}, bson.M{"$set": bson.M{"active": false, "lapsedAt": now}})
+			if err != nil || res.ModifiedCount == 0 {
+				return err
+			}
+			return enqueueInvalidation(tx, coll.Database(), newTokenInvalidation(d.ID.Hex(), d.HashPrefix, "rotated"))
+		})
+		if err != nil {
+			log.Printf("rotation sweep %s: %v", d.ID.Hex(), err)
+		}
+	}
+}
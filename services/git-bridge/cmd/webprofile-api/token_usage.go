@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usageRecorder is set by main; when nil, usage tracking is disabled (unit tests).
+var usageRecorder *tokenUsageRecorder
+
+// pendingUsage aggregates successful verifications of one token between flushes.
+type pendingUsage struct {
+	userId interface{}
+	count  int64
+	lastAt time.Time
+	lastIP string
+}
+
+// tokenUsageRecorder records token usage off the request path. Verifications
+// are aggregated in memory and flushed at most once per interval per token, so
+// a hot CI token costs one update per interval rather than one per request.
+// Each flush also appends a bucket to the usage history collection.
+type tokenUsageRecorder struct {
+	tokens   *mongo.Collection
+	history  *mongo.Collection
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]*pendingUsage
+}
+
+// newTokenUsageRecorder builds a recorder using environment variables:
+// - TOKEN_USAGE_FLUSH_SECONDS (default 60)
+func newTokenUsageRecorder(db *mongo.Database) *tokenUsageRecorder {
+	interval := 60
+	if n, err := strconv.Atoi(getEnv("TOKEN_USAGE_FLUSH_SECONDS", "60")); err == nil && n > 0 {
+		interval = n
+	}
+	return &tokenUsageRecorder{
+		tokens:   db.Collection("personalaccesstokens"),
+		history:  db.Collection("personalaccesstokenusage"),
+		interval: time.Duration(interval) * time.Second,
+		pending:  make(map[primitive.ObjectID]*pendingUsage),
+	}
+}
+
+// Record notes a successful verification. It never blocks on the database.
+func (u *tokenUsageRecorder) Record(doc bson.M, ip string) {
+	if u == nil {
+		return
+	}
+	id, ok := doc["_id"].(primitive.ObjectID)
+	if !ok {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p := u.pending[id]
+	if p == nil {
+		p = &pendingUsage{userId: doc["userId"]}
+		u.pending[id] = p
+	}
+	p.count++
+	p.lastAt = time.Now()
+	if ip != "" {
+		p.lastIP = ip
+	}
+}
+
+// run flushes pending usage every interval until ctx is cancelled. main
+// flushes once more after the HTTP server has stopped, so usage recorded by
+// the last requests is kept.
+func (u *tokenUsageRecorder) run(ctx context.Context) {
+	t := time.NewTicker(u.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			u.flush(ctx)
+		}
+	}
+}
+
+func (u *tokenUsageRecorder) flush(ctx context.Context) {
+	u.mu.Lock()
+	batch := u.pending
+	u.pending = make(map[primitive.ObjectID]*pendingUsage)
+	u.mu.Unlock()
+
+	for id, p := range batch {
+		set := bson.M{"lastUsedAt": p.lastAt}
+		if p.lastIP != "" {
+			set["lastUsedIP"] = p.lastIP
+		}
+		if _, err := u.tokens.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set, "$inc": bson.M{"usageCount": p.count}}); err != nil {
+			log.Printf("token usage update %s: %v", id.Hex(), err)
+			continue
+		}
+		entry := bson.M{"tokenRef": id, "userId": p.userId, "at": p.lastAt, "count": p.count}
+		if p.lastIP != "" {
+			entry["ip"] = p.lastIP
+		}
+		if _, err := u.history.InsertOne(ctx, entry); err != nil {
+			log.Printf("token usage history %s: %v", id.Hex(), err)
+		}
+	}
+}
+
+// ensureUsageIndexes indexes history by token and expires buckets after
+// retention (TOKEN_USAGE_HISTORY_DAYS, default 90). History buckets reference
+// the token by its _id in tokenRef, not by the public structured tokenId.
+func ensureUsageIndexes(ctx context.Context, coll *mongo.Collection, retention time.Duration) {
+	idx := mongo.IndexModel{Keys: bson.D{{Key: "tokenRef", Value: 1}, {Key: "at", Value: -1}}}
+	if _, err := coll.Indexes().CreateOne(ctx, idx); err != nil {
+		log.Printf("ensureUsageIndexes: %v", err)
+	}
+	if err := ensureTTLIndex(ctx, coll, "at", retention); err != nil {
+		log.Printf("ensureUsageIndexes: %v", err)
+	} else {
+		log.Printf("ensured usage history indexes (retention=%s)", retention)
+	}
+}
+
+// tokenUsageHandler returns the usage history buckets for one of the user's
+// tokens, newest first. `limit` caps the number of buckets (default 100).
+func tokenUsageHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, client *mongo.Client) {
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+	id, err := primitive.ObjectIDFromHex(vars["tokenId"])
+	if err != nil {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+	limit := int64(100)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	db := client.Database("sharelatex")
+	if err := db.Collection("personalaccesstokens").FindOne(ctx, bson.M{"_id": id, "userId": userId}).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(limit)
+	cur, err := db.Collection("personalaccesstokenusage").Find(ctx, bson.M{"tokenRef": id}, opts)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close(ctx)
+	out := []map[string]interface{}{}
+	for cur.Next(ctx) {
+		var d struct {
+			At    time.Time `bson:"at"`
+			Count int64     `bson:"count"`
+			IP    string    `bson:"ip"`
+		}
+		if err := cur.Decode(&d); err != nil {
+			continue
+		}
+		out = append(out, map[string]interface{}{"at": d.At, "count": d.Count, "ip": d.IP})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
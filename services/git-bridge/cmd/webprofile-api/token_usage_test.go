@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUsageRecorderAggregates(t *testing.T) {
+	u := &tokenUsageRecorder{pending: make(map[primitive.ObjectID]*pendingUsage)}
+	id := primitive.NewObjectID()
+	doc := bson.M{"_id": id, "userId": "u1"}
+
+	u.Record(doc, "10.0.0.1")
+	u.Record(doc, "")
+	u.Record(doc, "10.0.0.2")
+
+	p := u.pending[id]
+	if p == nil {
+		t.Fatalf("expected pending usage for token")
+	}
+	if p.count != 3 {
+		t.Fatalf("expected count 3, got %d", p.count)
+	}
+	if p.lastIP != "10.0.0.2" {
+		t.Fatalf("expected last ip 10.0.0.2, got %s", p.lastIP)
+	}
+}
+
+func TestUsageRecorderNilSafe(t *testing.T) {
+	var u *tokenUsageRecorder
+	// unit tests run handlers without a recorder; Record must be a no-op
+	u.Record(bson.M{"_id": primitive.NewObjectID()}, "10.0.0.1")
+}
@@ -1,35 +1,73 @@
 package lookup
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 type FingerprintResponse struct {
 	UserId string `json:"userId"`
+	// ExpiresAt is set when the key expires; results must not be trusted past it.
+	ExpiresAt *time.Time `json:"expiresAt"`
 }
 
 func LookupFingerprint(client *http.Client, baseURL string, fingerprint string) (string, error) {
+	fr, err := LookupKey(client, baseURL, fingerprint)
+	if err != nil || fr == nil {
+		return "", err
+	}
+	return fr.UserId, nil
+}
+
+// LookupKey resolves a fingerprint to its owning key. A nil response without
+// error means the key is unknown, expired or disabled. The expiry is checked
+// here as well, so a key reported past its expiresAt is never accepted.
+func LookupKey(client *http.Client, baseURL string, fingerprint string) (*FingerprintResponse, error) {
 	if !strings.HasPrefix(fingerprint, "SHA256:") {
-		return "", fmt.Errorf("malformed fingerprint")
+		return nil, fmt.Errorf("malformed fingerprint")
 	}
-	url := fmt.Sprintf("%s/internal/api/ssh-keys/%s", strings.TrimRight(baseURL, "/"), fingerprint)
-	resp, err := client.Get(url)
+	// base64 fingerprints may contain '/' and '+'
+	endpoint := fmt.Sprintf("%s/internal/api/ssh-keys/%s", strings.TrimRight(baseURL, "/"), url.PathEscape(fingerprint))
+	resp, err := client.Get(endpoint)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusNotFound {
-		return "", nil
+		return nil, nil
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 	var fr FingerprintResponse
 	if err := json.NewDecoder(resp.Body).Decode(&fr); err != nil {
-		return "", err
+		return nil, err
 	}
-	return fr.UserId, nil
+	if fr.UserId == "" {
+		return nil, nil
+	}
+	if fr.ExpiresAt != nil && !fr.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &fr, nil
+}
+
+// ReportKeyUsage tells the web-profile service that a key authenticated
+// successfully so it can record lastUsedAt.
+func ReportKeyUsage(client *http.Client, baseURL, fingerprint, sourceIP string) error {
+	b, _ := json.Marshal(map[string]string{"fingerprint": fingerprint, "sourceIp": sourceIP})
+	resp, err := client.Post(strings.TrimRight(baseURL, "/")+"/internal/api/ssh-keys/usage", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return nil
 }
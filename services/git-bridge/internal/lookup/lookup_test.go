@@ -43,3 +43,58 @@ func TestLookupFingerprintMalformed(t *testing.T) {
 		t.Fatalf("expected error for malformed fingerprint")
 	}
 }
+
+func TestLookupKeyEscapesFingerprintAndReturnsExpiry(t *testing.T) {
+	var rawPath string
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawPath = r.URL.EscapedPath()
+		w.Write([]byte(`{"userId":"u-1","expiresAt":"2030-01-02T03:04:05Z"}`))
+	}))
+	defer h.Close()
+	fr, err := LookupKey(h.Client(), h.URL, "SHA256:ab/c+d=")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if rawPath != "/internal/api/ssh-keys/SHA256:ab%2Fc+d=" {
+		t.Fatalf("fingerprint not escaped: %s", rawPath)
+	}
+	if fr == nil || fr.UserId != "u-1" || fr.ExpiresAt == nil || fr.ExpiresAt.Year() != 2030 {
+		t.Fatalf("unexpected response: %+v", fr)
+	}
+}
+
+func TestLookupKeyTreatsExpiredKeyAsNotFound(t *testing.T) {
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"userId":"u-1","expiresAt":"2001-01-02T03:04:05Z"}`))
+	}))
+	defer h.Close()
+	fr, err := LookupKey(h.Client(), h.URL, "SHA256:AAA")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if fr != nil {
+		t.Fatalf("expired key should not be found, got %+v", fr)
+	}
+	user, err := LookupFingerprint(h.Client(), h.URL, "SHA256:AAA")
+	if err != nil || user != "" {
+		t.Fatalf("expected no user for expired key, got %q (err %v)", user, err)
+	}
+}
+
+func TestReportKeyUsage(t *testing.T) {
+	var got map[string]string
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/internal/api/ssh-keys/usage" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer h.Close()
+	if err := ReportKeyUsage(h.Client(), h.URL, "SHA256:AAA", "192.0.2.1"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got["fingerprint"] != "SHA256:AAA" || got["sourceIp"] != "192.0.2.1" {
+		t.Fatalf("unexpected body: %v", got)
+	}
+}
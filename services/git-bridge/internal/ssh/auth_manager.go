@@ -6,6 +6,8 @@ package ssh
 
 import (
 	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -14,6 +16,8 @@ import (
 
 	"errors"
 	"github.com/overleaf/git-bridge/internal/lookup"
+	"github.com/overleaf/git-bridge/internal/membership"
+	"github.com/overleaf/git-bridge/internal/svcauth"
 	"github.com/overleaf/git-bridge/internal/webprofile"
 )
 
@@ -30,12 +34,20 @@ type AuthManager struct {
 	mu       sync.RWMutex
 	cache    map[string]cacheEntry
 	closed   bool
+
+	usageInterval time.Duration
+	usageMu       sync.Mutex
+	lastReported  map[string]time.Time
 }
 
 // NewAuthManagerFromEnv constructs an AuthManager using environment variables:
 // - SSH_LOOKUP_BASE_URL (required)
 // - CACHE_LOOKUP_TTL_SECONDS (default 60)
 // - CACHE_NEGATIVE_TTL_SECONDS (default 5)
+// - SSH_USAGE_REPORT_SECONDS (default 300): minimum interval between usage
+//   reports for the same key
+// and, when client is nil, the svcauth signing variables (SERVICE_AUTH_KEY_ID,
+// SERVICE_AUTH_SECRET, SERVICE_TLS_*).
 func NewAuthManagerFromEnv(client *http.Client) (*AuthManager, error) {
 	base := os.Getenv("SSH_LOOKUP_BASE_URL")
 	if base == "" {
@@ -53,8 +65,19 @@ func NewAuthManagerFromEnv(client *http.Client) (*AuthManager, error) {
 			neg = n
 		}
 	}
+	usage := 300
+	if v := os.Getenv("SSH_USAGE_REPORT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			usage = n
+		}
+	}
 	if client == nil {
-		client = &http.Client{Timeout: 5 * time.Second}
+		// signed (and optionally mTLS) client for lookup, membership and introspection
+		c, err := svcauth.NewClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		client = c
 	}
 	return &AuthManager{
 		client:  client,
@@ -62,6 +85,9 @@ func NewAuthManagerFromEnv(client *http.Client) (*AuthManager, error) {
 		ttl:     time.Duration(ttl) * time.Second,
 		negTtl:  time.Duration(neg) * time.Second,
 		cache:   make(map[string]cacheEntry),
+
+		usageInterval: time.Duration(usage) * time.Second,
+		lastReported:  make(map[string]time.Time),
 	}, nil
 }
 
@@ -83,19 +109,69 @@ func (a *AuthManager) LookupUserForFingerprint(ctx context.Context, fingerprint
 	a.mu.RUnlock()
 
 	// Miss: call lookup
-	user, err := lookup.LookupFingerprint(a.client, a.baseURL, fingerprint)
+	key, err := lookup.LookupKey(a.client, a.baseURL, fingerprint)
 	if err != nil {
 		return "", err
 	}
-	// Store positive or negative TTL
+	// Store positive or negative TTL; a positive entry never outlives the key
+	now := time.Now()
 	a.mu.Lock()
-	if user == "" {
-		a.cache[fingerprint] = cacheEntry{userId: "", expiresAt: time.Now().Add(a.negTtl)}
-	} else {
-		a.cache[fingerprint] = cacheEntry{userId: user, expiresAt: time.Now().Add(a.ttl)}
+	defer a.mu.Unlock()
+	if key == nil {
+		a.cache[fingerprint] = cacheEntry{userId: "", expiresAt: now.Add(a.negTtl)}
+		return "", nil
+	}
+	exp := now.Add(a.ttl)
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(exp) {
+		exp = *key.ExpiresAt
+	}
+	a.cache[fingerprint] = cacheEntry{userId: key.UserId, expiresAt: exp}
+	return key.UserId, nil
+}
+
+// ReportKeyUsed reports a successful authentication to the web-profile
+// service in the background, at most once per usage interval per key.
+func (a *AuthManager) ReportKeyUsed(fingerprint, sourceIP string) {
+	now := time.Now()
+	a.usageMu.Lock()
+	if last, ok := a.lastReported[fingerprint]; ok && now.Sub(last) < a.usageInterval {
+		a.usageMu.Unlock()
+		return
 	}
+	a.lastReported[fingerprint] = now
+	a.usageMu.Unlock()
+	go func() {
+		if err := lookup.ReportKeyUsage(a.client, a.baseURL, fingerprint, sourceIP); err != nil {
+			log.Printf("ssh key usage report %s: %v", fingerprint, err)
+		}
+	}()
+}
+
+// InvalidateFingerprint drops any cached lookup for fingerprint so the next
+// authentication consults the web-profile service again.
+func (a *AuthManager) InvalidateFingerprint(fingerprint string) {
+	a.mu.Lock()
+	delete(a.cache, fingerprint)
 	a.mu.Unlock()
-	return user, nil
+}
+
+// InvalidationHandler accepts auth.cache.invalidate.v1 events pushed by the
+// web-profile service (HTTP fan-out publisher). Token events are accepted and
+// ignored because token introspection results are not cached here.
+func (a *AuthManager) InvalidationHandler(w http.ResponseWriter, r *http.Request) {
+	var ev struct {
+		Version     int    `json:"version"`
+		Type        string `json:"type"`
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil || ev.Version != 1 {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	if ev.Type == "ssh" && ev.Fingerprint != "" {
+		a.InvalidateFingerprint(ev.Fingerprint)
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (a *AuthManager) Close(ctx context.Context) error {
@@ -119,3 +195,9 @@ func (a *AuthManager) IntrospectToken(token string) (string, bool, error) {
 	userId, active, err := webprofile.IntrospectToken(a.client, base, token)
 	return userId, active, err
 }
+
+// IsProjectMember checks project membership through the web-profile service
+// using the same signed client as lookups.
+func (a *AuthManager) IsProjectMember(projectId, userId string) (bool, error) {
+	return membership.IsMember(a.client, a.baseURL, projectId, userId)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -121,3 +122,88 @@ func TestTTLExpiry(t *testing.T) {
 		t.Fatalf("expected backend to be called again after TTL expiry, got %d", calls)
 	}
 }
+
+func TestInvalidationHandlerDropsCachedFingerprint(t *testing.T) {
+	var calls int32
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"userId":"u-1"}`))
+	}))
+	defer h.Close()
+
+	os.Setenv("SSH_LOOKUP_BASE_URL", h.URL)
+	am, err := NewAuthManagerFromEnv(h.Client())
+	if err != nil {
+		t.Fatalf("NewAuthManagerFromEnv error: %v", err)
+	}
+	ctx := context.Background()
+	defer am.Close(ctx)
+
+	am.LookupUserForFingerprint(ctx, "SHA256:AAA")
+	req := httptest.NewRequest("POST", "/internal/api/auth-cache/invalidate", strings.NewReader(`{"version":1,"type":"ssh","id":"k1","fingerprint":"SHA256:AAA","timestamp":"2025-01-01T00:00:00Z"}`))
+	rr := httptest.NewRecorder()
+	am.InvalidationHandler(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	am.LookupUserForFingerprint(ctx, "SHA256:AAA")
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expected lookup after invalidation to hit backend, got %d calls", calls)
+	}
+}
+
+func TestPositiveCacheCappedAtKeyExpiry(t *testing.T) {
+	var calls int32
+	exp := time.Now().Add(time.Second).UTC().Format(time.RFC3339Nano)
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"userId":"u-1","expiresAt":"` + exp + `"}`))
+	}))
+	defer h.Close()
+
+	os.Setenv("SSH_LOOKUP_BASE_URL", h.URL)
+	os.Setenv("CACHE_LOOKUP_TTL_SECONDS", "60")
+	am, err := NewAuthManagerFromEnv(h.Client())
+	if err != nil {
+		t.Fatalf("NewAuthManagerFromEnv error: %v", err)
+	}
+	ctx := context.Background()
+	defer am.Close(ctx)
+
+	am.LookupUserForFingerprint(ctx, "SHA256:DDD")
+	time.Sleep(1100 * time.Millisecond)
+	am.LookupUserForFingerprint(ctx, "SHA256:DDD")
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expected lookup after key expiry to hit backend, got %d calls", calls)
+	}
+}
+
+func TestReportKeyUsedIsThrottled(t *testing.T) {
+	var reports int32
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal/api/ssh-keys/usage" {
+			atomic.AddInt32(&reports, 1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer h.Close()
+
+	os.Setenv("SSH_LOOKUP_BASE_URL", h.URL)
+	os.Setenv("SSH_USAGE_REPORT_SECONDS", "300")
+	am, err := NewAuthManagerFromEnv(h.Client())
+	if err != nil {
+		t.Fatalf("NewAuthManagerFromEnv error: %v", err)
+	}
+	defer am.Close(context.Background())
+
+	am.ReportKeyUsed("SHA256:EEE", "192.0.2.1")
+	am.ReportKeyUsed("SHA256:EEE", "192.0.2.1")
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&reports) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&reports); n != 1 {
+		t.Fatalf("expected a single usage report, got %d", n)
+	}
+}
@@ -36,10 +36,11 @@ func NewServer(am *AuthManager, listenAddr string) *Server {
 				return false
 			}
 			user, err := am.LookupUserForFingerprint(context.Background(), fp)
-			if err != nil {
+			if err != nil || user == "" {
 				return false
 			}
-			return user != ""
+			am.ReportKeyUsed(fp, remoteIP(ctx.RemoteAddr()))
+			return true
 		},
 		Handler: func(ses gliderssh.Session) {
 			cmd := ses.Command()
@@ -101,6 +102,17 @@ func (s *Server) Stop(ctx context.Context) error {
 	return nil
 }
 
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
 // FingerprintFromPublicKey returns the canonical SHA256:<base64> fingerprint
 // for an `ssh.PublicKey` as used throughout the codebase.
 func FingerprintFromPublicKey(pk sshlib.PublicKey) string {
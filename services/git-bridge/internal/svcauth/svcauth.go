@@ -0,0 +1,287 @@
+// Package svcauth signs internal service-to-service HTTP requests with an
+// HMAC-SHA256 over the method, path, timestamp, nonce and body digest, and
+// verifies the requests other services sign the same way. The web-profile API
+// imports this package for both directions, and the Node web client
+// (WebProfileClient.mjs) builds the same canonical string.
+package svcauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderKeyID     = "X-Service-Key-Id"
+	HeaderTimestamp = "X-Service-Timestamp"
+	HeaderNonce     = "X-Service-Nonce"
+	HeaderSignature = "X-Service-Signature"
+)
+
+// CanonicalString builds the string that is signed:
+// METHOD \n escaped-path[?query] \n unix-timestamp \n nonce \n hex(sha256(body))
+func CanonicalString(method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+// Sign returns the lowercase hex HMAC-SHA256 of canonical under secret.
+func Sign(secret []byte, canonical string) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(canonical))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// SignRequest adds the signature headers to req, whose body is body. The
+// caller remains responsible for sending body with the request.
+func SignRequest(req *http.Request, keyID string, secret, body []byte) error {
+	n := make([]byte, 16)
+	if _, err := rand.Read(n); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(n)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		// the server sees "/" for a URL without a path
+		uri = "/"
+	}
+	if req.URL.RawQuery != "" {
+		uri += "?" + req.URL.RawQuery
+	}
+	req.Header.Set(HeaderKeyID, keyID)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign(secret, CanonicalString(req.Method, uri, ts, nonce, body)))
+	return nil
+}
+
+// Transport is an http.RoundTripper that signs every outgoing request.
+type Transport struct {
+	KeyID  string
+	Secret []byte
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	signed := req.Clone(req.Context())
+	if body != nil {
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+	if err := SignRequest(signed, t.KeyID, t.Secret, body); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// NewClientFromEnv returns an HTTP client for internal API calls configured by:
+// - SERVICE_AUTH_KEY_ID / SERVICE_AUTH_SECRET: HMAC signing key (both or neither)
+// - SERVICE_TLS_CERT / SERVICE_TLS_KEY: optional client certificate for mTLS
+// - SERVICE_TLS_CA: optional CA bundle used to verify the server
+// Without a signing key the client sends unsigned requests.
+func NewClientFromEnv() (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	certFile, keyFile := os.Getenv("SERVICE_TLS_CERT"), os.Getenv("SERVICE_TLS_KEY")
+	caFile := os.Getenv("SERVICE_TLS_CA")
+	if certFile != "" || caFile != "" {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("load client certificate: %w", err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("read CA bundle: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("no certificates in SERVICE_TLS_CA")
+			}
+			cfg.RootCAs = pool
+		}
+		base.TLSClientConfig = cfg
+	}
+	var rt http.RoundTripper = base
+	keyID, secret := os.Getenv("SERVICE_AUTH_KEY_ID"), os.Getenv("SERVICE_AUTH_SECRET")
+	if (keyID == "") != (secret == "") {
+		return nil, errors.New("SERVICE_AUTH_KEY_ID and SERVICE_AUTH_SECRET must be set together")
+	}
+	if keyID != "" {
+		rt = &Transport{KeyID: keyID, Secret: []byte(secret), Base: base}
+	}
+	return &http.Client{Timeout: 5 * time.Second, Transport: rt}, nil
+}
+
+var (
+	ErrMissingHeaders = errors.New("missing signature headers")
+	ErrUnknownKey     = errors.New("unknown key id")
+	ErrStaleTimestamp = errors.New("timestamp outside allowed skew")
+	ErrReplayedNonce  = errors.New("nonce already used")
+	ErrBadSignature   = errors.New("invalid signature")
+)
+
+// NonceStore remembers the nonces a Verifier has accepted. Use records key
+// until expires and returns ErrReplayedNonce when key is already recorded and
+// has not expired. The default store is in memory, so it only catches replays
+// sent to the same process; services that run several instances behind one
+// address should share a store between them.
+type NonceStore interface {
+	Use(ctx context.Context, key string, now, expires time.Time) error
+}
+
+// Verifier checks signed requests sent to git-bridge, such as the cache
+// invalidations pushed by the web-profile API, and to the web-profile API
+// itself. Several keys may be active at once so secrets can be rotated.
+type Verifier struct {
+	keys   map[string][]byte
+	skew   time.Duration
+	nonces NonceStore
+}
+
+// NewVerifier returns a verifier for spec, comma-separated keyId:secret
+// pairs, accepting timestamps within skew of its clock. With no keys every
+// request is rejected. Nonces are kept in memory; see WithNonceStore.
+func NewVerifier(spec string, skew time.Duration) *Verifier {
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(spec, ",") {
+		kid, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && kid != "" && secret != "" {
+			keys[kid] = []byte(secret)
+		}
+	}
+	return &Verifier{keys: keys, skew: skew, nonces: NewMemoryNonceStore()}
+}
+
+// WithNonceStore replaces the verifier's nonce store and returns v.
+func (v *Verifier) WithNonceStore(s NonceStore) *Verifier {
+	v.nonces = s
+	return v
+}
+
+// NewVerifierFromEnv returns a verifier configured by:
+// - SERVICE_AUTH_KEYS: comma-separated keyId:secret pairs
+// - SERVICE_AUTH_MAX_SKEW_SECONDS (default 300)
+func NewVerifierFromEnv() *Verifier {
+	skew := 300
+	if n, err := strconv.Atoi(os.Getenv("SERVICE_AUTH_MAX_SKEW_SECONDS")); err == nil && n > 0 {
+		skew = n
+	}
+	return NewVerifier(os.Getenv("SERVICE_AUTH_KEYS"), time.Duration(skew)*time.Second)
+}
+
+// Configured reports whether the verifier has any keys.
+func (v *Verifier) Configured() bool {
+	return len(v.keys) > 0
+}
+
+// Verify checks the signature headers on r. The body is read and restored so
+// handlers can decode it afterwards.
+func (v *Verifier) Verify(r *http.Request, now time.Time) error {
+	kid := r.Header.Get(HeaderKeyID)
+	ts := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	sig := r.Header.Get(HeaderSignature)
+	if kid == "" || ts == "" || nonce == "" || sig == "" {
+		return ErrMissingHeaders
+	}
+	secret, ok := v.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > v.skew || d < -v.skew {
+		return ErrStaleTimestamp
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+	if !hmac.Equal([]byte(Sign(secret, CanonicalString(r.Method, uri, ts, nonce, body))), []byte(sig)) {
+		return ErrBadSignature
+	}
+	// nonces older than the skew window fail the timestamp check anyway
+	return v.nonces.Use(r.Context(), kid+":"+nonce, now, now.Add(2*v.skew))
+}
+
+// MemoryNonceStore is a NonceStore for a single process.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+func (m *MemoryNonceStore) Use(ctx context.Context, key string, now, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if exp, ok := m.nonces[key]; ok && now.Before(exp) {
+		return ErrReplayedNonce
+	}
+	if len(m.nonces) > 10000 {
+		for k, exp := range m.nonces {
+			if now.After(exp) {
+				delete(m.nonces, k)
+			}
+		}
+	}
+	m.nonces[key] = expires
+	return nil
+}
+
+// Require wraps h so that only correctly signed requests reach it; others
+// get a 401.
+func (v *Verifier) Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r, time.Now()); err != nil {
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
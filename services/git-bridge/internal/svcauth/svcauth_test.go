@@ -0,0 +1,138 @@
+package svcauth
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTransportSignsRequest(t *testing.T) {
+	secret := []byte("s3cret")
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uri := r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			uri += "?" + r.URL.RawQuery
+		}
+		canonical := CanonicalString(r.Method, uri, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), body)
+		if r.Header.Get(HeaderKeyID) != "k1" || r.Header.Get(HeaderSignature) != Sign(secret, canonical) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if string(body) != `{"token":"tok"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer h.Close()
+
+	client := &http.Client{Transport: &Transport{KeyID: "k1", Secret: secret}}
+	resp, err := client.Post(h.URL+"/internal/api/tokens/introspect?x=1", "application/json", bytes.NewBufferString(`{"token":"tok"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected signed request to verify, got %d", resp.StatusCode)
+	}
+}
+
+func TestNewClientFromEnvRequiresKeyPair(t *testing.T) {
+	t.Setenv("SERVICE_AUTH_KEY_ID", "k1")
+	t.Setenv("SERVICE_AUTH_SECRET", "")
+	if _, err := NewClientFromEnv(); err == nil {
+		t.Fatalf("expected error when only key id is set")
+	}
+}
+
+func TestVerifierRequire(t *testing.T) {
+	v := NewVerifier("old:s1, k1:s3cret", time.Minute)
+	var reached int
+	h := httptest.NewServer(v.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"version":1}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reached++
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer h.Close()
+
+	post := func(client *http.Client) int {
+		resp, err := client.Post(h.URL+"/internal/api/auth-cache/invalidate", "application/json", bytes.NewBufferString(`{"version":1}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(&http.Client{Transport: &Transport{KeyID: "k1", Secret: []byte("s3cret")}}); code != http.StatusNoContent {
+		t.Fatalf("expected signed request to pass, got %d", code)
+	}
+	for name, client := range map[string]*http.Client{
+		"unsigned":    http.DefaultClient,
+		"wrong key":   {Transport: &Transport{KeyID: "k1", Secret: []byte("other")}},
+		"unknown kid": {Transport: &Transport{KeyID: "k2", Secret: []byte("s3cret")}},
+	} {
+		if code := post(client); code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, code)
+		}
+	}
+	if reached != 1 {
+		t.Fatalf("expected only the signed request to reach the handler, got %d", reached)
+	}
+}
+
+func TestVerifierRejectsReplayAndSkew(t *testing.T) {
+	v := NewVerifier("k1:s3cret", time.Minute)
+	req := httptest.NewRequest("POST", "/internal/api/auth-cache/invalidate", bytes.NewBufferString(`{}`))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderKeyID, "k1")
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderNonce, "n1")
+	req.Header.Set(HeaderSignature, Sign([]byte("s3cret"), CanonicalString("POST", "/internal/api/auth-cache/invalidate", ts, "n1", []byte(`{}`))))
+	if err := v.Verify(req, time.Now()); err != nil {
+		t.Fatalf("expected request to verify, got %v", err)
+	}
+	if err := v.Verify(req, time.Now()); err != ErrReplayedNonce {
+		t.Fatalf("expected ErrReplayedNonce, got %v", err)
+	}
+	if err := NewVerifier("k1:s3cret", time.Minute).Verify(req, time.Now().Add(2*time.Minute)); err != ErrStaleTimestamp {
+		t.Fatalf("expected ErrStaleTimestamp, got %v", err)
+	}
+	if NewVerifier("", time.Minute).Configured() {
+		t.Fatalf("expected a verifier without keys to be unconfigured")
+	}
+}
+
+func TestVerifiersSharingANonceStoreRejectCrossInstanceReplay(t *testing.T) {
+	shared := NewMemoryNonceStore()
+	a := NewVerifier("k1:s3cret", time.Minute).WithNonceStore(shared)
+	b := NewVerifier("k1:s3cret", time.Minute).WithNonceStore(shared)
+
+	req, _ := http.NewRequest("POST", "http://webprofile/internal/api/tokens/introspect", nil)
+	if err := SignRequest(req, "k1", []byte("s3cret"), []byte(`{}`)); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	send := func(v *Verifier) error {
+		r := req.Clone(req.Context())
+		r.Body = io.NopCloser(bytes.NewBufferString(`{}`))
+		return v.Verify(r, time.Now())
+	}
+	if err := send(a); err != nil {
+		t.Fatalf("expected first delivery to verify, got %v", err)
+	}
+	if err := send(b); err != ErrReplayedNonce {
+		t.Fatalf("expected replay to another instance to be rejected, got %v", err)
+	}
+	// without a shared store the second instance cannot tell
+	if err := send(NewVerifier("k1:s3cret", time.Minute)); err != nil {
+		t.Fatalf("expected a fresh in-memory verifier to accept, got %v", err)
+	}
+}
@@ -1,32 +1,122 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/davrot/gogotex_at_work/services/real_time/internal/api"
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/auth"
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/hub"
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/ws"
+)
+
+const (
+	// roomHistory is how many recent events a room keeps for clients that
+	// reconnect; roomIdle is how long it lives on after its last client.
+	roomHistory = 100
+	roomIdle    = 10 * time.Minute
 )
 
 func main() {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rooms := hub.New(roomHistory)
+
+	// Editor clients authenticate with tokens signed by one of
+	// REALTIME_TOKEN_KEYS ("kid:secret,..."); without keys nobody can.
+	keys, err := auth.ParseKeys(os.Getenv("REALTIME_TOKEN_KEYS"))
+	if err != nil {
+		log.Fatalf("REALTIME_TOKEN_KEYS: %v", err)
+	}
+	if len(keys) == 0 {
+		log.Printf("REALTIME_TOKEN_KEYS is not set: WebSocket clients cannot authenticate")
+	}
+	sockets := ws.NewServer(rooms, auth.NewVerifier(keys))
+	// Browsers may connect from the page's own host, or from any origin
+	// listed in REALTIME_ALLOWED_ORIGINS ("https://a.example,...").
+	if origins := os.Getenv("REALTIME_ALLOWED_ORIGINS"); origins != "" {
+		allowed := strings.Split(origins, ",")
+		sockets.Upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || slices.Contains(allowed, origin)
+		}
+	}
+	public, internal := newMuxes(rooms, sockets)
+
+	go func() {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				rooms.Sweep(roomIdle)
+			}
+		}
+	}()
+
+	// Publishing is for other services only, so it is served on a listener
+	// of its own, REALTIME_INTERNAL_ADDR, which must not be exposed to
+	// browsers.
+	internalAddr := os.Getenv("REALTIME_INTERNAL_ADDR")
+	if internalAddr == "" {
+		internalAddr = ":3001"
+	}
+	srv := &http.Server{Addr: ":3000", Handler: public}
+	internalSrv := &http.Server{Addr: internalAddr, Handler: internal}
+	for _, s := range []*http.Server{srv, internalSrv} {
+		go func() {
+			log.Printf("real-time service listening on %s", s.Addr)
+			if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("server error: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sockets.Shutdown(shutdownCtx); err != nil {
+		log.Printf("closing WebSocket clients: %v", err)
+	}
+	for _, s := range []*http.Server{srv, internalSrv} {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}
+}
+
+// newMuxes returns the public routes, /health and the WebSocket endpoint,
+// and the internal ones, /health and publishing to rooms.
+func newMuxes(rooms *hub.Hub, sockets http.Handler) (public, internal *http.ServeMux) {
+	health := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, `{"status":"ok"}`)
-	})
+	}
+	public = http.NewServeMux()
+	public.HandleFunc("/health", health)
+	public.Handle("/ws", sockets)
 
-	// Minimal API endpoints for parity tests
-	http.HandleFunc("/internal/api/pubsub/publish", func(w http.ResponseWriter, r *http.Request) {
+	internal = http.NewServeMux()
+	internal.HandleFunc("/health", health)
+	publish := api.PublishHandler(rooms)
+	internal.HandleFunc("/internal/api/pubsub/publish", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		api.PublishHandler(w, r)
+		publish(w, r)
 	})
-
-	addr := ":3000"
-	log.Printf("real-time service listening on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("server error: %v", err)
-	}
+	return public, internal
 }
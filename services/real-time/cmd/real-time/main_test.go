@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/hub"
+)
+
+func TestPublishIsOnlyServedInternally(t *testing.T) {
+	public, internal := newMuxes(hub.New(10), http.NotFoundHandler())
+	publish := func(mux *http.ServeMux) int {
+		rr := httptest.NewRecorder()
+		body := strings.NewReader(`{"topic":"editor-events:p1","msg":"{}"}`)
+		mux.ServeHTTP(rr, httptest.NewRequest("POST", "/internal/api/pubsub/publish", body))
+		return rr.Code
+	}
+	if code := publish(public); code != http.StatusNotFound {
+		t.Fatalf("public listener answered publish with %d", code)
+	}
+	if code := publish(internal); code != http.StatusOK {
+		t.Fatalf("internal listener answered publish with %d", code)
+	}
+	for _, mux := range []*http.ServeMux{public, internal} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("health answered %d", rr.Code)
+		}
+	}
+}
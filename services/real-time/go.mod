@@ -1,3 +1,5 @@
 module github.com/davrot/gogotex_at_work/services/real_time
 
 go 1.25
+
+require github.com/gorilla/websocket v1.5.3
@@ -3,6 +3,9 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
+
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/hub"
 )
 
 // PublishRequest represents a simple publish payload.
@@ -11,20 +14,33 @@ type PublishRequest struct {
 	Msg   string `json:"msg"`
 }
 
-// PublishHandler is a minimal handler that accepts a publish request and returns
-// a simple acknowledge response. This stub supports parity tests and basic
-// contract validation while the full real-time transport is implemented.
-func PublishHandler(w http.ResponseWriter, r *http.Request) {
-	var req PublishRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
-	}
-	if req.Topic == "" {
-		http.Error(w, "topic required", http.StatusBadRequest)
-		return
+// PublishHandler returns a handler that accepts a publish request and
+// delivers it to the WebSocket clients in the project's room of h. Topics
+// are "<channel>:<projectId>", such as "editor-events:<projectId>"; msg is
+// forwarded as JSON when it is JSON and as a string otherwise. Topics that
+// name no project are acknowledged and delivered to nobody. The response
+// reports how many clients received the message.
+func PublishHandler(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PublishRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if req.Topic == "" {
+			http.Error(w, "topic required", http.StatusBadRequest)
+			return
+		}
+		delivered := 0
+		if channel, projectID, ok := strings.Cut(req.Topic, ":"); ok && projectID != "" {
+			msg := json.RawMessage(req.Msg)
+			if !json.Valid(msg) {
+				msg, _ = json.Marshal(req.Msg)
+			}
+			delivered = h.Publish(projectID, channel, msg)
+		}
+		resp := map[string]any{"ok": true, "topic": req.Topic, "delivered": delivered}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
 	}
-	resp := map[string]any{"ok": true, "topic": req.Topic}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
 }
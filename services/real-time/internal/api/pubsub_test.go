@@ -2,16 +2,19 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/hub"
 )
 
 func TestPublishHandler_OK(t *testing.T) {
 	body := bytes.NewBufferString(`{"topic":"news","msg":"hello"}`)
 	req := httptest.NewRequest("POST", "/internal/api/pubsub/publish", body)
 	r := httptest.NewRecorder()
-	PublishHandler(r, req)
+	PublishHandler(hub.New(10))(r, req)
 	if r.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", r.Code)
 	}
@@ -21,7 +24,7 @@ func TestPublishHandler_BadJSON(t *testing.T) {
 	body := bytes.NewBufferString(`{invalid`)
 	req := httptest.NewRequest("POST", "/internal/api/pubsub/publish", body)
 	r := httptest.NewRecorder()
-	PublishHandler(r, req)
+	PublishHandler(hub.New(10))(r, req)
 	if r.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d", r.Code)
 	}
@@ -31,8 +34,46 @@ func TestPublishHandler_NoTopic(t *testing.T) {
 	body := bytes.NewBufferString(`{"msg":"hi"}`)
 	req := httptest.NewRequest("POST", "/internal/api/pubsub/publish", body)
 	r := httptest.NewRecorder()
-	PublishHandler(r, req)
+	PublishHandler(hub.New(10))(r, req)
 	if r.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d", r.Code)
 	}
 }
+
+type recorder struct{ events []hub.Event }
+
+func (r *recorder) Deliver(e hub.Event) { r.events = append(r.events, e) }
+
+func TestPublishHandler_Delivers(t *testing.T) {
+	h := hub.New(10)
+	sub := &recorder{}
+	h.Join("p1", sub, hub.Position{}, func(hub.Position, bool) {})
+
+	publish := func(body string) map[string]any {
+		r := httptest.NewRecorder()
+		PublishHandler(h)(r, httptest.NewRequest("POST", "/internal/api/pubsub/publish", bytes.NewBufferString(body)))
+		if r.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", body, r.Code)
+		}
+		var resp map[string]any
+		json.Unmarshal(r.Body.Bytes(), &resp)
+		return resp
+	}
+	if resp := publish(`{"topic":"editor-events:p1","msg":"{\"room_id\":\"p1\",\"message\":\"new-chat-message\",\"payload\":[]}"}`); resp["delivered"] != 1.0 {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	publish(`{"topic":"news:p1","msg":"hello"}`)
+	if resp := publish(`{"topic":"editor-events:p2","msg":"{}"}`); resp["delivered"] != 0.0 {
+		t.Fatalf("unexpected response %v", resp)
+	}
+
+	if len(sub.events) != 2 {
+		t.Fatalf("expected two events, got %+v", sub.events)
+	}
+	if e := sub.events[0]; e.Channel != "editor-events" || e.Seq != 1 || string(e.Message) != `{"room_id":"p1","message":"new-chat-message","payload":[]}` {
+		t.Fatalf("unexpected JSON event %+v", e)
+	}
+	if e := sub.events[1]; e.Channel != "news" || string(e.Message) != `"hello"` {
+		t.Fatalf("unexpected text event %+v", e)
+	}
+}
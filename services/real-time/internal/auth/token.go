@@ -0,0 +1,126 @@
+// Package auth verifies the tokens editor clients present on the WebSocket
+// endpoint. The web service issues them when a user opens a project, after
+// it has checked the user's access, so the real-time service never needs to
+// look up permissions itself.
+//
+// A token is
+//
+//	key-id "." base64url(claims JSON) "." hex(hmac-sha256(secret, key-id "." base64url(claims JSON)))
+//
+// where the claims are {"user_id", "project_ids", "exp"} and exp is a unix
+// timestamp in seconds. Several keys may be configured so that secrets can
+// be rotated: sign with the new one once every verifier knows it.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for malformed tokens, unknown keys and bad
+	// signatures.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpired is returned for a correctly signed token past its expiry.
+	ErrExpired = errors.New("token expired")
+)
+
+// Claims is what a token grants: the user it identifies and the projects
+// whose rooms they may join, until Expires.
+type Claims struct {
+	UserID     string   `json:"user_id"`
+	ProjectIDs []string `json:"project_ids"`
+	Expires    int64    `json:"exp"`
+}
+
+// Allows reports whether the claims grant access to projectID.
+func (c Claims) Allows(projectID string) bool {
+	return slices.Contains(c.ProjectIDs, projectID)
+}
+
+// Expired reports whether the claims have expired at now.
+func (c Claims) Expired(now time.Time) bool {
+	return now.Unix() >= c.Expires
+}
+
+// Sign returns a token for claims under the key keyID.
+func Sign(keyID string, secret []byte, c Claims) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := keyID + "." + base64.RawURLEncoding.EncodeToString(b)
+	return signed + "." + mac(secret, signed), nil
+}
+
+func mac(secret []byte, signed string) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(signed))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Verifier checks tokens against a set of keys.
+type Verifier struct {
+	keys map[string][]byte
+}
+
+// NewVerifier returns a verifier for keys, a map of key id to secret. With no
+// keys every token is rejected.
+func NewVerifier(keys map[string][]byte) *Verifier {
+	return &Verifier{keys: keys}
+}
+
+// ParseKeys parses a comma-separated "kid:secret" list, the format of
+// SERVICE_AUTH_KEYS elsewhere in the repo.
+func ParseKeys(s string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" || secret == "" || strings.Contains(kid, ".") {
+			return nil, fmt.Errorf("invalid key %q: want kid:secret", kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	return keys, nil
+}
+
+// Verify returns the claims of a token signed by one of the verifier's keys
+// and not expired at now.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidToken
+	}
+	secret, ok := v.keys[parts[0]]
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+	want := mac(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(want), []byte(parts[2])) {
+		return Claims{}, ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	if err := json.Unmarshal(b, &c); err != nil || c.UserID == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if c.Expired(now) {
+		return Claims{}, ErrExpired
+	}
+	return c, nil
+}
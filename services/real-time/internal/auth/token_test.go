@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := Claims{UserID: "u1", ProjectIDs: []string{"p1", "p2"}, Expires: now.Add(time.Minute).Unix()}
+	v := NewVerifier(map[string][]byte{"old": []byte("s1"), "new": []byte("s2")})
+
+	for _, kid := range []string{"old", "new"} {
+		secret := map[string]string{"old": "s1", "new": "s2"}[kid]
+		token, err := Sign(kid, []byte(secret), claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := v.Verify(token, now)
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		if got.UserID != "u1" || !got.Allows("p2") || got.Allows("p3") {
+			t.Fatalf("%s: unexpected claims %+v", kid, got)
+		}
+	}
+
+	token, _ := Sign("new", []byte("s2"), claims)
+	if _, err := v.Verify(token, now.Add(time.Minute)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged, _ := Sign("new", []byte("other"), Claims{UserID: "u2", ProjectIDs: []string{"p1"}, Expires: claims.Expires})
+	invalid := []string{
+		"",
+		"new.abc",
+		"unknown." + parts[1] + "." + parts[2],
+		"old." + parts[1] + "." + parts[2],
+		forged,
+		parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2],
+	}
+	for _, tok := range invalid {
+		if _, err := v.Verify(tok, now); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("%q: expected ErrInvalidToken, got %v", tok, err)
+		}
+	}
+
+	if _, err := NewVerifier(nil).Verify(token, now); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("no keys: expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(" k1:s1, k2:s:2 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || string(keys["k1"]) != "s1" || string(keys["k2"]) != "s:2" {
+		t.Fatalf("unexpected keys %q", keys)
+	}
+	for _, bad := range []string{"k1", "k1:", ":s1", "k.1:s1"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Fatalf("%q: expected an error", bad)
+		}
+	}
+}
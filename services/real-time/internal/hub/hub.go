@@ -0,0 +1,147 @@
+// Package hub fans published events out to the clients in a project's room.
+// Each room numbers its events and keeps the most recent ones, so a client
+// that reconnects can ask for what it missed instead of reloading.
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is one published message in a room. Seq counts up from 1 within the
+// room's stream.
+type Event struct {
+	ProjectID string
+	Channel   string
+	Seq       uint64
+	Message   json.RawMessage
+}
+
+// Position identifies a point in a room's history: the stream, which is new
+// whenever the room is recreated, and the last event seen in it.
+type Position struct {
+	Stream string
+	Seq    uint64
+}
+
+// Subscriber receives a room's events. Deliver is called with the hub locked
+// and must not block or call back into the hub.
+type Subscriber interface {
+	Deliver(e Event)
+}
+
+type room struct {
+	stream  string
+	seq     uint64
+	recent  []Event
+	subs    map[Subscriber]struct{}
+	touched time.Time
+}
+
+// Hub holds the rooms. The zero value is not usable; call New.
+type Hub struct {
+	history int
+	now     func() time.Time
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// New returns a hub keeping the last history events of each room.
+func New(history int) *Hub {
+	return &Hub{history: history, now: time.Now, rooms: make(map[string]*room)}
+}
+
+// Publish appends an event to a project's room and delivers it to the room's
+// subscribers, returning how many there were. Events for projects without a
+// room are dropped: nobody is there to receive or resume them.
+func (h *Hub) Publish(projectID, channel string, msg json.RawMessage) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[projectID]
+	if !ok {
+		return 0
+	}
+	r.seq++
+	e := Event{ProjectID: projectID, Channel: channel, Seq: r.seq, Message: msg}
+	r.recent = append(r.recent, e)
+	if len(r.recent) > h.history {
+		r.recent = append(r.recent[:0], r.recent[len(r.recent)-h.history:]...)
+	}
+	r.touched = h.now()
+	for s := range r.subs {
+		s.Deliver(e)
+	}
+	return len(r.subs)
+}
+
+// Join subscribes s to a project's room, creating the room if needed. If from
+// is a position in the room's current stream that is still covered by its
+// history, the events after it are delivered to s first. joined is called
+// before any event is delivered, with the room's latest position and whether
+// the client must resync because from could not be resumed. A zero from is a
+// fresh join and never needs a resync.
+func (h *Hub) Join(projectID string, s Subscriber, from Position, joined func(latest Position, resync bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[projectID]
+	if !ok {
+		r = &room{stream: newStream(), subs: make(map[Subscriber]struct{})}
+		h.rooms[projectID] = r
+	}
+	var missed []Event
+	resync := false
+	if from != (Position{}) {
+		oldest := r.seq + 1 - uint64(len(r.recent))
+		switch {
+		case from.Stream != r.stream, from.Seq > r.seq, from.Seq+1 < oldest:
+			resync = true
+		default:
+			missed = r.recent[len(r.recent)-int(r.seq-from.Seq):]
+		}
+	}
+	joined(Position{Stream: r.stream, Seq: r.seq}, resync)
+	for _, e := range missed {
+		s.Deliver(e)
+	}
+	r.subs[s] = struct{}{}
+	r.touched = h.now()
+}
+
+// Leave unsubscribes s from a project's room.
+func (h *Hub) Leave(projectID string, s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r, ok := h.rooms[projectID]; ok {
+		if _, ok := r.subs[s]; ok {
+			delete(r.subs, s)
+			r.touched = h.now()
+		}
+	}
+}
+
+// Sweep removes rooms that have had no subscribers and no events for longer
+// than idle, and returns how many it removed. Clients rejoining a removed
+// room get a new stream and resync.
+func (h *Hub) Sweep(idle time.Duration) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cutoff := h.now().Add(-idle)
+	n := 0
+	for pid, r := range h.rooms {
+		if len(r.subs) == 0 && r.touched.Before(cutoff) {
+			delete(h.rooms, pid)
+			n++
+		}
+	}
+	return n
+}
+
+func newStream() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
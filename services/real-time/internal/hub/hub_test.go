@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type recorder struct{ seqs []uint64 }
+
+func (r *recorder) Deliver(e Event) { r.seqs = append(r.seqs, e.Seq) }
+
+func join(h *Hub, pid string, s Subscriber, from Position) (Position, bool) {
+	var latest Position
+	var resync bool
+	h.Join(pid, s, from, func(p Position, r bool) { latest, resync = p, r })
+	return latest, resync
+}
+
+func publish(h *Hub, pid string, n int) {
+	for i := 0; i < n; i++ {
+		h.Publish(pid, "editor-events", json.RawMessage(`{}`))
+	}
+}
+
+func TestPublishAndResume(t *testing.T) {
+	h := New(3)
+	if n := h.Publish("p1", "editor-events", json.RawMessage(`{}`)); n != 0 {
+		t.Fatalf("published to a missing room: %d", n)
+	}
+
+	a := &recorder{}
+	start, resync := join(h, "p1", a, Position{})
+	if start.Stream == "" || start.Seq != 0 || resync {
+		t.Fatalf("unexpected fresh join %+v %v", start, resync)
+	}
+	publish(h, "p1", 2)
+	if n := h.Publish("p2", "editor-events", json.RawMessage(`{}`)); n != 0 {
+		t.Fatalf("published to another room: %d", n)
+	}
+	if len(a.seqs) != 2 || a.seqs[0] != 1 || a.seqs[1] != 2 {
+		t.Fatalf("unexpected deliveries %v", a.seqs)
+	}
+
+	h.Leave("p1", a)
+	publish(h, "p1", 2)
+	if len(a.seqs) != 2 {
+		t.Fatalf("delivered after leaving: %v", a.seqs)
+	}
+
+	// resume from seq 2: events 3 and 4 are replayed
+	b := &recorder{}
+	latest, resync := join(h, "p1", b, Position{Stream: start.Stream, Seq: 2})
+	if latest.Seq != 4 || resync || len(b.seqs) != 2 || b.seqs[0] != 3 || b.seqs[1] != 4 {
+		t.Fatalf("unexpected resume %+v %v %v", latest, resync, b.seqs)
+	}
+
+	// with history 3, seq 1 is the oldest position that can be resumed
+	c := &recorder{}
+	if _, resync := join(h, "p1", c, Position{Stream: start.Stream, Seq: 1}); resync || len(c.seqs) != 3 {
+		t.Fatalf("resume from 1: %v %v", resync, c.seqs)
+	}
+	for _, from := range []Position{
+		{Stream: start.Stream, Seq: 0},
+		{Stream: start.Stream, Seq: 5},
+		{Stream: "other", Seq: 4},
+	} {
+		d := &recorder{}
+		if _, resync := join(h, "p1", d, from); !resync || len(d.seqs) != 0 {
+			t.Fatalf("from %+v: expected a resync, got %v %v", from, resync, d.seqs)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	h := New(10)
+	now := time.Unix(1700000000, 0)
+	h.now = func() time.Time { return now }
+
+	a, b := &recorder{}, &recorder{}
+	first, _ := join(h, "p1", a, Position{})
+	join(h, "p2", b, Position{})
+	h.Leave("p1", a)
+
+	now = now.Add(time.Hour)
+	if n := h.Sweep(time.Minute); n != 1 {
+		t.Fatalf("expected one room swept, got %d", n)
+	}
+	// p2 still has a subscriber
+	if n := h.Publish("p2", "editor-events", json.RawMessage(`{}`)); n != 1 {
+		t.Fatalf("expected p2 to survive, got %d", n)
+	}
+	latest, resync := join(h, "p1", a, first)
+	if latest.Stream == first.Stream || !resync {
+		t.Fatalf("expected a new stream and a resync, got %+v %v", latest, resync)
+	}
+}
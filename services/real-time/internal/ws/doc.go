@@ -0,0 +1,69 @@
+// Package ws serves the WebSocket endpoint editor clients connect to.
+//
+// # Framing
+//
+// Every frame is a WebSocket text message holding one JSON object with a
+// "type". Client frames may carry an "id" of any JSON value; the server's
+// reply echoes it, so clients can match replies to requests. Frames of
+// unknown type are answered with an error, not a disconnect.
+//
+// Client to server:
+//
+//	{"type": "auth", "id", "token"}
+//	{"type": "join", "id", "project_id", "stream"?, "seq"?}
+//	{"type": "leave", "id", "project_id"}
+//	{"type": "ping", "id"}
+//
+// Server to client:
+//
+//	{"type": "authenticated", "id", "user_id", "project_ids"}
+//	{"type": "joined", "id", "project_id", "stream", "seq", "resync"?}
+//	{"type": "left", "id", "project_id"}
+//	{"type": "pong", "id"}
+//	{"type": "event", "project_id", "seq", "channel", "message"}
+//	{"type": "error", "id", "error"}
+//
+// # Authentication
+//
+// The first frame must be "auth", within the auth timeout, with a token from
+// the web service (see package auth). The token names the user and the
+// projects they may join. A missing or rejected token closes the connection
+// with code 4001; a client should fetch a new token before reconnecting. A
+// later "auth" frame replaces the token, so a long-lived connection can
+// renew it before it expires; rooms the new token does not grant are left,
+// each reported by a "left" frame without an id. A connection whose token
+// expires without renewal is closed with 4001.
+//
+// # Rooms and events
+//
+// "join" subscribes the connection to a project's room, if the token allows
+// it ("error": "forbidden" otherwise). Messages published on topic
+// "<channel>:<projectId>" are sent to the room as "event" frames: channel is
+// the topic prefix, such as "editor-events", and message is the published
+// msg, inline when it is JSON and as a string otherwise. For editor-events
+// it is {"room_id", "message", "payload"}, the event name and its arguments.
+//
+// Events in a room are numbered by "seq" within a "stream". "joined" reports
+// the room's current stream and the seq of its latest event.
+//
+// # Reconnecting
+//
+// A client that loses its connection reconnects, authenticates and joins
+// again, passing the stream and the last seq it received. If the room still
+// holds the events after that seq, "joined" is followed by those events, so
+// nothing is lost. Otherwise "joined" has "resync": true and the client
+// should reload the project's state. Rooms keep a bounded number of recent
+// events and are dropped a while after their last client leaves.
+//
+// Clients should reconnect with backoff and jitter. The server closes
+// connections with 1012 (service restart) when it shuts down and 1013 (try
+// again later) when a client falls too far behind; both are worth a prompt
+// reconnect. 4001 is not, until a new token has been fetched.
+//
+// # Keepalive
+//
+// The server sends a WebSocket ping every ping interval and closes the
+// connection if no frame or pong arrives within the pong wait. Browsers
+// answer pings automatically. Clients that want to detect a dead connection
+// themselves can send "ping" frames and expect a "pong".
+package ws
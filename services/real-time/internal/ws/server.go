@@ -0,0 +1,399 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/auth"
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/hub"
+)
+
+// Close codes beyond the WebSocket standard ones.
+const (
+	// CloseUnauthorized is sent when the client does not authenticate in
+	// time, its token is rejected, or its token expires.
+	CloseUnauthorized = 4001
+)
+
+const (
+	writeWait     = 10 * time.Second
+	closeGrace    = time.Second
+	maxFrameBytes = 64 << 10
+)
+
+// Server is the WebSocket endpoint. Set its fields before serving.
+type Server struct {
+	// PingInterval is how often the server pings; PongWait is how long it
+	// waits for any frame or pong before giving up on the connection.
+	PingInterval time.Duration
+	PongWait     time.Duration
+	// AuthTimeout is how long a new connection has to send "auth".
+	AuthTimeout time.Duration
+	// SendBuffer is the number of frames queued for a client before it is
+	// disconnected as too slow.
+	SendBuffer int
+	Upgrader   websocket.Upgrader
+
+	hub      *hub.Hub
+	verifier *auth.Verifier
+	now      func() time.Time
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}
+	shutdown bool
+}
+
+// NewServer returns a server joining clients to rooms in h, authenticated by
+// v, with default timeouts.
+func NewServer(h *hub.Hub, v *auth.Verifier) *Server {
+	return &Server{
+		PingInterval: 25 * time.Second,
+		PongWait:     60 * time.Second,
+		AuthTimeout:  10 * time.Second,
+		SendBuffer:   256,
+		hub:          h,
+		verifier:     v,
+		now:          time.Now,
+		conns:        make(map[*conn]struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request and serves the connection until it closes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error.
+		return
+	}
+	c := &conn{
+		srv:      s,
+		ws:       ws,
+		send:     make(chan []byte, s.SendBuffer),
+		done:     make(chan struct{}),
+		closeMsg: make(chan []byte, 1),
+		rooms:    make(map[string]bool),
+	}
+	s.mu.Lock()
+	shutdown := s.shutdown
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	if shutdown {
+		c.close(websocket.CloseServiceRestart, "service restart")
+	}
+
+	go c.writeLoop()
+	c.readLoop()
+
+	if c.expiry != nil {
+		c.expiry.Stop()
+	}
+	for pid := range c.rooms {
+		s.hub.Leave(pid, c)
+	}
+	close(c.done)
+	ws.Close()
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+}
+
+// Shutdown asks every client to reconnect, to another instance or once this
+// one is back, and refuses new connections the same way. It waits until the
+// connections have closed or ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	for c := range s.conns {
+		c.close(websocket.CloseServiceRestart, "service restart")
+	}
+	s.mu.Unlock()
+
+	t := time.NewTicker(50 * time.Millisecond)
+	defer t.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// clientFrame is any frame a client sends; see the package documentation.
+type clientFrame struct {
+	Type      string          `json:"type"`
+	ID        json.RawMessage `json:"id,omitempty"`
+	Token     string          `json:"token"`
+	ProjectID string          `json:"project_id"`
+	Stream    string          `json:"stream"`
+	Seq       uint64          `json:"seq"`
+}
+
+// replyFrame is an "authenticated", "left", "pong" or "error" frame.
+type replyFrame struct {
+	Type       string          `json:"type"`
+	ID         json.RawMessage `json:"id,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	UserID     string          `json:"user_id,omitempty"`
+	ProjectIDs []string        `json:"project_ids,omitempty"`
+	ProjectID  string          `json:"project_id,omitempty"`
+}
+
+type joinedFrame struct {
+	Type      string          `json:"type"`
+	ID        json.RawMessage `json:"id,omitempty"`
+	ProjectID string          `json:"project_id"`
+	Stream    string          `json:"stream"`
+	Seq       uint64          `json:"seq"`
+	Resync    bool            `json:"resync,omitempty"`
+}
+
+type eventFrame struct {
+	Type      string          `json:"type"`
+	ProjectID string          `json:"project_id"`
+	Seq       uint64          `json:"seq"`
+	Channel   string          `json:"channel"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// conn is one client connection. Only the read loop touches claims, expiry
+// and rooms; only the write loop writes data frames.
+type conn struct {
+	srv  *Server
+	ws   *websocket.Conn
+	send chan []byte
+	done chan struct{}
+
+	closing   atomic.Bool
+	closeOnce sync.Once
+	closeMsg  chan []byte
+
+	claims *auth.Claims
+	expiry *time.Timer
+	rooms  map[string]bool
+}
+
+// Deliver queues a room event. It implements hub.Subscriber.
+func (c *conn) Deliver(e hub.Event) {
+	c.enqueue(eventFrame{Type: "event", ProjectID: e.ProjectID, Seq: e.Seq, Channel: e.Channel, Message: e.Message})
+}
+
+// enqueue queues a frame without blocking. A client whose queue is full is
+// disconnected: it can reconnect and resume from the last event it got.
+func (c *conn) enqueue(v interface{}) {
+	if c.closing.Load() {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("ws: encode frame: %v", err)
+		return
+	}
+	select {
+	case c.send <- b:
+	case <-c.done:
+	default:
+		go c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// close has the write loop send a close frame, after the frames already
+// queued unless the client is too slow for them, and drops the connection
+// once the client has answered or after a grace period. It is safe to call
+// from any goroutine.
+func (c *conn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closing.Store(true)
+		if code == websocket.CloseTryAgainLater {
+			c.drain()
+		}
+		c.closeMsg <- websocket.FormatCloseMessage(code, reason)
+	})
+}
+
+// drain discards the queued frames.
+func (c *conn) drain() {
+	for {
+		select {
+		case <-c.send:
+		default:
+			return
+		}
+	}
+}
+
+func (c *conn) writeLoop() {
+	ping := time.NewTicker(c.srv.PingInterval)
+	defer ping.Stop()
+	write := func(b []byte) bool {
+		c.ws.SetWriteDeadline(c.srv.now().Add(writeWait))
+		if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+			c.ws.Close()
+			return false
+		}
+		return true
+	}
+	for {
+		select {
+		case b := <-c.send:
+			if !write(b) {
+				return
+			}
+		case msg := <-c.closeMsg:
+			for len(c.send) > 0 {
+				if !write(<-c.send) {
+					return
+				}
+			}
+			_ = c.ws.WriteControl(websocket.CloseMessage, msg, c.srv.now().Add(writeWait))
+			time.AfterFunc(closeGrace, func() { c.ws.Close() })
+			return
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, c.srv.now().Add(writeWait)); err != nil {
+				c.ws.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) readLoop() {
+	c.ws.SetReadLimit(maxFrameBytes)
+	c.ws.SetReadDeadline(c.srv.now().Add(c.srv.AuthTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		c.extendDeadline()
+		return nil
+	})
+	for {
+		mt, data, err := c.ws.ReadMessage()
+		if err != nil {
+			var ne net.Error
+			if c.claims == nil && errors.As(err, &ne) && ne.Timeout() {
+				// Tell a client that never authenticated why it is dropped.
+				msg := websocket.FormatCloseMessage(CloseUnauthorized, "authentication required")
+				_ = c.ws.WriteControl(websocket.CloseMessage, msg, c.srv.now().Add(writeWait))
+			}
+			return
+		}
+		if c.closing.Load() {
+			// Draining until the client answers the close frame.
+			continue
+		}
+		c.extendDeadline()
+		if mt != websocket.TextMessage {
+			c.close(websocket.CloseUnsupportedData, "text frames only")
+			continue
+		}
+		var f clientFrame
+		if err := json.Unmarshal(data, &f); err != nil {
+			c.enqueue(replyFrame{Type: "error", Error: "invalid frame"})
+			continue
+		}
+		c.handle(f)
+	}
+}
+
+// extendDeadline gives an authenticated client another PongWait to send
+// something. Before authentication only AuthTimeout applies.
+func (c *conn) extendDeadline() {
+	if c.claims != nil && !c.closing.Load() {
+		c.ws.SetReadDeadline(c.srv.now().Add(c.srv.PongWait))
+	}
+}
+
+func (c *conn) handle(f clientFrame) {
+	if f.Type == "ping" {
+		c.enqueue(replyFrame{Type: "pong", ID: f.ID})
+		return
+	}
+	if f.Type == "auth" {
+		c.authenticate(f)
+		return
+	}
+	if c.claims == nil {
+		c.enqueue(replyFrame{Type: "error", ID: f.ID, Error: "not authenticated"})
+		c.close(CloseUnauthorized, "authentication required")
+		return
+	}
+	switch f.Type {
+	case "join":
+		c.join(f)
+	case "leave":
+		if c.rooms[f.ProjectID] {
+			c.srv.hub.Leave(f.ProjectID, c)
+			delete(c.rooms, f.ProjectID)
+		}
+		c.enqueue(replyFrame{Type: "left", ID: f.ID, ProjectID: f.ProjectID})
+	default:
+		c.enqueue(replyFrame{Type: "error", ID: f.ID, Error: "unknown type"})
+	}
+}
+
+func (c *conn) authenticate(f clientFrame) {
+	claims, err := c.srv.verifier.Verify(f.Token, c.srv.now())
+	if err == nil && c.claims != nil && claims.UserID != c.claims.UserID {
+		err = errors.New("token is for another user")
+	}
+	if err != nil {
+		c.enqueue(replyFrame{Type: "error", ID: f.ID, Error: err.Error()})
+		c.close(CloseUnauthorized, err.Error())
+		return
+	}
+	c.claims = &claims
+	c.extendDeadline()
+	// The connection lasts as long as its token; a renewal restarts the clock.
+	if c.expiry != nil {
+		c.expiry.Stop()
+	}
+	c.expiry = time.AfterFunc(time.Unix(claims.Expires, 0).Sub(c.srv.now()), func() {
+		c.close(CloseUnauthorized, auth.ErrExpired.Error())
+	})
+	// A renewed token may grant fewer projects.
+	for pid := range c.rooms {
+		if !claims.Allows(pid) {
+			c.srv.hub.Leave(pid, c)
+			delete(c.rooms, pid)
+			c.enqueue(replyFrame{Type: "left", ProjectID: pid})
+		}
+	}
+	c.enqueue(replyFrame{Type: "authenticated", ID: f.ID, UserID: claims.UserID, ProjectIDs: claims.ProjectIDs})
+}
+
+func (c *conn) join(f clientFrame) {
+	switch {
+	case f.ProjectID == "":
+		c.enqueue(replyFrame{Type: "error", ID: f.ID, Error: "project_id required"})
+		return
+	case c.claims.Expired(c.srv.now()):
+		c.enqueue(replyFrame{Type: "error", ID: f.ID, Error: auth.ErrExpired.Error()})
+		return
+	case !c.claims.Allows(f.ProjectID):
+		c.enqueue(replyFrame{Type: "error", ID: f.ID, Error: "forbidden"})
+		return
+	}
+	if c.rooms[f.ProjectID] {
+		c.srv.hub.Leave(f.ProjectID, c)
+	}
+	from := hub.Position{Stream: f.Stream, Seq: f.Seq}
+	c.srv.hub.Join(f.ProjectID, c, from, func(latest hub.Position, resync bool) {
+		c.enqueue(joinedFrame{Type: "joined", ID: f.ID, ProjectID: f.ProjectID, Stream: latest.Stream, Seq: latest.Seq, Resync: resync})
+	})
+	c.rooms[f.ProjectID] = true
+}
@@ -0,0 +1,372 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/api"
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/auth"
+	"github.com/davrot/gogotex_at_work/services/real_time/internal/hub"
+)
+
+var testSecret = []byte("test-secret")
+
+// testServer serves the WebSocket endpoint and the publish endpoint, as
+// main does.
+type testServer struct {
+	*httptest.Server
+	sockets *Server
+}
+
+func newTestServer(t *testing.T, configure func(*Server)) *testServer {
+	t.Helper()
+	rooms := hub.New(10)
+	sockets := NewServer(rooms, auth.NewVerifier(map[string][]byte{"k1": testSecret}))
+	if configure != nil {
+		configure(sockets)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws", sockets)
+	mux.HandleFunc("POST /internal/api/pubsub/publish", api.PublishHandler(rooms))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, sockets: sockets}
+}
+
+func (s *testServer) publish(t *testing.T, topic, msg string) {
+	t.Helper()
+	body, _ := json.Marshal(api.PublishRequest{Topic: topic, Msg: msg})
+	resp, err := http.Post(s.URL+"/internal/api/pubsub/publish", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("publish %s: %d", topic, resp.StatusCode)
+	}
+}
+
+func token(t *testing.T, user string, projects ...string) string {
+	t.Helper()
+	tok, err := auth.Sign("k1", testSecret, auth.Claims{UserID: user, ProjectIDs: projects, Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// client is a WebSocket client that reads frames in the background, so that
+// it answers the server's pings like a browser does.
+type client struct {
+	t      *testing.T
+	ws     *websocket.Conn
+	frames chan map[string]interface{}
+	closed chan error
+}
+
+func (s *testServer) dial(t *testing.T, configure func(*websocket.Conn)) *client {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	if configure != nil {
+		configure(ws)
+	}
+	c := &client{t: t, ws: ws, frames: make(chan map[string]interface{}, 100), closed: make(chan error, 1)}
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				c.closed <- err
+				return
+			}
+			var f map[string]interface{}
+			if err := json.Unmarshal(data, &f); err != nil {
+				c.closed <- err
+				return
+			}
+			c.frames <- f
+		}
+	}()
+	return c
+}
+
+func (c *client) send(frame string) {
+	c.t.Helper()
+	if err := c.ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		c.t.Fatalf("send %s: %v", frame, err)
+	}
+}
+
+// next returns the next frame, which must have type want.
+func (c *client) next(want string) map[string]interface{} {
+	c.t.Helper()
+	select {
+	case f := <-c.frames:
+		if f["type"] != want {
+			c.t.Fatalf("expected a %q frame, got %v", want, f)
+		}
+		return f
+	case err := <-c.closed:
+		c.t.Fatalf("expected a %q frame, connection closed: %v", want, err)
+	case <-time.After(2 * time.Second):
+		c.t.Fatalf("expected a %q frame, got nothing", want)
+	}
+	return nil
+}
+
+// expectClose waits for the connection to be closed with code, or dropped
+// when code is websocket.CloseAbnormalClosure.
+func (c *client) expectClose(code int) {
+	c.t.Helper()
+	select {
+	case f := <-c.frames:
+		c.t.Fatalf("expected close %d, got frame %v", code, f)
+	case err := <-c.closed:
+		if !websocket.IsCloseError(err, code) {
+			c.t.Fatalf("expected close %d, got %v", code, err)
+		}
+	case <-time.After(2 * time.Second):
+		c.t.Fatalf("expected close %d, connection still open", code)
+	}
+}
+
+// auth authenticates and joins the given projects, returning the joined
+// frames.
+func (c *client) auth(tok string, projects ...string) []map[string]interface{} {
+	c.t.Helper()
+	c.send(`{"type":"auth","id":"a","token":"` + tok + `"}`)
+	c.next("authenticated")
+	var joined []map[string]interface{}
+	for _, pid := range projects {
+		c.send(`{"type":"join","project_id":"` + pid + `"}`)
+		joined = append(joined, c.next("joined"))
+	}
+	return joined
+}
+
+func TestRoomsAndEvents(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := s.dial(t, nil)
+
+	c.send(`{"type":"ping","id":1}`)
+	if f := c.next("pong"); f["id"] != 1.0 {
+		t.Fatalf("pong does not echo the id: %v", f)
+	}
+	c.send(`{"type":"auth","id":"a","token":"` + token(t, "u1", "p1", "p2") + `"}`)
+	if f := c.next("authenticated"); f["id"] != "a" || f["user_id"] != "u1" {
+		t.Fatalf("unexpected authenticated frame %v", f)
+	}
+	c.send(`{"type":"join","id":2,"project_id":"p3"}`)
+	if f := c.next("error"); f["id"] != 2.0 || f["error"] != "forbidden" {
+		t.Fatalf("unexpected error frame %v", f)
+	}
+	c.send(`{"type":"join","id":3,"project_id":"p1"}`)
+	joined := c.next("joined")
+	if joined["id"] != 3.0 || joined["project_id"] != "p1" || joined["stream"] == "" || joined["seq"] != 0.0 {
+		t.Fatalf("unexpected joined frame %v", joined)
+	}
+	c.send(`{"type":"join","project_id":"p2"}`)
+	c.next("joined")
+	c.send(`{"type":"frobnicate","id":4}`)
+	if f := c.next("error"); f["error"] != "unknown type" {
+		t.Fatalf("unexpected error frame %v", f)
+	}
+	c.send(`not json`)
+	if f := c.next("error"); f["error"] != "invalid frame" {
+		t.Fatalf("unexpected error frame %v", f)
+	}
+
+	// the chat service's editor-events, inline as JSON
+	chat := `{"room_id":"p1","message":"new-chat-message","payload":[{"content":"hi"}]}`
+	s.publish(t, "editor-events:p1", chat)
+	s.publish(t, "editor-events:p9", `{}`)
+	s.publish(t, "editor-events:p2", "not json")
+	e := c.next("event")
+	if e["project_id"] != "p1" || e["seq"] != 1.0 || e["channel"] != "editor-events" {
+		t.Fatalf("unexpected event %v", e)
+	}
+	if m, _ := e["message"].(map[string]interface{}); m["room_id"] != "p1" || m["message"] != "new-chat-message" {
+		t.Fatalf("expected message %s, got %v", chat, e["message"])
+	}
+	if e := c.next("event"); e["project_id"] != "p2" || e["message"] != "not json" {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	c.send(`{"type":"leave","id":5,"project_id":"p1"}`)
+	if f := c.next("left"); f["project_id"] != "p1" {
+		t.Fatalf("unexpected left frame %v", f)
+	}
+	s.publish(t, "editor-events:p1", `{}`)
+	// a renewed token without p2 leaves that room too
+	c.send(`{"type":"auth","token":"` + token(t, "u1", "p1") + `"}`)
+	if f := c.next("left"); f["project_id"] != "p2" {
+		t.Fatalf("unexpected left frame %v", f)
+	}
+	c.next("authenticated")
+	s.publish(t, "editor-events:p2", `{}`)
+	c.send(`{"type":"ping"}`)
+	c.next("pong")
+
+	c.send(`{"type":"auth","token":"` + token(t, "u2", "p1") + `"}`)
+	if f := c.next("error"); f["error"] != "token is for another user" {
+		t.Fatalf("unexpected error frame %v", f)
+	}
+	c.expectClose(CloseUnauthorized)
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t, func(srv *Server) { srv.AuthTimeout = 200 * time.Millisecond })
+
+	c := s.dial(t, nil)
+	c.send(`{"type":"join","project_id":"p1"}`)
+	if f := c.next("error"); f["error"] != "not authenticated" {
+		t.Fatalf("unexpected error frame %v", f)
+	}
+	c.expectClose(CloseUnauthorized)
+
+	forged, _ := auth.Sign("k1", []byte("wrong"), auth.Claims{UserID: "u1", ProjectIDs: []string{"p1"}, Expires: time.Now().Add(time.Hour).Unix()})
+	expired, _ := auth.Sign("k1", testSecret, auth.Claims{UserID: "u1", ProjectIDs: []string{"p1"}, Expires: time.Now().Add(-time.Minute).Unix()})
+	for tok, want := range map[string]string{forged: "invalid token", expired: "token expired", "": "invalid token"} {
+		c := s.dial(t, nil)
+		c.send(`{"type":"auth","token":"` + tok + `"}`)
+		if f := c.next("error"); f["error"] != want {
+			t.Fatalf("expected %q, got %v", want, f)
+		}
+		c.expectClose(CloseUnauthorized)
+	}
+
+	// a client that never authenticates is dropped after AuthTimeout
+	c = s.dial(t, nil)
+	c.expectClose(CloseUnauthorized)
+}
+
+func TestTokenExpiryClosesTheConnection(t *testing.T) {
+	var mu sync.Mutex
+	var clock time.Time
+	s := newTestServer(t, func(srv *Server) {
+		srv.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return clock
+		}
+	})
+	// expiresIn returns a token and sets the server clock to d before its
+	// expiry
+	exp := time.Now().Add(time.Hour).Unix()
+	expiresIn := func(d time.Duration) string {
+		tok, err := auth.Sign("k1", testSecret, auth.Claims{UserID: "u1", ProjectIDs: []string{"p1"}, Expires: exp})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		clock = time.Unix(exp, 0).Add(-d)
+		mu.Unlock()
+		return tok
+	}
+
+	tok := expiresIn(300 * time.Millisecond)
+	c := s.dial(t, nil)
+	c.auth(tok, "p1")
+	// a renewal restarts the clock
+	time.Sleep(150 * time.Millisecond)
+	c.send(`{"type":"auth","token":"` + expiresIn(time.Second) + `"}`)
+	c.next("authenticated")
+	time.Sleep(300 * time.Millisecond)
+	c.send(`{"type":"ping"}`)
+	c.next("pong")
+	c.expectClose(CloseUnauthorized)
+}
+
+func TestReconnectResumes(t *testing.T) {
+	s := newTestServer(t, nil)
+	tok := token(t, "u1", "p1")
+
+	c := s.dial(t, nil)
+	joined := c.auth(tok, "p1")[0]
+	stream := joined["stream"].(string)
+	s.publish(t, "editor-events:p1", `{"n":1}`)
+	c.next("event")
+	c.ws.Close()
+
+	s.publish(t, "editor-events:p1", `{"n":2}`)
+	s.publish(t, "editor-events:p1", `{"n":3}`)
+
+	// resuming from seq 1 replays 2 and 3 after the joined frame
+	c = s.dial(t, nil)
+	c.auth(tok)
+	c.send(`{"type":"join","project_id":"p1","stream":"` + stream + `","seq":1}`)
+	if f := c.next("joined"); f["seq"] != 3.0 || f["stream"] != stream || f["resync"] != nil {
+		t.Fatalf("unexpected joined frame %v", f)
+	}
+	for _, want := range []float64{2, 3} {
+		if e := c.next("event"); e["seq"] != want {
+			t.Fatalf("expected event %v, got %v", want, e)
+		}
+	}
+	s.publish(t, "editor-events:p1", `{"n":4}`)
+	if e := c.next("event"); e["seq"] != 4.0 {
+		t.Fatalf("expected event 4, got %v", e)
+	}
+
+	// a position from another stream cannot be resumed
+	other := s.dial(t, nil)
+	other.auth(tok)
+	other.send(`{"type":"join","project_id":"p1","stream":"0000","seq":1}`)
+	if f := other.next("joined"); f["resync"] != true || f["seq"] != 4.0 {
+		t.Fatalf("expected a resync, got %v", f)
+	}
+	other.send(`{"type":"ping"}`)
+	other.next("pong")
+}
+
+func TestKeepalive(t *testing.T) {
+	s := newTestServer(t, func(srv *Server) {
+		srv.PingInterval = 50 * time.Millisecond
+		srv.PongWait = 300 * time.Millisecond
+	})
+	tok := token(t, "u1", "p1")
+
+	// a client answering pings stays connected while idle
+	alive := s.dial(t, nil)
+	alive.auth(tok, "p1")
+	// a client that has stopped answering is dropped
+	dead := s.dial(t, func(ws *websocket.Conn) {
+		ws.SetPingHandler(func(string) error { return nil })
+	})
+	dead.auth(tok, "p1")
+
+	time.Sleep(600 * time.Millisecond)
+	dead.expectClose(websocket.CloseAbnormalClosure)
+	s.publish(t, "editor-events:p1", `{}`)
+	alive.next("event")
+}
+
+func TestShutdown(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := s.dial(t, nil)
+	c.auth(token(t, "u1", "p1"), "p1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.sockets.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	c.expectClose(websocket.CloseServiceRestart)
+
+	// new connections are asked to come back later too
+	c = s.dial(t, nil)
+	c.expectClose(websocket.CloseServiceRestart)
+}
@@ -247,15 +247,19 @@ export default {
         const client = await import('./WebProfileClient.mjs')
         const res = await client.listTokens(userId)
         if (res) {
-          // Map/respect returned fields (assuming webprofile returns similar docs)
+          // webprofile list DTOs carry no hash material; the public
+          // structured tokenId is the display prefix for ogt_ tokens
           return (Array.isArray(res) ? res : []).map(t => ({
             id: t.id || (t._id && t._id.toString && t._id.toString()),
+            tokenId: t.tokenId || null,
             label: t.label,
             scopes: t.scopes || [],
             active: typeof t.active === 'boolean' ? t.active : true,
-            hashPrefix: t.hashPrefix || t.accessTokenPartial || null,
+            expired: t.expired === true,
+            hashPrefix: null,
             createdAt: t.createdAt || null,
             expiresAt: t.expiresAt || null,
+            lastUsedAt: t.lastUsedAt || null,
           }))
         }
         // If webprofile returned no result, fall through to local
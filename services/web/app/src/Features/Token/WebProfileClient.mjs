@@ -1,8 +1,7 @@
+import crypto from 'node:crypto'
 import logger from '@overleaf/logger'
 
 const DEFAULT_BASE = process.env.AUTH_LOCAL_INTROSPECT_URL || 'http://localhost:3900'
-const BASIC_AUTH_USER = process.env.WEBPROFILE_ADMIN_USER || 'overleaf'
-const BASIC_AUTH_PASS = process.env.WEBPROFILE_ADMIN_PASS || 'overleaf'
 
 // Helper: perform fetch with a bounded timeout so tests and CI cannot hang
 // if a remote service or a test stub returns a promise that never resolves.
@@ -20,9 +19,35 @@ async function fetchWithTimeout (url, opts = {}, timeoutMs = Number(process.env.
   return Promise.race([fetchPromise, timeoutPromise])
 }
 
-function authHeader() {
-  const cred = Buffer.from(`${BASIC_AUTH_USER}:${BASIC_AUTH_PASS}`).toString('base64')
-  return `Basic ${cred}`
+// Requests to webprofile-api are HMAC-signed with SERVICE_AUTH_KEY_ID /
+// SERVICE_AUTH_SECRET (see docs/service-auth.md). The canonical string must
+// match git-bridge's internal/svcauth: method, escaped path plus query,
+// timestamp, nonce and hex sha256 of the body, newline separated.
+// WEBPROFILE_ADMIN_USER / WEBPROFILE_ADMIN_PASS Basic auth is only sent when
+// signing is not configured and both are set explicitly (dev/CI).
+function authHeaders(method, url, body = '') {
+  const keyId = process.env.SERVICE_AUTH_KEY_ID
+  const secret = process.env.SERVICE_AUTH_SECRET
+  if (keyId && secret) {
+    const { pathname, search } = new URL(url)
+    const timestamp = String(Math.floor(Date.now() / 1000))
+    const nonce = crypto.randomBytes(16).toString('hex')
+    const bodyHash = crypto.createHash('sha256').update(body).digest('hex')
+    const canonical = [method, pathname + search, timestamp, nonce, bodyHash].join('\n')
+    return {
+      'X-Service-Key-Id': keyId,
+      'X-Service-Timestamp': timestamp,
+      'X-Service-Nonce': nonce,
+      'X-Service-Signature': crypto.createHmac('sha256', secret).update(canonical).digest('hex'),
+    }
+  }
+  const user = process.env.WEBPROFILE_ADMIN_USER
+  const pass = process.env.WEBPROFILE_ADMIN_PASS
+  if (user && pass) {
+    return { Authorization: `Basic ${Buffer.from(`${user}:${pass}`).toString('base64')}` }
+  }
+  logger.warn({ url }, 'webprofile request has no credentials; set SERVICE_AUTH_KEY_ID and SERVICE_AUTH_SECRET')
+  return {}
 }
 
 export async function introspect(token) {
@@ -30,13 +55,14 @@ export async function introspect(token) {
   try {
     let res
     try {
+      const body = JSON.stringify({ token })
       res = await fetchWithTimeout(url, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          Authorization: authHeader(),
+          ...authHeaders('POST', url, body),
         },
-        body: JSON.stringify({ token }),
+        body,
       })
     } catch (err) {
       logger.err({ err }, 'webprofile introspect call failed (timeout or network)')
@@ -59,13 +85,14 @@ export async function createToken(userId, payload) {
   try {
     let res
     try {
+      const body = JSON.stringify(payload)
       res = await fetchWithTimeout(url, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          Authorization: authHeader(),
+          ...authHeaders('POST', url, body),
         },
-        body: JSON.stringify(payload),
+        body,
       })
     } catch (err) {
       logger.err({ err }, 'webprofile create token call failed (timeout or network)')
@@ -79,21 +106,39 @@ export async function createToken(userId, payload) {
   }
 }
 
-export async function listTokens(userId) {
-  const url = `${DEFAULT_BASE.replace(/\/$/, '')}/internal/api/users/${encodeURIComponent(userId)}/git-tokens`
-  try {
+// List endpoints return one page per call and the cursor for the next page in
+// the X-Next-Cursor header. Callers need the full list, so follow the cursor
+// with the largest page size webprofile-api allows.
+const LIST_PAGE_SIZE = 500
+
+async function listAllPages(baseUrl, what) {
+  const items = []
+  let cursor = null
+  do {
+    const url = `${baseUrl}?limit=${LIST_PAGE_SIZE}${cursor ? `&cursor=${encodeURIComponent(cursor)}` : ''}`
     let res
     try {
       res = await fetchWithTimeout(url, {
         method: 'GET',
-        headers: { Authorization: authHeader() },
+        headers: authHeaders('GET', url),
       })
     } catch (err) {
-      logger.err({ err }, 'webprofile list tokens call failed (timeout or network)')
+      logger.err({ err }, `webprofile list ${what} call failed (timeout or network)`)
       return null
     }
     if (res.status !== 200) return null
-    return await res.json()
+    const page = await res.json()
+    if (!Array.isArray(page)) return null
+    items.push(...page)
+    cursor = res.headers && typeof res.headers.get === 'function' ? res.headers.get('X-Next-Cursor') : null
+  } while (cursor)
+  return items
+}
+
+export async function listTokens(userId) {
+  const url = `${DEFAULT_BASE.replace(/\/$/, '')}/internal/api/users/${encodeURIComponent(userId)}/git-tokens`
+  try {
+    return await listAllPages(url, 'tokens')
   } catch (err) {
     logger.err({ err }, 'webprofile list tokens call failed')
     return null
@@ -105,7 +150,7 @@ export async function revokeToken(userId, tokenId) {
   try {
     let res
     try {
-      res = await fetchWithTimeout(url, { method: 'DELETE', headers: { Authorization: authHeader() } })
+      res = await fetchWithTimeout(url, { method: 'DELETE', headers: authHeaders('DELETE', url) })
     } catch (err) {
       logger.err({ err }, 'webprofile revoke call failed (timeout or network)')
       return false
@@ -134,10 +179,11 @@ export async function createSSHKey(userId, { public_key, key_name }) {
   try {
     let res
     try {
+      const body = JSON.stringify({ public_key, key_name })
       res = await fetchWithTimeout(url, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json', Authorization: authHeader() },
-        body: JSON.stringify({ public_key, key_name }),
+        headers: { 'Content-Type': 'application/json', ...authHeaders('POST', url, body) },
+        body,
       })
     } catch (err) {
       logger.err({ err }, 'webprofile create ssh key call failed (timeout or network)')
@@ -154,18 +200,7 @@ export async function createSSHKey(userId, { public_key, key_name }) {
 export async function listSSHKeys(userId) {
   const url = `${DEFAULT_BASE.replace(/\/$/, '')}/internal/api/users/${encodeURIComponent(userId)}/ssh-keys`
   try {
-    let res
-    try {
-      res = await fetchWithTimeout(url, {
-        method: 'GET',
-        headers: { Authorization: authHeader() },
-      })
-    } catch (err) {
-      logger.err({ err }, 'webprofile list ssh keys call failed (timeout or network)')
-      return null
-    }
-    if (res.status !== 200) return null
-    return await res.json()
+    return await listAllPages(url, 'ssh keys')
   } catch (err) {
     logger.err({ err }, 'webprofile list ssh keys call failed')
     return null
@@ -177,7 +212,7 @@ export async function removeSSHKey(userId, keyId) {
   try {
     let res
     try {
-      res = await fetchWithTimeout(url, { method: 'DELETE', headers: { Authorization: authHeader() } })
+      res = await fetchWithTimeout(url, { method: 'DELETE', headers: authHeaders('DELETE', url) })
     } catch (err) {
       logger.err({ err }, 'webprofile remove ssh key call failed (timeout or network)')
       return false
@@ -195,7 +230,7 @@ export async function getSSHKeyByFingerprint(fingerprint) {
   try {
     let res
     try {
-      res = await fetchWithTimeout(url, { method: 'GET', headers: { Authorization: authHeader(), Accept: 'application/json' } })
+      res = await fetchWithTimeout(url, { method: 'GET', headers: { ...authHeaders('GET', url), Accept: 'application/json' } })
     } catch (err) {
       logger.err({ err }, 'webprofile fingerprint lookup call failed (timeout or network)')
       return undefined
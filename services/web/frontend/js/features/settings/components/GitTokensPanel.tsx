@@ -8,7 +8,8 @@ type Token = {
   label?: string;
   scopes?: string[];
   active?: boolean;
-  hashPrefix?: string;
+  hashPrefix?: string | null;
+  tokenId?: string | null;
   createdAt?: string;
   expiresAt?: string | null;
 }
@@ -139,7 +140,7 @@ export default function GitTokensPanel({ userId }: { userId?: string | null }){
               <tr key={t.id}>
                 <td>{t.label}</td>
                 <td>{(t.scopes||[]).join(', ')}</td>
-                <td>{t.hashPrefix}</td>
+                <td>{t.tokenId || t.hashPrefix}</td>
                 <td>{t.createdAt}</td>
                 <td><button onClick={()=>revokeToken(t.id)}>Revoke</button></td>
               </tr>
@@ -0,0 +1,65 @@
+import { expect } from 'chai'
+
+const base = process.env.TARGET_BASE_URL || 'http://localhost:3900'
+
+/**
+ * Drives WebProfileClient against a running webprofile-api with HMAC service
+ * auth on. The shim must be started with SERVICE_AUTH_KEYS containing
+ * SERVICE_AUTH_KEY_ID:SERVICE_AUTH_SECRET. Basic credentials are cleared from
+ * this process, so every request below is signed and a signing mismatch shows
+ * up as a rejected call. The webprofile-api integration_test.sh starts the
+ * shim that way and runs this.
+ */
+
+describe('WebProfileClient signed requests (Go shim)', function () {
+  this.timeout(30 * 1000)
+
+  let Client
+  before(async function () {
+    if (!process.env.SERVICE_AUTH_KEY_ID || !process.env.SERVICE_AUTH_SECRET) {
+      this.skip()
+    }
+    delete process.env.WEBPROFILE_ADMIN_USER
+    delete process.env.WEBPROFILE_ADMIN_PASS
+    process.env.AUTH_LOCAL_INTROSPECT_URL = base
+    Client = await import('../../../app/src/Features/Token/WebProfileClient.mjs')
+  })
+
+  it('creates, lists, introspects and revokes a token', async function () {
+    const userId = `signed-client-${Date.now()}`
+
+    const created = await Client.createToken(userId, { label: 'signed', scopes: ['repo:read'] })
+    expect(created, 'create was rejected').to.be.an('object')
+    expect(created.token).to.be.a('string')
+
+    const listed = await Client.listTokens(userId)
+    expect(listed, 'list was rejected').to.be.an('array')
+    expect(listed.map(t => String(t.id))).to.include(String(created.id))
+
+    const active = await Client.introspect(created.token)
+    expect(active && active.active).to.equal(true)
+
+    expect(await Client.revokeToken(userId, created.id)).to.equal(true)
+    const revoked = await Client.introspect(created.token)
+    expect(revoked && revoked.active).to.equal(false)
+  })
+
+  it('creates, looks up and removes an SSH key', async function () {
+    const userId = `signed-client-${Date.now()}`
+    const publicKey = 'ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGq1mB0L3LtW0cPjzqR8uGa0lq0m4A3b5o0k1i0Uu9Xy signed@test'
+
+    const created = await Client.createSSHKey(userId, { public_key: publicKey, key_name: 'signed' })
+    expect(created, 'create ssh key was rejected').to.be.an('object')
+    expect(created.fingerprint).to.be.a('string')
+
+    const listed = await Client.listSSHKeys(userId)
+    expect(listed, 'list ssh keys was rejected').to.be.an('array')
+
+    const found = await Client.getSSHKeyByFingerprint(created.fingerprint)
+    expect(found && found.userId).to.equal(userId)
+
+    expect(await Client.removeSSHKey(userId, created.id)).to.equal(true)
+    const gone = await Client.getSSHKeyByFingerprint(created.fingerprint)
+    expect(gone).to.deep.equal({ notFound: true })
+  })
+})
@@ -28,11 +28,31 @@ describe('WebProfile delegation (create/list/revoke)', () => {
   it('delegates listTokens to WebProfileClient', async () => {
     vi.stubGlobal('fetch', async (url, opts) => ({
       status: 200,
-      async json() { return [{ id: 'r1', label: 'lab', scopes: [], active: true, hashPrefix: 'abcd' }] }
+      headers: new Headers(),
+      async json() { return [{ id: 'r1', tokenId: '0123456789abcdef', label: 'lab', scopes: [], active: true, expired: false, createdAt: '2025-01-01T00:00:00Z', usageCount: 0 }] }
     }))
     const PAM = await import('../../../../../app/src/Features/Token/PersonalAccessTokenManager.mjs')
     const res = await PAM.default.listTokens('69475e1ac65798f7cab0c5f7')
-    expect(res).toEqual([{ id: 'r1', label: 'lab', scopes: [], active: true, hashPrefix: 'abcd', createdAt: null, expiresAt: null }])
+    expect(res).toEqual([{ id: 'r1', tokenId: '0123456789abcdef', label: 'lab', scopes: [], active: true, expired: false, hashPrefix: null, createdAt: '2025-01-01T00:00:00Z', expiresAt: null, lastUsedAt: null }])
+  })
+
+  it('follows X-Next-Cursor until the last page', async () => {
+    const urls = []
+    vi.stubGlobal('fetch', async (url, opts) => {
+      urls.push(url)
+      const cursor = new URL(url).searchParams.get('cursor')
+      const page = cursor === null ? 1 : Number(cursor)
+      return {
+        status: 200,
+        headers: new Headers(page < 3 ? { 'X-Next-Cursor': String(page + 1) } : {}),
+        async json() { return [{ id: `r${page}`, label: 'lab', scopes: [], active: true, expired: false, usageCount: 0 }] }
+      }
+    })
+    const PAM = await import('../../../../../app/src/Features/Token/PersonalAccessTokenManager.mjs')
+    const res = await PAM.default.listTokens('69475e1ac65798f7cab0c5f7')
+    expect(res.map(t => t.id)).toEqual(['r1', 'r2', 'r3'])
+    expect(urls).toHaveLength(3)
+    expect(new URL(urls[1]).searchParams.get('cursor')).toEqual('2')
   })
 
   it('delegates revokeToken to WebProfileClient', async () => {
@@ -0,0 +1,90 @@
+import crypto from 'node:crypto'
+import { vi, describe, it, expect, beforeEach, afterEach } from 'vitest'
+
+const ENV_KEYS = ['SERVICE_AUTH_KEY_ID', 'SERVICE_AUTH_SECRET', 'WEBPROFILE_ADMIN_USER', 'WEBPROFILE_ADMIN_PASS']
+
+describe('WebProfileClient request signing', () => {
+  let origEnv
+  beforeEach(() => {
+    origEnv = Object.fromEntries(ENV_KEYS.map(k => [k, process.env[k]]))
+    for (const k of ENV_KEYS) delete process.env[k]
+    vi.resetModules()
+  })
+  afterEach(() => {
+    for (const k of ENV_KEYS) {
+      if (origEnv[k] === undefined) delete process.env[k]
+      else process.env[k] = origEnv[k]
+    }
+    vi.unstubAllGlobals()
+    vi.restoreAllMocks()
+  })
+
+  function captureFetch(response) {
+    const calls = []
+    vi.stubGlobal('fetch', async (url, opts) => {
+      calls.push({ url, opts })
+      return response
+    })
+    return calls
+  }
+
+  it('signs requests with the svcauth canonical string', async () => {
+    process.env.SERVICE_AUTH_KEY_ID = 'web'
+    process.env.SERVICE_AUTH_SECRET = 's3cret'
+    const calls = captureFetch({ status: 200, async json() { return { active: true } } })
+    const Client = await import('../../../../../app/src/Features/Token/WebProfileClient.mjs')
+
+    await Client.introspect('ogt_tok')
+
+    const { url, opts } = calls[0]
+    const h = opts.headers
+    expect(h.Authorization).to.equal(undefined)
+    expect(h['X-Service-Key-Id']).to.equal('web')
+    const canonical = [
+      'POST',
+      new URL(url).pathname,
+      h['X-Service-Timestamp'],
+      h['X-Service-Nonce'],
+      crypto.createHash('sha256').update(opts.body).digest('hex'),
+    ].join('\n')
+    const expected = crypto.createHmac('sha256', 's3cret').update(canonical).digest('hex')
+    expect(h['X-Service-Signature']).to.equal(expected)
+  })
+
+  it('signs the escaped path and query of GET requests over an empty body', async () => {
+    process.env.SERVICE_AUTH_KEY_ID = 'web'
+    process.env.SERVICE_AUTH_SECRET = 's3cret'
+    const calls = captureFetch({ status: 404, async json() { return {} } })
+    const Client = await import('../../../../../app/src/Features/Token/WebProfileClient.mjs')
+
+    await Client.getSSHKeyByFingerprint('SHA256:a/b+c')
+
+    const { url, opts } = calls[0]
+    const h = opts.headers
+    const { pathname, search } = new URL(url)
+    expect(pathname).to.contain('SHA256%3Aa%2Fb%2Bc')
+    const canonical = ['GET', pathname + search, h['X-Service-Timestamp'], h['X-Service-Nonce'], crypto.createHash('sha256').update('').digest('hex')].join('\n')
+    expect(h['X-Service-Signature']).to.equal(crypto.createHmac('sha256', 's3cret').update(canonical).digest('hex'))
+  })
+
+  it('sends no default Basic credentials', async () => {
+    const calls = captureFetch({ status: 302, async json() { return {} } })
+    const Client = await import('../../../../../app/src/Features/Token/WebProfileClient.mjs')
+
+    await Client.introspect('ogt_tok')
+
+    expect(calls[0].opts.headers.Authorization).to.equal(undefined)
+    expect(calls[0].opts.headers['X-Service-Signature']).to.equal(undefined)
+  })
+
+  it('falls back to explicitly configured Basic auth when signing is not configured', async () => {
+    process.env.WEBPROFILE_ADMIN_USER = 'dev'
+    process.env.WEBPROFILE_ADMIN_PASS = 'devpass'
+    const calls = captureFetch({ status: 204 })
+    const Client = await import('../../../../../app/src/Features/Token/WebProfileClient.mjs')
+
+    await Client.revokeToken('u1', 't1')
+
+    expect(calls[0].opts.headers.Authorization).to.equal('Basic ' + Buffer.from('dev:devpass').toString('base64'))
+  })
+})